@@ -2,14 +2,19 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"log/slog"
 	"net/http"
-	"os"
 	"strings"
+	"time"
 
 	firebase "firebase.google.com/go/v4"
 	"firebase.google.com/go/v4/auth"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/api/option"
 )
 
@@ -17,20 +22,19 @@ var firebaseApp *firebase.App
 var authClient *auth.Client
 
 // Initialize Firebase Admin SDK
-func InitFirebase() error {
-	var opt option.ClientOption
-
-	// Check if we're running in production (Render) with credentials in env var
-	firebaseCredentials := os.Getenv("FIREBASE_CREDENTIALS")
-	if firebaseCredentials != "" {
-		// Use credentials from environment variable
-		opt = option.WithCredentialsJSON([]byte(firebaseCredentials))
-	} else {
-		// Fallback to file for local development
-		opt = option.WithCredentialsFile("brocab-1c545-firebase-adminsdk-fbsvc-e3e6893c15.json")
+func InitFirebase(cfg FirebaseConfig) error {
+	var opts []option.ClientOption
+
+	if cfg.Credentials != "" {
+		// Production (Render) passes the service account JSON directly
+		opts = append(opts, option.WithCredentialsJSON([]byte(cfg.Credentials)))
+	} else if cfg.CredentialsFile != "" {
+		// Local development points at the downloaded service account file
+		opts = append(opts, option.WithCredentialsFile(cfg.CredentialsFile))
 	}
+	// Otherwise fall back to Application Default Credentials (GOOGLE_APPLICATION_CREDENTIALS)
 
-	app, err := firebase.NewApp(context.Background(), nil, opt)
+	app, err := firebase.NewApp(context.Background(), nil, opts...)
 	if err != nil {
 		return fmt.Errorf("error initializing firebase app: %v", err)
 	}
@@ -42,39 +46,82 @@ func InitFirebase() error {
 
 	firebaseApp = app
 	authClient = client
-	fmt.Println("✅ Firebase initialized")
+	slog.Info("✅ Firebase initialized")
 	return nil
 }
 
+// Verified tokens are cached until they expire, keyed by their hash so raw tokens aren't kept.
+// VerifyIDToken doesn't check for revocation, so this accepts exactly the tokens it would.
+var tokenCache = newMeteredCache[*auth.Token]("token", NewMemoryCache[*auth.Token](0))
+
+// verifyFirebaseIDToken asks Firebase to check a token; tests replace it
+var verifyFirebaseIDToken = func(ctx context.Context, idToken string) (*auth.Token, error) {
+	return authClient.VerifyIDToken(ctx, idToken)
+}
+
+// verifyIDToken checks a Firebase ID token, using the cache when the token was seen before
+func verifyIDToken(ctx context.Context, idToken string) (*auth.Token, error) {
+	sum := sha256.Sum256([]byte(idToken))
+	key := hex.EncodeToString(sum[:])
+	if token, ok := tokenCache.Get(key); ok && time.Now().Before(time.Unix(token.Expires, 0)) {
+		return token, nil
+	}
+
+	ctx, span := tracer.Start(ctx, "firebase.VerifyIDToken")
+	defer span.End()
+
+	token, err := verifyFirebaseIDToken(ctx, idToken)
+	if err != nil {
+		recordSpanError(span, err)
+		return nil, err
+	}
+	tokenCache.Set(key, token, time.Until(time.Unix(token.Expires, 0)))
+	return token, nil
+}
+
+// OptionalFirebaseAuth identifies the user on public routes that personalize their response.
+// Requests without a valid token continue anonymously instead of being rejected.
+func OptionalFirebaseAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authHeader := c.GetHeader("Authorization")
+		if idToken, ok := strings.CutPrefix(authHeader, "Bearer "); ok && idToken != "" {
+			if token, err := verifyIDToken(c.Request.Context(), idToken); err == nil {
+				c.Set("uid", token.UID)
+				setLogUID(c, token.UID)
+			}
+		}
+		c.Next()
+	}
+}
+
 // Middleware to verify Firebase token
 func FirebaseAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get the Authorization header
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header missing"})
-			c.Abort()
+			abortWithError(c, http.StatusUnauthorized, CodeAuthHeaderMissing, "Authorization header missing")
 			return
 		}
 
 		// Format: "Bearer <token>"
 		idToken := strings.TrimPrefix(authHeader, "Bearer ")
 		if idToken == authHeader {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header format"})
-			c.Abort()
+			abortWithError(c, http.StatusUnauthorized, CodeAuthHeaderInvalid, "Invalid authorization header format")
 			return
 		}
 
 		// Verify token
-		token, err := authClient.VerifyIDToken(context.Background(), idToken)
+		token, err := verifyIDToken(c.Request.Context(), idToken)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
-			c.Abort()
+			abortWithError(c, http.StatusUnauthorized, CodeAuthTokenInvalid, "Invalid or expired token")
 			return
 		}
 
 		// Store UID in context
+		trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.String("enduser.id", token.UID))
 		c.Set("uid", token.UID)
+		setLogUID(c, token.UID)
 		c.Next()
 	}
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"firebase.google.com/go/v4/auth"
+)
+
+// fakeFirebase answers token checks with a token expiring at expires, counting the calls
+func fakeFirebase(t *testing.T, expires time.Time) *int {
+	t.Helper()
+
+	calls := 0
+	prevVerify, prevCache := verifyFirebaseIDToken, tokenCache
+	verifyFirebaseIDToken = func(ctx context.Context, idToken string) (*auth.Token, error) {
+		calls++
+		return &auth.Token{UID: "uid-" + idToken, Expires: expires.Unix()}, nil
+	}
+	tokenCache = NewMemoryCache[*auth.Token](100)
+	t.Cleanup(func() { verifyFirebaseIDToken, tokenCache = prevVerify, prevCache })
+	return &calls
+}
+
+func TestVerifyIDTokenCache(t *testing.T) {
+	expires := time.Now().Add(time.Hour)
+	calls := fakeFirebase(t, expires)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if token, err := verifyIDToken(ctx, "token-a"); err != nil || token.UID != "uid-token-a" {
+			t.Fatalf("verify = %v, %v", token, err)
+		}
+	}
+	if *calls != 1 {
+		t.Fatalf("Firebase checked the token %d times, want once", *calls)
+	}
+}
+
+func TestVerifyIDTokenCacheStopsAtExpiry(t *testing.T) {
+	expires := time.Now().Add(-time.Second)
+	calls := fakeFirebase(t, expires)
+	ctx := context.Background()
+
+	// A token past its exp isn't cached, whatever the cache's own TTL
+	verifyIDToken(ctx, "token-a")
+	verifyIDToken(ctx, "token-a")
+	if *calls != 2 {
+		t.Fatalf("Firebase checked an expired token %d times, want every time", *calls)
+	}
+
+	// Nor is an entry used once its token expires, even if the cache still holds it
+	sum := sha256.Sum256([]byte("token-b"))
+	tokenCache.Set(hex.EncodeToString(sum[:]), &auth.Token{UID: "uid-stale", Expires: expires.Unix()}, time.Hour)
+	if token, _ := verifyIDToken(ctx, "token-b"); token.UID != "uid-token-b" || *calls != 3 {
+		t.Fatalf("got %q after %d checks, want the expired entry checked again", token.UID, *calls)
+	}
+}
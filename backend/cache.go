@@ -0,0 +1,122 @@
+package main
+
+import (
+	"container/list"
+	"log/slog"
+	"sync"
+	"time"
+
+	"firebase.google.com/go/v4/auth"
+)
+
+// Cache holds values for a limited time. The in-memory LRU only serves a single instance;
+// a shared store (e.g. Redis) can implement this interface when the backend is scaled out.
+type Cache[V any] interface {
+	Get(key string) (V, bool)
+	Set(key string, value V, ttl time.Duration)
+	Delete(key string)
+}
+
+type cacheEntry[V any] struct {
+	key     string
+	value   V
+	expires time.Time
+}
+
+// MemoryCache is an LRU cache in process memory, holding at most size entries.
+// A size of 0 disables it: nothing is stored and every Get misses.
+type MemoryCache[V any] struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // Most recently used first
+	entries map[string]*list.Element
+}
+
+func NewMemoryCache[V any](size int) *MemoryCache[V] {
+	return &MemoryCache[V]{size: size, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (c *MemoryCache[V]) Get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+	el, ok := c.entries[key]
+	if !ok {
+		return zero, false
+	}
+	entry := el.Value.(*cacheEntry[V])
+	if time.Now().After(entry.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return zero, false
+	}
+	c.order.MoveToFront(el)
+	return entry.value, true
+}
+
+func (c *MemoryCache[V]) Set(key string, value V, ttl time.Duration) {
+	if c.size <= 0 || ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(ttl)
+	if el, ok := c.entries[key]; ok {
+		el.Value = &cacheEntry[V]{key: key, value: value, expires: expires}
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry[V]{key: key, value: value, expires: expires})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry[V]).key)
+	}
+}
+
+func (c *MemoryCache[V]) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.order.Remove(el)
+		delete(c.entries, key)
+	}
+}
+
+// meteredCache counts hits and misses of the cache it wraps in brocab_cache_requests_total
+type meteredCache[V any] struct {
+	Cache[V]
+	name string
+}
+
+func newMeteredCache[V any](name string, cache Cache[V]) Cache[V] {
+	return meteredCache[V]{Cache: cache, name: name}
+}
+
+func (c meteredCache[V]) Get(key string) (V, bool) {
+	value, ok := c.Cache.Get(key)
+	result := "miss"
+	if ok {
+		result = "hit"
+	}
+	cacheRequestsTotal.WithLabelValues(c.name, result).Inc()
+	return value, ok
+}
+
+// InitCaches sizes the user, token, place and ride search caches. Until it runs they store nothing.
+func InitCaches(cfg CacheConfig) {
+	userCache = newMeteredCache[User]("user", NewMemoryCache[User](cfg.UserSize))
+	userCacheTTL = cfg.UserTTL
+	tokenCache = newMeteredCache[*auth.Token]("token", NewMemoryCache[*auth.Token](cfg.TokenSize))
+	placeLookupCache = newMeteredCache[uint]("place", NewMemoryCache[uint](cfg.PlaceSize))
+	placeLookupTTL, placeMissTTL = cfg.PlaceTTL, cfg.PlaceMissTTL
+	rideSearchCache = newMeteredCache[rideSearchPages]("ride_search", NewMemoryCache[rideSearchPages](cfg.RideSearchSize))
+	rideSearchCacheTTL = cfg.RideSearchTTL
+	slog.Info("ℹ️  Caches ready", "users", cfg.UserSize, "user_ttl", cfg.UserTTL.String(), "tokens", cfg.TokenSize,
+		"places", cfg.PlaceSize, "place_ttl", cfg.PlaceTTL.String(), "place_miss_ttl", cfg.PlaceMissTTL.String(), "ride_searches", cfg.RideSearchSize, "ride_search_ttl", cfg.RideSearchTTL.String())
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm/clause"
+)
+
+// NotificationChannel delivers a notification to a user through one medium (in-app feed, email, push...)
+type NotificationChannel interface {
+	Name() string
+	Send(ctx context.Context, n *Notification) error
+}
+
+// Registered delivery channels, keyed by name
+var notificationChannels = map[string]NotificationChannel{}
+
+// RegisterNotificationChannel makes a channel available to user preferences
+func RegisterNotificationChannel(ch NotificationChannel) {
+	notificationChannels[ch.Name()] = ch
+}
+
+// availableChannelNames lists registered channels in a stable order
+func availableChannelNames() []string {
+	names := make([]string, 0, len(notificationChannels))
+	for name := range notificationChannels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// InAppChannel stores the notification so it shows up in the user's notification feed.
+// Rows are unique per outbox event, so redelivering an event never duplicates it in the feed.
+type InAppChannel struct{}
+
+func (InAppChannel) Name() string { return "in_app" }
+
+func (InAppChannel) Send(ctx context.Context, n *Notification) error {
+	return DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(n).Error
+}
+
+func init() {
+	RegisterNotificationChannel(InAppChannel{})
+}
+
+// dispatchNotification routes a notification according to the recipient's preferences:
+// dropped if the type is disabled, held for the digest during quiet hours, otherwise
+// sent on every channel the user selected for this type. Channels in delivered are skipped,
+// and each channel that succeeds is added to it.
+func dispatchNotification(ctx context.Context, n Notification, delivered map[string]bool) (err error) {
+	ctx, span := tracer.Start(ctx, "notification.dispatch", trace.WithAttributes(
+		attribute.String("notification.type", n.Type),
+		attribute.String("enduser.id", n.UserID),
+	))
+	defer func() {
+		if err != nil {
+			recordSpanError(span, err)
+		}
+		span.End()
+	}()
+
+	pref, settings, err := loadNotificationPreference(ctx, n.UserID, n.Type)
+	if err != nil {
+		return err
+	}
+
+	if !pref.Enabled {
+		return nil
+	}
+
+	var channels []string
+	for _, name := range pref.channelList() {
+		if !delivered[name] {
+			channels = append(channels, name)
+		}
+	}
+
+	if !isUrgentNotification(n.Type) && settings.inQuietHours(time.Now()) {
+		if err := queueDigestEntries(ctx, n, channels); err != nil {
+			return err
+		}
+		for _, name := range channels {
+			delivered[name] = true
+		}
+		return nil
+	}
+
+	var errs []error
+	for _, name := range channels {
+		ch, ok := notificationChannels[name]
+		if !ok {
+			continue // channel no longer registered
+		}
+		sent := n // each channel gets its own copy
+		if err := sendOnChannel(ctx, ch, &sent); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		delivered[name] = true
+	}
+	return errors.Join(errs...)
+}
+
+// sendOnChannel sends n on ch in its own span
+func sendOnChannel(ctx context.Context, ch NotificationChannel, n *Notification) error {
+	ctx, span := tracer.Start(ctx, "notification.send", trace.WithAttributes(
+		attribute.String("notification.channel", ch.Name()),
+		attribute.String("notification.type", n.Type),
+	))
+	defer span.End()
+
+	err := ch.Send(ctx, n)
+	if err != nil {
+		recordSpanError(span, err)
+	}
+	return err
+}
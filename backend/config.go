@@ -0,0 +1,336 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// Config is every setting the backend reads, loaded once at startup by LoadConfig.
+//
+// Each field is named by its env tag. Values are layered, later ones winning:
+// the default tag, the config file (.env format), the environment, then command-line flags
+// (the env name in lower case with dashes, e.g. -postgres-host). Fields tagged secret are
+// redacted when the config is printed.
+type Config struct {
+	Env  string `env:"APP_ENV" default:"development" usage:"development or production"`
+	Port string `env:"PORT" default:"8080" usage:"HTTP listen port"`
+
+	Database      DatabaseConfig
+	Firebase      FirebaseConfig
+	HTTP          HTTPConfig
+	SMTP          SMTPConfig
+	Notifications NotificationConfig
+	Log           LogConfig
+	Tracing       TracingConfig
+	Cache         CacheConfig
+
+	RateLimits     string `env:"RATE_LIMITS" usage:"Rate limit overrides, e.g. add_ride=10/h:3,filter_rides=120/m"`
+	RankingWeights string `env:"RANKING_WEIGHTS" usage:"Ride search relevance weight overrides, e.g. time=3,price=2,seats=1,leader=1,gender=1"`
+}
+
+type DatabaseConfig struct {
+	URL      string `env:"DATABASE_URL" secret:"true" usage:"Postgres connection URL; overrides the POSTGRES_* settings"`
+	Host     string `env:"POSTGRES_HOST" default:"aws-0-ap-south-1.pooler.supabase.com"`
+	Port     string `env:"POSTGRES_PORT" default:"6543" usage:"6543 is the Supabase transaction pooler"`
+	User     string `env:"POSTGRES_USER" default:"postgres.lwghhgzhlyrourvbssjk"`
+	Password string `env:"POSTGRES_PASSWORD" secret:"true"`
+	Name     string `env:"POSTGRES_DB" default:"postgres"`
+
+	// Zero values pick a default suited to the connection type
+	MaxOpenConns    int           `env:"DB_MAX_OPEN_CONNS" usage:"0 picks a default for the connection type"`
+	MaxIdleConns    int           `env:"DB_MAX_IDLE_CONNS" usage:"0 picks a default for the connection type"`
+	ConnMaxLifetime time.Duration `env:"DB_CONN_MAX_LIFETIME" usage:"0 picks a default for the connection type"`
+	ConnMaxIdleTime time.Duration `env:"DB_CONN_MAX_IDLE_TIME" usage:"0 picks a default for the connection type"`
+	QueryTimeout    time.Duration `env:"DB_QUERY_TIMEOUT" default:"5s" usage:"Deadline for each database statement; 0 disables it"`
+}
+
+type FirebaseConfig struct {
+	Credentials     string `env:"FIREBASE_CREDENTIALS" secret:"true" usage:"Service account JSON"`
+	CredentialsFile string `env:"FIREBASE_CREDENTIALS_FILE" usage:"Path to a service account JSON file, used when FIREBASE_CREDENTIALS is empty"`
+}
+
+type HTTPConfig struct {
+	CORSOrigins          []string      `env:"CORS_ALLOWED_ORIGINS" default:"*" usage:"Comma-separated allowed origins; https://*.example.com allows any subdomain"`
+	CORSAllowCredentials bool          `env:"CORS_ALLOW_CREDENTIALS" default:"false" usage:"Let browsers send cookies and HTTP auth cross-origin"`
+	TrustedProxies       []string      `env:"TRUSTED_PROXIES" default:"127.0.0.1,::1" usage:"Comma-separated proxy IPs or CIDRs trusted for the client IP"`
+	ReadinessDrain       time.Duration `env:"READINESS_DRAIN" default:"5s" usage:"How long /readyz fails before shutdown stops accepting connections"`
+	ShutdownTimeout      time.Duration `env:"SHUTDOWN_TIMEOUT" default:"20s" usage:"How long shutdown waits for in-flight requests and workers"`
+	MetricsToken         string        `env:"METRICS_TOKEN" secret:"true" usage:"Bearer token required to scrape /metrics; open when empty"`
+}
+
+type SMTPConfig struct {
+	Host     string        `env:"SMTP_HOST" usage:"Email notifications are disabled when empty"`
+	Port     string        `env:"SMTP_PORT" default:"587"`
+	Username string        `env:"SMTP_USERNAME"`
+	Password string        `env:"SMTP_PASSWORD" secret:"true"`
+	From     string        `env:"SMTP_FROM" usage:"Defaults to SMTP_USERNAME"`
+	Timeout  time.Duration `env:"SMTP_TIMEOUT" default:"30s" usage:"Bound on sending one email, from connecting to QUIT"`
+}
+
+type LogConfig struct {
+	Level  string `env:"LOG_LEVEL" default:"info" usage:"debug, info, warn or error; debug includes every SQL statement"`
+	Format string `env:"LOG_FORMAT" default:"json" usage:"json or text"`
+}
+
+type TracingConfig struct {
+	Exporter    string  `env:"OTEL_TRACES_EXPORTER" default:"none" usage:"none, otlp or stdout"`
+	ServiceName string  `env:"OTEL_SERVICE_NAME" default:"brocab-backend"`
+	SampleRatio float64 `env:"OTEL_TRACES_SAMPLE_RATIO" default:"1" usage:"Fraction of new traces to sample, 0 to 1"`
+}
+
+type CacheConfig struct {
+	UserSize  int           `env:"USER_CACHE_SIZE" default:"10000" usage:"Users kept in memory; 0 disables the cache"`
+	UserTTL   time.Duration `env:"USER_CACHE_TTL" default:"5m" usage:"How long a cached user is used before it is read again"`
+	TokenSize int           `env:"TOKEN_CACHE_SIZE" default:"10000" usage:"Verified ID tokens kept in memory until they expire; 0 disables the cache"`
+
+	PlaceSize    int           `env:"PLACE_CACHE_SIZE" default:"10000" usage:"Place name lookups kept in memory; 0 disables the cache"`
+	PlaceTTL     time.Duration `env:"PLACE_CACHE_TTL" default:"1h" usage:"How long a name's place is remembered"`
+	PlaceMissTTL time.Duration `env:"PLACE_CACHE_MISS_TTL" default:"1m" usage:"How long a name matching no place is remembered"`
+
+	RideSearchSize int           `env:"RIDE_SEARCH_CACHE_SIZE" default:"1000" usage:"Ride search routes (origin, destination, date) kept in memory; 0 disables the cache"`
+	RideSearchTTL  time.Duration `env:"RIDE_SEARCH_CACHE_TTL" default:"30s" usage:"How long cached ride search results are served"`
+}
+
+type NotificationConfig struct {
+	RetentionDays int           `env:"NOTIFICATION_RETENTION_DAYS" default:"30" usage:"Days to keep read notifications"`
+	ReminderLead  time.Duration `env:"RIDE_REMINDER_LEAD" default:"1h" usage:"How long before departure riders are reminded; 0 disables reminders"`
+}
+
+// configField is a leaf setting found by walking Config
+type configField struct {
+	value reflect.Value
+	field reflect.StructField
+}
+
+func (f configField) name() string { return f.field.Tag.Get("env") }
+func (f configField) flagName() string {
+	return strings.ReplaceAll(strings.ToLower(f.name()), "_", "-")
+}
+func (f configField) secret() bool { return f.field.Tag.Get("secret") == "true" }
+
+// configFields lists the settings in declaration order
+func configFields(v reflect.Value) []configField {
+	var fields []configField
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.Type.Kind() == reflect.Struct {
+			fields = append(fields, configFields(v.Field(i))...)
+			continue
+		}
+		if field.Tag.Get("env") != "" {
+			fields = append(fields, configField{value: v.Field(i), field: field})
+		}
+	}
+	return fields
+}
+
+// set parses raw into the field according to its type
+func (f configField) set(raw string) error {
+	switch f.value.Interface().(type) {
+	case string:
+		f.value.SetString(raw)
+	case bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not true or false", f.name(), raw)
+		}
+		f.value.SetBool(b)
+	case int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a number", f.name(), raw)
+		}
+		f.value.SetInt(int64(n))
+	case float64:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a number", f.name(), raw)
+		}
+		f.value.SetFloat(n)
+	case time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a duration (e.g. 30s, 5m)", f.name(), raw)
+		}
+		f.value.SetInt(int64(d))
+	case []string:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		f.value.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("%s: unsupported config type %s", f.name(), f.value.Type())
+	}
+	return nil
+}
+
+func (f configField) String() string {
+	if f.secret() {
+		if f.value.IsZero() {
+			return ""
+		}
+		return "********"
+	}
+	if items, ok := f.value.Interface().([]string); ok {
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(f.value.Interface())
+}
+
+// LoadConfig builds the Config from defaults, the config file, the environment and args (usually os.Args[1:])
+func LoadConfig(args []string) (*Config, error) {
+	cfg := &Config{}
+	fields := configFields(reflect.ValueOf(cfg).Elem())
+
+	flags := flag.NewFlagSet("brocab", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "Config file in .env format (default .env if present)")
+	flagValues := make(map[string]*string, len(fields))
+	for _, f := range fields {
+		usage := f.field.Tag.Get("usage")
+		if usage == "" {
+			usage = f.name()
+		}
+		flagValues[f.name()] = flags.String(f.flagName(), "", usage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// The config file is optional unless one was asked for explicitly
+	fileValues := map[string]string{}
+	if *configFile != "" {
+		values, err := godotenv.Read(*configFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file %s: %v", *configFile, err)
+		}
+		fileValues = values
+	} else if values, err := godotenv.Read(".env"); err == nil {
+		fileValues = values
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read .env: %v", err)
+	}
+
+	setFlags := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+
+	for _, f := range fields {
+		raw, ok := f.field.Tag.Lookup("default")
+		if v, found := fileValues[f.name()]; found {
+			raw, ok = v, true
+		}
+		if v, found := os.LookupEnv(f.name()); found {
+			raw, ok = v, true
+		}
+		if setFlags[f.flagName()] {
+			raw, ok = *flagValues[f.name()], true
+		}
+		if !ok {
+			continue
+		}
+		if err := f.set(raw); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (cfg *Config) validate() error {
+	var errs []error
+
+	if cfg.Env != "development" && cfg.Env != "production" {
+		errs = append(errs, fmt.Errorf("APP_ENV must be development or production, got %q", cfg.Env))
+	}
+	if _, err := strconv.Atoi(cfg.Port); err != nil {
+		errs = append(errs, fmt.Errorf("PORT must be a number, got %q", cfg.Port))
+	}
+
+	if cfg.Database.URL == "" && cfg.Database.Password == "" {
+		errs = append(errs, errors.New("DATABASE_URL or POSTGRES_PASSWORD is required"))
+	}
+	if cfg.Database.MaxOpenConns < 0 || cfg.Database.MaxIdleConns < 0 ||
+		cfg.Database.ConnMaxLifetime < 0 || cfg.Database.ConnMaxIdleTime < 0 || cfg.Database.QueryTimeout < 0 {
+		errs = append(errs, errors.New("database pool and timeout settings cannot be negative"))
+	}
+
+	if err := cfg.HTTP.validate(cfg.Env == "production"); err != nil {
+		errs = append(errs, err)
+	}
+
+	if cfg.HTTP.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("SHUTDOWN_TIMEOUT must be positive"))
+	}
+	if cfg.HTTP.ReadinessDrain < 0 {
+		errs = append(errs, errors.New("READINESS_DRAIN cannot be negative"))
+	}
+
+	if cfg.SMTP.Host != "" {
+		if _, err := strconv.Atoi(cfg.SMTP.Port); err != nil {
+			errs = append(errs, fmt.Errorf("SMTP_PORT must be a number, got %q", cfg.SMTP.Port))
+		}
+	}
+
+	switch cfg.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Errorf("LOG_LEVEL must be debug, info, warn or error, got %q", cfg.Log.Level))
+	}
+	if cfg.Log.Format != "json" && cfg.Log.Format != "text" {
+		errs = append(errs, fmt.Errorf("LOG_FORMAT must be json or text, got %q", cfg.Log.Format))
+	}
+
+	switch cfg.Tracing.Exporter {
+	case "none", "otlp", "stdout":
+	default:
+		errs = append(errs, fmt.Errorf("OTEL_TRACES_EXPORTER must be none, otlp or stdout, got %q", cfg.Tracing.Exporter))
+	}
+	if cfg.Tracing.SampleRatio < 0 || cfg.Tracing.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("OTEL_TRACES_SAMPLE_RATIO must be between 0 and 1, got %v", cfg.Tracing.SampleRatio))
+	}
+
+	if cfg.Cache.UserSize < 0 || cfg.Cache.TokenSize < 0 || cfg.Cache.PlaceSize < 0 || cfg.Cache.RideSearchSize < 0 ||
+		cfg.Cache.UserTTL < 0 || cfg.Cache.PlaceTTL < 0 || cfg.Cache.PlaceMissTTL < 0 || cfg.Cache.RideSearchTTL < 0 {
+		errs = append(errs, errors.New("cache sizes and TTLs cannot be negative"))
+	}
+
+	if cfg.Notifications.RetentionDays < 0 {
+		errs = append(errs, errors.New("NOTIFICATION_RETENTION_DAYS cannot be negative"))
+	}
+	if cfg.Notifications.ReminderLead < 0 {
+		errs = append(errs, errors.New("RIDE_REMINDER_LEAD cannot be negative"))
+	}
+
+	if _, err := parseRateLimits(cfg.RateLimits); err != nil {
+		errs = append(errs, fmt.Errorf("RATE_LIMITS: %v", err))
+	}
+	if _, err := parseRankingWeights(cfg.RankingWeights); err != nil {
+		errs = append(errs, fmt.Errorf("RANKING_WEIGHTS: %v", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// LogValue lists every setting, with secrets redacted, for the startup log
+func (cfg *Config) LogValue() slog.Value {
+	fields := configFields(reflect.ValueOf(cfg).Elem())
+	attrs := make([]slog.Attr, 0, len(fields))
+	for _, f := range fields {
+		attrs = append(attrs, slog.String(f.name(), f.String()))
+	}
+	return slog.GroupValue(attrs...)
+}
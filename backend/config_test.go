@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// inConfigDir runs the test in a fresh directory holding dotenv as its .env file, with none of
+// the given variables set in the environment
+func inConfigDir(t *testing.T, dotenv string, unset ...string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ".env"), []byte(dotenv), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	for _, name := range append(unset, "CONFIG_FILE") {
+		t.Setenv(name, "") // Restores the variable when the test ends
+		os.Unsetenv(name)
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	inConfigDir(t, "POSTGRES_PASSWORD=secret\nPOSTGRES_DB=from_file\nPOSTGRES_HOST=from_file\nPOSTGRES_PORT=1111\n",
+		"POSTGRES_USER", "POSTGRES_DB", "POSTGRES_PASSWORD", "DATABASE_URL", "APP_ENV", "CORS_ALLOWED_ORIGINS")
+	t.Setenv("POSTGRES_HOST", "from_env")
+	t.Setenv("POSTGRES_PORT", "2222")
+
+	cfg, err := LoadConfig([]string{"-postgres-port", "3333"})
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+
+	got := cfg.Database
+	if got.User != "postgres.lwghhgzhlyrourvbssjk" || got.Name != "from_file" || got.Host != "from_env" || got.Port != "3333" {
+		t.Fatalf("user %q, db %q, host %q, port %q; want the default, .env, environment and flag values",
+			got.User, got.Name, got.Host, got.Port)
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	inConfigDir(t, "POSTGRES_PASSWORD=secret\nPOSTGRES_DB=from_dotenv\n", "POSTGRES_DB", "POSTGRES_PASSWORD", "DATABASE_URL", "APP_ENV")
+	other := filepath.Join(t.TempDir(), "brocab.env")
+	if err := os.WriteFile(other, []byte("POSTGRES_PASSWORD=secret\nPOSTGRES_DB=from_config\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// -config replaces .env rather than layering on it
+	cfg, err := LoadConfig([]string{"-config", other})
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if cfg.Database.Name != "from_config" {
+		t.Fatalf("POSTGRES_DB = %q, want the -config file's", cfg.Database.Name)
+	}
+
+	if _, err := LoadConfig([]string{"-config", filepath.Join(t.TempDir(), "missing.env")}); err == nil {
+		t.Fatal("a missing -config file was ignored")
+	}
+}
+
+func TestLoadConfigHelp(t *testing.T) {
+	inConfigDir(t, "")
+
+	// Keep the usage text out of the test output
+	stderr := os.Stderr
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	os.Stderr = devNull
+	defer func() { os.Stderr = stderr }()
+
+	if _, err := LoadConfig([]string{"-h"}); !errors.Is(err, flag.ErrHelp) {
+		t.Fatalf("LoadConfig(-h) = %v, want flag.ErrHelp for main to exit cleanly on", err)
+	}
+}
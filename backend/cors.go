@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+// originPattern is one entry of CORS_ALLOWED_ORIGINS: an exact origin such as https://brocab.app,
+// or a subdomain wildcard such as https://*.brocab.app, which matches any subdomain at any depth
+// but not brocab.app itself.
+type originPattern struct {
+	scheme   string
+	host     string // Without the "*." for wildcards
+	port     string
+	wildcard bool
+}
+
+func parseOriginPattern(origin string) (originPattern, error) {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return originPattern{}, fmt.Errorf("%q is not an origin (scheme://host[:port])", origin)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return originPattern{}, fmt.Errorf("%q must use http or https", origin)
+	}
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.User != nil {
+		return originPattern{}, fmt.Errorf("%q must not have a path, query or credentials", origin)
+	}
+
+	p := originPattern{scheme: u.Scheme, host: strings.ToLower(u.Hostname()), port: u.Port()}
+	if strings.HasPrefix(p.host, "*.") {
+		p.wildcard = true
+		p.host = strings.TrimPrefix(p.host, "*.")
+	}
+	if strings.Contains(p.host, "*") {
+		return originPattern{}, fmt.Errorf("%q: only a leading *. subdomain wildcard is supported", origin)
+	}
+	if p.wildcard && !strings.Contains(p.host, ".") {
+		return originPattern{}, fmt.Errorf("%q: wildcard must be under a registrable domain, not a top-level domain", origin)
+	}
+	return p, nil
+}
+
+func (p originPattern) matches(origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme != p.scheme || u.Port() != p.port {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if p.wildcard {
+		return strings.HasSuffix(host, "."+p.host)
+	}
+	return host == p.host
+}
+
+func (p originPattern) isLocalhost() bool {
+	return p.host == "localhost" || p.host == "127.0.0.1" || p.host == "::1"
+}
+
+// allowsAllOrigins reports whether the origin list is the "*" wildcard
+func (cfg HTTPConfig) allowsAllOrigins() bool {
+	return len(cfg.CORSOrigins) == 1 && cfg.CORSOrigins[0] == "*"
+}
+
+// validate checks the CORS and proxy settings. In production it also rejects combinations that
+// would let any site call the API as the user, or let clients spoof their IP.
+func (cfg HTTPConfig) validate(production bool) error {
+	var errs []error
+
+	if len(cfg.CORSOrigins) == 0 {
+		errs = append(errs, errors.New("CORS_ALLOWED_ORIGINS cannot be empty"))
+	}
+
+	if cfg.allowsAllOrigins() {
+		if cfg.CORSAllowCredentials {
+			errs = append(errs, errors.New("CORS_ALLOW_CREDENTIALS cannot be used with CORS_ALLOWED_ORIGINS=*"))
+		}
+		if production {
+			errs = append(errs, errors.New("CORS_ALLOWED_ORIGINS=* is not allowed in production, list the frontend origins"))
+		}
+	} else {
+		for _, origin := range cfg.CORSOrigins {
+			p, err := parseOriginPattern(origin)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("CORS_ALLOWED_ORIGINS: %v", err))
+				continue
+			}
+			if production && p.scheme == "http" && !p.isLocalhost() {
+				errs = append(errs, fmt.Errorf("CORS_ALLOWED_ORIGINS: %q must use https in production", origin))
+			}
+			if production && p.isLocalhost() && cfg.CORSAllowCredentials {
+				errs = append(errs, fmt.Errorf("CORS_ALLOWED_ORIGINS: %q cannot receive credentials in production", origin))
+			}
+		}
+	}
+
+	for _, proxy := range cfg.TrustedProxies {
+		network, err := parseTrustedProxy(proxy)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("TRUSTED_PROXIES: %v", err))
+			continue
+		}
+		if ones, _ := network.Mask.Size(); production && ones == 0 {
+			errs = append(errs, fmt.Errorf("TRUSTED_PROXIES: %q trusts every address, so any client could spoof its IP", proxy))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// parseTrustedProxy accepts an IP or a CIDR, the same forms gin's SetTrustedProxies does
+func parseTrustedProxy(proxy string) (*net.IPNet, error) {
+	if !strings.Contains(proxy, "/") {
+		ip := net.ParseIP(proxy)
+		if ip == nil {
+			return nil, fmt.Errorf("%q is not an IP address or CIDR", proxy)
+		}
+		bits := 8 * len(ip.To16())
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, network, err := net.ParseCIDR(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not an IP address or CIDR", proxy)
+	}
+	return network, nil
+}
+
+// CORSMiddleware allows the configured origins to call the API from a browser
+func CORSMiddleware(cfg HTTPConfig) gin.HandlerFunc {
+	config := cors.Config{
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", "Authorization", "Accept", "X-Requested-With", "X-Request-ID", "If-None-Match", "traceparent", "tracestate"},
+		ExposeHeaders:    []string{"Content-Length", "Deprecation", "Sunset", "Link", "Retry-After", "X-Request-ID", "ETag"},
+		AllowCredentials: cfg.CORSAllowCredentials,
+		MaxAge:           12 * time.Hour,
+	}
+
+	if cfg.allowsAllOrigins() {
+		config.AllowAllOrigins = true
+	} else {
+		// Origins were validated with the config, so parse errors can't happen here
+		patterns := make([]originPattern, 0, len(cfg.CORSOrigins))
+		for _, origin := range cfg.CORSOrigins {
+			p, _ := parseOriginPattern(origin)
+			patterns = append(patterns, p)
+		}
+		config.AllowOriginFunc = func(origin string) bool {
+			for _, p := range patterns {
+				if p.matches(origin) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	return cors.New(config)
+}
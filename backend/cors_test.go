@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestOriginPatternMatches(t *testing.T) {
+	tests := []struct {
+		pattern, origin string
+		want            bool
+	}{
+		{"https://*.example.com", "https://app.example.com", true},
+		{"https://*.example.com", "https://a.b.example.com", true}, // Any depth
+		{"https://*.example.com", "https://APP.Example.com", true},
+		{"https://*.example.com", "https://example.com", false}, // Not the domain itself
+		{"https://*.example.com", "https://evilexample.com", false},
+		{"https://*.example.com", "https://example.com.evil.net", false},
+		{"https://*.example.com", "https://app.example.com.evil.net", false},
+		{"https://*.example.com", "http://app.example.com", false}, // Scheme must match
+		{"https://*.example.com", "https://app.example.com:8443", false},
+		{"https://example.com", "https://example.com", true},
+		{"https://example.com", "https://app.example.com", false},
+		{"http://localhost:3000", "http://localhost:3000", true},
+		{"http://localhost:3000", "http://localhost:3001", false},
+	}
+	for _, tt := range tests {
+		p, err := parseOriginPattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tt.pattern, err)
+		}
+		if got := p.matches(tt.origin); got != tt.want {
+			t.Errorf("%q matches %q = %v, want %v", tt.pattern, tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestParseOriginPatternErrors(t *testing.T) {
+	for _, origin := range []string{
+		"example.com",               // No scheme
+		"ftp://example.com",         // Not http(s)
+		"https://example.com/app",   // Path
+		"https://app.*.example.com", // Wildcard not leading
+		"https://*.com",             // Wildcard over a top-level domain
+	} {
+		if _, err := parseOriginPattern(origin); err == nil {
+			t.Errorf("parseOriginPattern(%q) accepted an invalid origin", origin)
+		}
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	r := gin.New()
+	r.Use(CORSMiddleware(HTTPConfig{CORSOrigins: []string{"https://*.example.com"}, CORSAllowCredentials: true}))
+	r.GET("/ping", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://app.example.com", true},
+		{"https://evilexample.com", false},
+		{"https://example.com.evil.net", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/ping", nil)
+		req.Header.Set("Origin", tt.origin)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		allowOrigin := w.Header().Get("Access-Control-Allow-Origin")
+		if tt.allowed && (allowOrigin != tt.origin || w.Header().Get("Access-Control-Allow-Credentials") != "true") {
+			t.Errorf("%s: Access-Control-Allow-Origin = %q, want it echoed with credentials", tt.origin, allowOrigin)
+		}
+		if !tt.allowed && (w.Code != http.StatusForbidden || allowOrigin != "") {
+			t.Errorf("%s: %d with Access-Control-Allow-Origin %q, want refused", tt.origin, w.Code, allowOrigin)
+		}
+	}
+}
+
+func TestHTTPConfigValidate(t *testing.T) {
+	local := []string{"127.0.0.1", "::1"}
+	tests := []struct {
+		name       string
+		cfg        HTTPConfig
+		production bool
+		want       string // Part of the error, empty when valid
+	}{
+		{"any origin in development", HTTPConfig{CORSOrigins: []string{"*"}, TrustedProxies: local}, false, ""},
+		{"credentials with any origin", HTTPConfig{CORSOrigins: []string{"*"}, CORSAllowCredentials: true}, false,
+			"CORS_ALLOW_CREDENTIALS cannot be used with CORS_ALLOWED_ORIGINS=*"},
+		{"any origin in production", HTTPConfig{CORSOrigins: []string{"*"}}, true, "not allowed in production"},
+		{"no origins", HTTPConfig{}, false, "cannot be empty"},
+		{"listed https origins in production",
+			HTTPConfig{CORSOrigins: []string{"https://brocab.app", "https://*.brocab.app"}, CORSAllowCredentials: true, TrustedProxies: []string{"10.0.0.0/8"}},
+			true, ""},
+		{"plain http in production", HTTPConfig{CORSOrigins: []string{"http://brocab.app"}}, true, "must use https in production"},
+		{"plain http in development", HTTPConfig{CORSOrigins: []string{"http://brocab.app"}}, false, ""},
+		{"localhost without credentials in production", HTTPConfig{CORSOrigins: []string{"http://localhost:3000"}}, true, ""},
+		{"localhost with credentials in production", HTTPConfig{CORSOrigins: []string{"http://localhost:3000"}, CORSAllowCredentials: true}, true,
+			"cannot receive credentials in production"},
+		{"invalid origin", HTTPConfig{CORSOrigins: []string{"brocab.app"}}, false, "is not an origin"},
+		{"every proxy trusted in production", HTTPConfig{CORSOrigins: []string{"https://brocab.app"}, TrustedProxies: []string{"0.0.0.0/0"}}, true,
+			"trusts every address"},
+		{"every proxy trusted in development", HTTPConfig{CORSOrigins: []string{"*"}, TrustedProxies: []string{"0.0.0.0/0"}}, false, ""},
+		{"invalid proxy", HTTPConfig{CORSOrigins: []string{"*"}, TrustedProxies: []string{"proxy.internal"}}, false, "is not an IP address or CIDR"},
+	}
+	for _, tt := range tests {
+		err := tt.cfg.validate(tt.production)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: %v, want valid", tt.name, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: %v, want an error containing %q", tt.name, err, tt.want)
+		}
+	}
+}
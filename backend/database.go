@@ -1,13 +1,18 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
+	"net"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/joho/godotenv"
+	"github.com/jackc/pgx/v5/pgconn"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -16,35 +21,34 @@ import (
 // Global DB instance
 var DB *gorm.DB
 
-// InitDatabase connects to the DB and migrates tables
-func InitDatabase() {
-	// Load environment variables from .env file
-	envVars := make(map[string]string)
-	if err := godotenv.Load(); err != nil {
-		log.Println("⚠️  No .env file found, using system environment variables")
-	} else {
-		// Read .env file variables into a map for priority handling
-		file, err := os.ReadFile(".env")
-		if err == nil {
-			lines := strings.Split(string(file), "\n")
-			for _, line := range lines {
-				if strings.Contains(line, "=") && !strings.HasPrefix(line, "#") {
-					parts := strings.SplitN(line, "=", 2)
-					if len(parts) == 2 {
-						key := strings.TrimSpace(parts[0])
-						value := strings.TrimSpace(parts[1])
-						envVars[key] = value
-					}
-				}
-			}
-		}
-	}
+// models lists every table AutoMigrate manages
+var models = []interface{}{
+	&User{},
+	&Ride{},
+	&Request{},
+	&Participant{},
+	&Notification{},
+	&NotificationOutbox{},
+	&NotificationPreference{},
+	&NotificationSettings{},
+	&DigestEntry{},
+	&EmailOutbox{},
+	&DeviceToken{},
+	&Place{},
+	&PlaceAlias{},
+	&SavedSearch{},
+	&SavedSearchAlert{},
+	&TripRequest{},
+	&RideReminder{},
+}
 
+// InitDatabase connects to the DB and migrates tables
+func InitDatabase(cfg DatabaseConfig) {
 	var dsn string
 	var connectionType string
 
 	// Check if DATABASE_URL is provided (preferred method for Supabase)
-	if databaseURL := getEnvFromFile("DATABASE_URL", "", envVars); databaseURL != "" {
+	if databaseURL := cfg.URL; databaseURL != "" {
 		// Ensure SSL is enabled in DATABASE_URL
 		if !strings.Contains(databaseURL, "sslmode=") {
 			if strings.Contains(databaseURL, "?") {
@@ -67,18 +71,10 @@ func InitDatabase() {
 			connectionType = "Custom"
 		}
 
-		fmt.Printf("🔗 Using DATABASE_URL for Supabase %s with SSL enforcement\n", connectionType)
+		slog.Info("🔗 Using DATABASE_URL for Supabase with SSL enforcement", "connection_type", connectionType)
 	} else {
-		// Fallback to individual parameters - prioritize .env file over system variables
-		host := getEnvFromFile("POSTGRES_HOST", "aws-0-ap-south-1.pooler.supabase.com", envVars)
-		user := getEnvFromFile("POSTGRES_USER", "postgres.lwghhgzhlyrourvbssjk", envVars)
-		password := getEnvFromFile("POSTGRES_PASSWORD", "", envVars)
-		dbname := getEnvFromFile("POSTGRES_DB", "postgres", envVars)
-		port := getEnvFromFile("POSTGRES_PORT", "6543", envVars)
-
-		if password == "" {
-			log.Fatal("❌ POSTGRES_PASSWORD environment variable is required for Supabase connection")
-		}
+		// Fallback to individual parameters
+		host, user, password, dbname, port := cfg.Host, cfg.User, cfg.Password, cfg.Name, cfg.Port
 
 		// Determine connection type based on host and port
 		if strings.Contains(host, "pooler.supabase.com") {
@@ -108,7 +104,7 @@ func InitDatabase() {
 			)
 		}
 
-		fmt.Printf("🔗 Connecting to Supabase %s: %s@%s:%s/%s\n", connectionType, user, host, port, dbname)
+		slog.Info("🔗 Connecting to Supabase", "connection_type", connectionType, "user", user, "host", host, "port", port, "database", dbname)
 	}
 
 	// Set connection timeout and retry logic with better prepared statement handling
@@ -121,103 +117,106 @@ func InitDatabase() {
 		gormConfig = &gorm.Config{
 			PrepareStmt:                              false, // Required for Transaction Pooler
 			DisableForeignKeyConstraintWhenMigrating: true,  // Avoid constraint conflicts
-			Logger: logger.New(
-				log.New(os.Stdout, "\r\n", log.LstdFlags),
-				logger.Config{
-					SlowThreshold: 2 * time.Second, // More lenient for pooled connections
-					LogLevel:      logger.Warn,     // Reduce log noise
-					Colorful:      true,
-				},
-			),
+			Logger: gormLogger{
+				SlowThreshold: 2 * time.Second, // More lenient for pooled connections
+				LogLevel:      logger.Warn,     // Reduce log noise
+			},
 		}
 	} else {
 		// Direct or Session Pooler can use prepared statements
 		gormConfig = &gorm.Config{
 			PrepareStmt: true, // Can use prepared statements for better performance
-			Logger: logger.New(
-				log.New(os.Stdout, "\r\n", log.LstdFlags),
-				logger.Config{
-					SlowThreshold: time.Second,
-					LogLevel:      logger.Info,
-					Colorful:      true,
-				},
-			),
+			Logger: gormLogger{
+				SlowThreshold: time.Second,
+				LogLevel:      logger.Info,
+			},
 		}
 	}
 
 	db, err := gorm.Open(postgres.Open(dsn), gormConfig)
 	if err != nil {
-		log.Printf("❌ First connection attempt failed: %v", err)
-		log.Println("🔄 Retrying connection in 2 seconds...")
+		slog.Warn("❌ First connection attempt failed, retrying in 2 seconds", "error", err)
 		time.Sleep(2 * time.Second)
 
 		// Retry once more
 		db, err = gorm.Open(postgres.Open(dsn), gormConfig)
 		if err != nil {
-			log.Fatal("❌ Failed to connect to Supabase database after retry:", err)
+			fatal("❌ Failed to connect to Supabase database after retry", "error", err)
 		}
 	}
 
 	// Test the connection
 	sqlDB, err := db.DB()
 	if err != nil {
-		log.Fatal("❌ Failed to get database instance:", err)
+		fatal("❌ Failed to get database instance", "error", err)
 	}
 
-	// Configure connection pool settings based on connection type
+	// Configure connection pool settings based on connection type, unless configured explicitly
+	pool := cfg
 	if strings.Contains(connectionType, "Transaction Pooler") {
 		// Optimized for stateless, short-lived connections - maximum capacity
-		sqlDB.SetMaxOpenConns(200)                // Maximum for Transaction Pooler
-		sqlDB.SetMaxIdleConns(50)                 // High idle connections for quick reuse
-		sqlDB.SetConnMaxLifetime(5 * time.Minute) // Optimal lifetime for pooled connections
-		sqlDB.SetConnMaxIdleTime(1 * time.Minute) // Quick cleanup of unused connections
+		pool.applyPoolDefaults(200, 50, 5*time.Minute, 1*time.Minute)
 	} else {
 		// Optimized for persistent connections - maximum capacity
-		sqlDB.SetMaxOpenConns(500)                 // Maximum for direct connections
-		sqlDB.SetMaxIdleConns(100)                 // High idle pool for performance
-		sqlDB.SetConnMaxLifetime(30 * time.Minute) // Longer lifetime for persistent connections
-		sqlDB.SetConnMaxIdleTime(10 * time.Minute) // Reasonable idle timeout
+		pool.applyPoolDefaults(500, 100, 30*time.Minute, 10*time.Minute)
 	}
+	sqlDB.SetMaxOpenConns(pool.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(pool.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(pool.ConnMaxIdleTime)
 
 	if err := sqlDB.Ping(); err != nil {
-		log.Fatal("❌ Failed to ping Supabase database:", err)
+		fatal("❌ Failed to ping Supabase database", "error", err)
+	}
+
+	if err := registerDBMetrics(db, sqlDB); err != nil {
+		slog.Warn("⚠️  Database metrics disabled", "error", err)
+	}
+	if err := db.Use(gormTracingPlugin{}); err != nil {
+		slog.Warn("⚠️  Database tracing disabled", "error", err)
+	}
+	if err := db.Use(gormTimeoutPlugin{timeout: cfg.QueryTimeout}); err != nil {
+		fatal("❌ Failed to register database timeouts", "error", err)
 	}
 	DB = db
-	fmt.Printf("✅ Supabase database connected successfully via %s!\n", connectionType)
+	slog.Info("✅ Supabase database connected successfully", "connection_type", connectionType)
 
 	// Run migrations for all tables with error suppression for prepared statement conflicts
-	err = db.AutoMigrate(
-		&User{},
-		&Ride{},
-		&Request{},
-		&Participant{},
-		&Notification{},
-	)
+	err = db.AutoMigrate(models...)
 	if err != nil {
 		// Check if it's just a table already exists error or prepared statement conflict
 		if strings.Contains(err.Error(), "already exists") ||
 			strings.Contains(err.Error(), "prepared statement") {
-			fmt.Println("ℹ️  Tables already exist or migration completed with cache conflicts, continuing...")
+			slog.Info("ℹ️  Tables already exist or migration completed with cache conflicts, continuing...")
 		} else {
-			log.Fatal("❌ Failed to migrate database:", err)
+			fatal("❌ Failed to migrate database", "error", err)
 		}
 	} else {
-		fmt.Println("✅ Database tables migrated successfully!")
+		slog.Info("✅ Database tables migrated successfully!")
+	}
+
+	if err := backfillNotificationSnapshots(); err != nil {
+		slog.Warn("⚠️  Failed to backfill notification ride details", "error", err)
+	}
+	if err := backfillRidePlaces(context.Background()); err != nil {
+		slog.Warn("⚠️  Failed to backfill ride places", "error", err)
 	}
 }
 
-// getEnvFromFile prioritizes .env file over system environment variables
-func getEnvFromFile(key, defaultVal string, envVars map[string]string) string {
-	// First check .env file variables
-	if value, exists := envVars[key]; exists && value != "" {
-		return value
+// applyPoolDefaults fills in pool settings left at zero
+func (cfg *DatabaseConfig) applyPoolDefaults(maxOpen, maxIdle int, maxLifetime, maxIdleTime time.Duration) {
+	if cfg.MaxOpenConns == 0 {
+		cfg.MaxOpenConns = maxOpen
 	}
-	// Then check system environment variables
-	if value, exists := os.LookupEnv(key); exists {
-		return value
+	if cfg.MaxIdleConns == 0 {
+		cfg.MaxIdleConns = maxIdle
+	}
+	if cfg.ConnMaxLifetime == 0 {
+		cfg.ConnMaxLifetime = maxLifetime
+	}
+	if cfg.ConnMaxIdleTime == 0 {
+		cfg.ConnMaxIdleTime = maxIdleTime
 	}
-	// Finally return default value
-	return defaultVal
 }
 
 // SafeQuery executes a GORM query with retry logic for prepared statement conflicts
@@ -232,3 +231,138 @@ func SafeQuery(queryFunc func() error) error {
 
 	return err
 }
+
+// registerGormCallbacks wraps every kind of GORM statement with a before and after callback,
+// named <prefix>:before_<operation> and <prefix>:after_<operation>
+func registerGormCallbacks(db *gorm.DB, prefix string, before, after func(operation string) func(*gorm.DB)) error {
+	cb := db.Callback()
+	register := []struct {
+		operation string
+		before    func(name string, fn func(*gorm.DB)) error
+		after     func(name string, fn func(*gorm.DB)) error
+	}{
+		{"create", cb.Create().Before("gorm:create").Register, cb.Create().After("gorm:create").Register},
+		{"query", cb.Query().Before("gorm:query").Register, cb.Query().After("gorm:query").Register},
+		{"update", cb.Update().Before("gorm:update").Register, cb.Update().After("gorm:update").Register},
+		{"delete", cb.Delete().Before("gorm:delete").Register, cb.Delete().After("gorm:delete").Register},
+		{"row", cb.Row().Before("gorm:row").Register, cb.Row().After("gorm:row").Register},
+		{"raw", cb.Raw().Before("gorm:raw").Register, cb.Raw().After("gorm:raw").Register},
+	}
+
+	for _, r := range register {
+		if err := r.before(prefix+":before_"+r.operation, before(r.operation)); err != nil {
+			return err
+		}
+		if err := r.after(prefix+":after_"+r.operation, after(r.operation)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The timeout and tracing plugins replace a statement's context for one operation. They save the
+// caller's context first and put it back afterwards, so a chain reused for several operations
+// (e.g. Count then Find) doesn't inherit an expired deadline or a finished span.
+const gormParentContextKey = "brocab:parent_context"
+
+func setStatementContext(tx *gorm.DB, ctx context.Context) {
+	if _, ok := tx.InstanceGet(gormParentContextKey); !ok {
+		tx.InstanceSet(gormParentContextKey, tx.Statement.Context)
+	}
+	tx.Statement.Context = ctx
+}
+
+func restoreStatementContext(tx *gorm.DB) {
+	if parent, ok := tx.InstanceGet(gormParentContextKey); ok {
+		tx.Statement.Context = parent.(context.Context)
+	}
+}
+
+// gormTimeoutPlugin gives each statement its own deadline on top of the caller's context, so a hung
+// pooler can't hold a request or a worker forever. It also records on the request when a statement
+// failed because the database was slow or unreachable.
+type gormTimeoutPlugin struct {
+	timeout time.Duration
+}
+
+const gormCancelKey = "timeout:cancel"
+
+func (gormTimeoutPlugin) Name() string { return "brocab:timeout" }
+
+func (p gormTimeoutPlugin) Initialize(db *gorm.DB) error {
+	before := func(operation string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			// Row and Rows are read after the callbacks return, so they can't be cancelled here
+			if p.timeout <= 0 || operation == "row" {
+				return
+			}
+			ctx, cancel := context.WithTimeout(tx.Statement.Context, p.timeout)
+			setStatementContext(tx, ctx)
+			tx.InstanceSet(gormCancelKey, cancel)
+		}
+	}
+	after := func(string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			if err := tx.Statement.Error; err != nil {
+				recordDBFailure(tx.Statement.Context, err)
+			}
+			if cancel, ok := tx.InstanceGet(gormCancelKey); ok {
+				cancel.(context.CancelFunc)()
+			}
+			restoreStatementContext(tx)
+		}
+	}
+	return registerGormCallbacks(db, "timeout", before, after)
+}
+
+// isDBTimeout reports whether err means a statement ran out of time
+func isDBTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || pgconn.Timeout(err)
+}
+
+// isDBUnavailable reports whether err means the database couldn't be reached, or the
+// statement was cancelled because the client went away
+func isDBUnavailable(err error) bool {
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	return errors.Is(err, context.Canceled) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, sql.ErrConnDone) ||
+		errors.As(err, &connectErr) ||
+		errors.As(err, &netErr)
+}
+
+// dbFailure holds the first timeout or connection error a request's statements hit
+type dbFailure struct {
+	mu  sync.Mutex
+	err error
+}
+
+type dbFailureKey struct{}
+
+// trackDBFailures lets statements run with ctx report timeouts and connection errors
+func trackDBFailures(ctx context.Context) (context.Context, *dbFailure) {
+	failure := &dbFailure{}
+	return context.WithValue(ctx, dbFailureKey{}, failure), failure
+}
+
+func recordDBFailure(ctx context.Context, err error) {
+	if !isDBTimeout(err) && !isDBUnavailable(err) {
+		return
+	}
+	failure, _ := ctx.Value(dbFailureKey{}).(*dbFailure)
+	if failure == nil {
+		return
+	}
+	failure.mu.Lock()
+	defer failure.mu.Unlock()
+	if failure.err == nil {
+		failure.err = err
+	}
+}
+
+func (f *dbFailure) Err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// slowQueries installs the timeout plugin with timeout on db and returns a switch that makes
+// queries stall past it, like a hung pooler
+func slowQueries(t *testing.T, db *gorm.DB, timeout time.Duration) *atomic.Bool {
+	t.Helper()
+
+	if err := db.Use(gormTimeoutPlugin{timeout: timeout}); err != nil {
+		t.Fatal(err)
+	}
+	var slow atomic.Bool
+	if err := db.Callback().Query().After("timeout:before_query").Before("gorm:query").Register("test_slow", func(tx *gorm.DB) {
+		if slow.Load() {
+			<-tx.Statement.Context.Done()
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return &slow
+}
+
+func TestQueryTimeout(t *testing.T) {
+	db := newTestDB(t)
+	leader := seedUser(t, db)
+	ride := seedRide(t, db, leader, "Campus", "Airport", daysFromNow(1), "09:00", 4)
+	slow := slowQueries(t, db, 20*time.Millisecond)
+
+	// Each statement gets its own deadline, even when the caller set none
+	slow.Store(true)
+	start := time.Now()
+	var count int64
+	if err := db.Model(&Ride{}).Count(&count).Error; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("stalled query = %v, want a deadline error", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("stalled query gave up after %s, want about 20ms", elapsed)
+	}
+
+	// The deadline belongs to one statement, not to the chain it was run on
+	slow.Store(false)
+	chain := db.WithContext(context.Background()).Model(&Ride{}).Where("id = ?", ride.ID)
+	if err := chain.Count(&count).Error; err != nil {
+		t.Fatalf("count = %v", err)
+	}
+	time.Sleep(40 * time.Millisecond)
+	var found []Ride
+	if err := chain.Find(&found).Error; err != nil || len(found) != 1 {
+		t.Fatalf("next statement on the chain = %v with %d rides, want the ride", err, len(found))
+	}
+}
+
+func TestQueryTimeoutReturns504(t *testing.T) {
+	db := newTestDB(t)
+	leader := seedUser(t, db)
+	ride := seedRide(t, db, leader, "Campus", "Airport", daysFromNow(1), "09:00", 4)
+	slowQueries(t, db, 20*time.Millisecond).Store(true)
+
+	// The handler sees a failed lookup and reports "not found"; the client learns the truth
+	handler := func(c *gin.Context) {
+		var found Ride
+		if err := DB.WithContext(c.Request.Context()).First(&found, c.Param("rideID")).Error; err != nil {
+			abortWithError(c, http.StatusNotFound, CodeRideNotFound, "Ride not found")
+			return
+		}
+		c.JSON(http.StatusOK, found)
+	}
+
+	var body APIError
+	decodeJSON(t, serve(t, handler, "GET", "/ride/:rideID", fmt.Sprintf("/ride/%d", ride.ID), "", nil), http.StatusGatewayTimeout, &body)
+	if body.Code != CodeDatabaseTimeout {
+		t.Fatalf("code = %s, want %s", body.Code, CodeDatabaseTimeout)
+	}
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"log/slog"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	emailMaxAttempts  = 8
+	emailBatchSize    = 20
+	emailRetryBackoff = 30 * time.Second
+
+	// How long a worker has to send a claimed batch before another worker may claim it again
+	emailLease = 5 * time.Minute
+
+	// Default bound on one SMTP delivery, from dialling to QUIT
+	defaultSMTPTimeout = 30 * time.Second
+)
+
+// EmailMessage is a rendered email ready to be handed to an EmailSender
+type EmailMessage struct {
+	To       string
+	Subject  string
+	TextBody string
+	HTMLBody string
+}
+
+// EmailSender delivers a single email, giving up when ctx is done. SMTPSender is the production
+// implementation.
+type EmailSender interface {
+	Send(ctx context.Context, msg EmailMessage) error
+}
+
+// SMTPSender sends multipart (text + HTML) email through an SMTP server
+type SMTPSender struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+	Timeout  time.Duration // Bound on the whole delivery; defaultSMTPTimeout when zero
+}
+
+// Send delivers msg the way smtp.SendMail does, but bounded by s.Timeout and ctx, so a stalled
+// server can't hold up the worker or shutdown
+func (s SMTPSender) Send(ctx context.Context, msg EmailMessage) error {
+	body, err := buildMIMEMessage(s.From, msg)
+	if err != nil {
+		return err
+	}
+
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = defaultSMTPTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(s.Host, s.Port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	// Cancelling ctx, e.g. at shutdown, unblocks a read or write in progress
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	client, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: s.Host}); err != nil {
+			return err
+		}
+	}
+	if s.Username != "" {
+		if ok, _ := client.Extension("AUTH"); ok {
+			if err := client.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := client.Mail(s.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(msg.To); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// buildMIMEMessage encodes msg as a multipart/alternative email
+func buildMIMEMessage(from string, msg EmailMessage) ([]byte, error) {
+	boundaryBytes := make([]byte, 12)
+	if _, err := rand.Read(boundaryBytes); err != nil {
+		return nil, err
+	}
+	boundary := "brocab-" + hex.EncodeToString(boundaryBytes)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", boundary)
+
+	parts := []struct{ contentType, body string }{
+		{"text/plain", msg.TextBody},
+		{"text/html", msg.HTMLBody},
+	}
+	for _, part := range parts {
+		fmt.Fprintf(&buf, "--%s\r\n", boundary)
+		fmt.Fprintf(&buf, "Content-Type: %s; charset=UTF-8\r\n", part.contentType)
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		qp := quotedprintable.NewWriter(&buf)
+		if _, err := qp.Write([]byte(part.body)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+		buf.WriteString("\r\n")
+	}
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+
+	return buf.Bytes(), nil
+}
+
+// EmailOutbox is a queued email. Rows are written by the email channel and drained by the
+// outbox worker, so a failing SMTP server never loses a message or blocks a request.
+type EmailOutbox struct {
+	ID            uint      `gorm:"primaryKey"`
+	UserID        string    `gorm:"index;not null"` // Firebase UID of the recipient
+	OutboxID      *uint     `gorm:"uniqueIndex"`    // Notification outbox event this email is for; nil for digests
+	ToAddress     string    `gorm:"type:varchar(100);not null"`
+	Subject       string    `gorm:"type:varchar(255);not null"`
+	TextBody      string    `gorm:"type:text;not null"`
+	HTMLBody      string    `gorm:"type:text;not null"`
+	Status        string    `gorm:"type:varchar(20);index;not null"` // "pending", "sending", "sent" or "failed"
+	Attempts      int       `gorm:"not null"`
+	NextAttemptAt time.Time `gorm:"index;not null"` // While sending, when the worker's lease runs out
+	LastError     string    `gorm:"type:text"`
+	SentAt        *time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// emailTemplateData is what notification email templates are rendered with
+type emailTemplateData struct {
+	Name        string
+	Title       string
+	Message     string
+	Type        string
+	Origin      string
+	Destination string
+	Date        string
+	Time        string
+}
+
+// Subject lines per notification type; anything else uses the notification title
+var emailSubjectTemplates = map[string]*texttemplate.Template{
+	"join_request":          texttemplate.Must(texttemplate.New("join_request").Parse("New join request for your ride to {{.Destination}}")),
+	"request_approved":      texttemplate.Must(texttemplate.New("request_approved").Parse("You're approved for the ride to {{.Destination}} on {{.Date}}")),
+	"ride_cancelled":        texttemplate.Must(texttemplate.New("ride_cancelled").Parse("Ride cancelled: {{.Origin}} → {{.Destination}} on {{.Date}}")),
+	"participant_removed":   texttemplate.Must(texttemplate.New("participant_removed").Parse("You were removed from the ride to {{.Destination}}")),
+	"participant_cancelled": texttemplate.Must(texttemplate.New("participant_cancelled").Parse("A participant left your ride to {{.Destination}}")),
+}
+
+var emailTextTemplate = texttemplate.Must(texttemplate.New("text").Parse(`Hi {{.Name}},
+
+{{.Message}}
+{{if .Origin}}
+Ride: {{.Origin}} → {{.Destination}}
+When: {{.Date}} at {{.Time}}
+{{end}}
+— BroCab
+`))
+
+var emailHTMLTemplate = htmltemplate.Must(htmltemplate.New("html").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: Arial, sans-serif; color: #222;">
+  <h2>{{.Title}}</h2>
+  <p>Hi {{.Name}},</p>
+  <p>{{.Message}}</p>
+  {{if .Origin}}
+  <table style="border-collapse: collapse;">
+    <tr><td style="padding: 4px 12px 4px 0;"><strong>Ride</strong></td><td>{{.Origin}} → {{.Destination}}</td></tr>
+    <tr><td style="padding: 4px 12px 4px 0;"><strong>When</strong></td><td>{{.Date}} at {{.Time}}</td></tr>
+  </table>
+  {{end}}
+  <p style="color: #888;">— BroCab</p>
+</body>
+</html>
+`))
+
+// renderNotificationEmail builds the email for a notification sent to user
+func renderNotificationEmail(user User, n *Notification) (EmailMessage, error) {
+	data := emailTemplateData{
+		Name:        user.Name,
+		Title:       n.Title,
+		Message:     n.Message,
+		Type:        n.Type,
+		Origin:      n.Origin,
+		Destination: n.Destination,
+		Date:        n.Date,
+		Time:        n.Time,
+	}
+
+	subject := n.Title
+	if tmpl, ok := emailSubjectTemplates[n.Type]; ok {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return EmailMessage{}, err
+		}
+		subject = buf.String()
+	}
+
+	var text, html bytes.Buffer
+	if err := emailTextTemplate.Execute(&text, data); err != nil {
+		return EmailMessage{}, err
+	}
+	if err := emailHTMLTemplate.Execute(&html, data); err != nil {
+		return EmailMessage{}, err
+	}
+
+	return EmailMessage{
+		To:       user.Email,
+		Subject:  strings.ReplaceAll(subject, "\n", " "),
+		TextBody: text.String(),
+		HTMLBody: html.String(),
+	}, nil
+}
+
+// EmailChannel renders notifications into emails and queues them in the outbox.
+// A notification outbox event queues at most one email, however often it is dispatched.
+type EmailChannel struct{}
+
+func (EmailChannel) Name() string { return "email" }
+
+func (EmailChannel) Send(ctx context.Context, n *Notification) error {
+	user, err := getUser(ctx, n.UserID)
+	if err != nil {
+		return fmt.Errorf("recipient not found: %w", err)
+	}
+
+	msg, err := renderNotificationEmail(*user, n)
+	if err != nil {
+		return err
+	}
+
+	return DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&EmailOutbox{
+		UserID:        n.UserID,
+		OutboxID:      n.OutboxID,
+		ToAddress:     msg.To,
+		Subject:       msg.Subject,
+		TextBody:      msg.TextBody,
+		HTMLBody:      msg.HTMLBody,
+		Status:        "pending",
+		NextAttemptAt: time.Now(),
+	}).Error
+}
+
+// claimEmails leases up to emailBatchSize due emails to the caller. Claimed rows are marked
+// "sending" until the lease runs out, so other workers skip them while they are sent outside any
+// transaction, and pick them up again if this worker dies before recording the outcome. The claim
+// counts as an attempt, so an email that keeps crashing its worker is still given up on.
+func claimEmails(db *gorm.DB) ([]EmailOutbox, error) {
+	var batch []EmailOutbox
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status IN ? AND next_attempt_at <= ?", []string{"pending", "sending"}, now).
+			Order("id").Limit(emailBatchSize).
+			Find(&batch).Error; err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, 0, len(batch))
+		for _, email := range batch {
+			ids = append(ids, email.ID)
+		}
+		return tx.Model(&EmailOutbox{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+			"status":          "sending",
+			"attempts":        gorm.Expr("attempts + 1"),
+			"next_attempt_at": now.Add(emailLease),
+		}).Error
+	})
+	return batch, err
+}
+
+// isPermanentEmailError reports whether the SMTP server refused the email outright (a 5xx reply),
+// so retrying cannot help
+func isPermanentEmailError(err error) bool {
+	var reply *textproto.Error
+	return errors.As(err, &reply) && reply.Code >= 500
+}
+
+// deliverEmailOutbox sends due outbox rows, rescheduling failures with exponential backoff. Once
+// ctx is done no more emails are sent; the rest of the batch is picked up again when its lease
+// runs out.
+func deliverEmailOutbox(ctx context.Context, sender EmailSender) error {
+	batch, err := claimEmails(DB.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	// An email that went out is recorded as sent even if shutdown starts meanwhile
+	db := DB.WithContext(context.WithoutCancel(ctx))
+	for _, email := range batch {
+		if ctx.Err() != nil {
+			return nil
+		}
+		attempts := email.Attempts + 1 // Counted by the claim
+		updates := map[string]interface{}{}
+
+		err := sender.Send(ctx, EmailMessage{
+			To:       email.ToAddress,
+			Subject:  email.Subject,
+			TextBody: email.TextBody,
+			HTMLBody: email.HTMLBody,
+		})
+		switch {
+		case err == nil:
+			updates["status"] = "sent"
+			updates["sent_at"] = time.Now()
+			updates["last_error"] = ""
+		case attempts >= emailMaxAttempts || isPermanentEmailError(err):
+			updates["status"] = "failed"
+			updates["last_error"] = err.Error()
+			slog.Error("❌ Giving up on email", "email_id", email.ID, "uid", email.UserID, "attempts", attempts, "error", err)
+		default:
+			updates["status"] = "pending"
+			updates["last_error"] = err.Error()
+			updates["next_attempt_at"] = time.Now().Add(emailRetryBackoff << email.Attempts)
+		}
+
+		if err := db.Model(&EmailOutbox{}).Where("id = ? AND status = ?", email.ID, "sending").Updates(updates).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartEmailOutboxWorker drains the email outbox every interval until shutdown
+func StartEmailOutboxWorker(sender EmailSender, interval time.Duration) {
+	runWorker(func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if err := deliverEmailOutbox(ctx, sender); err != nil {
+				slog.Error("❌ Failed to process email outbox", "error", err)
+			}
+		}
+	})
+}
+
+// InitEmail registers the email channel and starts the outbox worker when SMTP is configured
+func InitEmail(cfg SMTPConfig) {
+	if cfg.Host == "" {
+		slog.Info("ℹ️  SMTP_HOST not set, email notifications disabled")
+		return
+	}
+
+	sender := SMTPSender{
+		Host:     cfg.Host,
+		Port:     cfg.Port,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		From:     cfg.From,
+		Timeout:  cfg.Timeout,
+	}
+	if sender.From == "" {
+		sender.From = sender.Username
+	}
+
+	RegisterNotificationChannel(EmailChannel{})
+	StartEmailOutboxWorker(sender, 10*time.Second)
+	slog.Info("✅ Email notifications enabled", "smtp_host", sender.Host, "smtp_port", sender.Port)
+}
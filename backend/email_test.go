@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSMTPServer is an in-process SMTP server. It answers DATA with reply, which tests change
+// between deliveries, and keeps the messages it accepts.
+type fakeSMTPServer struct {
+	host, port string
+
+	mu       sync.Mutex
+	reply    string
+	received []string
+}
+
+func startFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	s := &fakeSMTPServer{host: host, port: port, reply: "250 OK"}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTPServer) setReply(reply string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reply = reply
+}
+
+func (s *fakeSMTPServer) messages() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.received...)
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	write := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	write("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			write("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL"), strings.HasPrefix(cmd, "RCPT"), strings.HasPrefix(cmd, "RSET"), strings.HasPrefix(cmd, "NOOP"):
+			write("250 OK")
+		case cmd == "DATA":
+			write("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			s.mu.Lock()
+			reply := s.reply
+			if strings.HasPrefix(reply, "250") {
+				s.received = append(s.received, data.String())
+			}
+			s.mu.Unlock()
+			write(reply)
+		case cmd == "QUIT":
+			write("221 Bye")
+			return
+		default:
+			write("502 Command not implemented")
+		}
+	}
+}
+
+func (s *fakeSMTPServer) sender() SMTPSender {
+	return SMTPSender{Host: s.host, Port: s.port, From: "noreply@brocab.test"}
+}
+
+// queueEmail adds a pending email to the outbox
+func queueEmail(t *testing.T, subject string) EmailOutbox {
+	t.Helper()
+
+	email := EmailOutbox{
+		UserID: "uid-email", ToAddress: "rider@example.com", Subject: subject,
+		TextBody: "Hello", HTMLBody: "<p>Hello</p>", Status: "pending", NextAttemptAt: time.Now().Add(-time.Second),
+	}
+	if err := DB.Create(&email).Error; err != nil {
+		t.Fatalf("queue email: %v", err)
+	}
+	return email
+}
+
+func reloadEmail(t *testing.T, id uint) EmailOutbox {
+	t.Helper()
+
+	var email EmailOutbox
+	if err := DB.First(&email, id).Error; err != nil {
+		t.Fatalf("reload email: %v", err)
+	}
+	return email
+}
+
+func TestDeliverEmailOutboxSuccess(t *testing.T) {
+	newTestDB(t)
+	server := startFakeSMTPServer(t)
+	email := queueEmail(t, "Ride cancelled")
+
+	if err := deliverEmailOutbox(context.Background(), server.sender()); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+
+	got := reloadEmail(t, email.ID)
+	if got.Status != "sent" || got.Attempts != 1 || got.SentAt == nil {
+		t.Fatalf("email = %+v, want sent after 1 attempt", got)
+	}
+	msgs := server.messages()
+	if len(msgs) != 1 || !strings.Contains(msgs[0], "To: rider@example.com") || !strings.Contains(msgs[0], "Ride cancelled") {
+		t.Fatalf("server received %q", msgs)
+	}
+
+	// A sent email is not sent again
+	if err := deliverEmailOutbox(context.Background(), server.sender()); err != nil {
+		t.Fatalf("deliver again: %v", err)
+	}
+	if n := len(server.messages()); n != 1 {
+		t.Fatalf("server received %d messages, want 1", n)
+	}
+}
+
+func TestDeliverEmailOutboxRetriesWithBackoff(t *testing.T) {
+	newTestDB(t)
+	server := startFakeSMTPServer(t)
+	server.setReply("451 Try again later")
+	email := queueEmail(t, "Join request")
+
+	before := time.Now()
+	if err := deliverEmailOutbox(context.Background(), server.sender()); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+	got := reloadEmail(t, email.ID)
+	if got.Status != "pending" || got.Attempts != 1 || !strings.Contains(got.LastError, "451") {
+		t.Fatalf("email = %+v, want pending with the 451 recorded", got)
+	}
+	if wait := got.NextAttemptAt.Sub(before); wait < emailRetryBackoff || wait > emailRetryBackoff+5*time.Second {
+		t.Fatalf("retry in %s, want about %s", wait, emailRetryBackoff)
+	}
+
+	// Not due yet, so a run now leaves it alone
+	if err := deliverEmailOutbox(context.Background(), server.sender()); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+	if got := reloadEmail(t, email.ID); got.Attempts != 1 {
+		t.Fatalf("attempts = %d before the retry is due, want 1", got.Attempts)
+	}
+
+	// The second failure backs off twice as long
+	DB.Model(&EmailOutbox{}).Where("id = ?", email.ID).Update("next_attempt_at", time.Now().Add(-time.Second))
+	before = time.Now()
+	if err := deliverEmailOutbox(context.Background(), server.sender()); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+	got = reloadEmail(t, email.ID)
+	if wait := got.NextAttemptAt.Sub(before); got.Attempts != 2 || wait < 2*emailRetryBackoff || wait > 2*emailRetryBackoff+5*time.Second {
+		t.Fatalf("after 2 attempts retry in %s, want about %s", wait, 2*emailRetryBackoff)
+	}
+
+	// Once the server recovers the email goes out
+	server.setReply("250 OK")
+	DB.Model(&EmailOutbox{}).Where("id = ?", email.ID).Update("next_attempt_at", time.Now().Add(-time.Second))
+	if err := deliverEmailOutbox(context.Background(), server.sender()); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+	if got := reloadEmail(t, email.ID); got.Status != "sent" || got.Attempts != 3 || got.LastError != "" {
+		t.Fatalf("email = %+v, want sent on attempt 3", got)
+	}
+}
+
+func TestDeliverEmailOutboxPermanentFailure(t *testing.T) {
+	newTestDB(t)
+	server := startFakeSMTPServer(t)
+
+	// A 5xx reply fails the email at once
+	server.setReply("550 No such user")
+	rejected := queueEmail(t, "Rejected")
+	if err := deliverEmailOutbox(context.Background(), server.sender()); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+	if got := reloadEmail(t, rejected.ID); got.Status != "failed" || got.Attempts != 1 {
+		t.Fatalf("email = %+v, want failed after 1 attempt", got)
+	}
+
+	// Temporary failures give up after emailMaxAttempts
+	server.setReply("451 Try again later")
+	exhausted := queueEmail(t, "Exhausted")
+	DB.Model(&EmailOutbox{}).Where("id = ?", exhausted.ID).Update("attempts", emailMaxAttempts-1)
+	if err := deliverEmailOutbox(context.Background(), server.sender()); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+	if got := reloadEmail(t, exhausted.ID); got.Status != "failed" || got.Attempts != emailMaxAttempts {
+		t.Fatalf("email = %+v, want failed after %d attempts", got, emailMaxAttempts)
+	}
+}
+
+// emailSenderFunc adapts a function to EmailSender
+type emailSenderFunc func(EmailMessage) error
+
+func (f emailSenderFunc) Send(_ context.Context, msg EmailMessage) error { return f(msg) }
+
+func TestDeliverEmailOutboxSendsOutsideTransaction(t *testing.T) {
+	newTestDB(t)
+	email := queueEmail(t, "Leased")
+	other := queueEmail(t, "Other")
+	DB.Model(&EmailOutbox{}).Where("id = ?", other.ID).Update("next_attempt_at", time.Now().Add(time.Hour))
+
+	// While sending, the row is leased to this worker and the database is free: another
+	// worker's claim finds nothing, and writes don't wait on a transaction held for the batch
+	sender := emailSenderFunc(func(msg EmailMessage) error {
+		if got := reloadEmail(t, email.ID); got.Status != "sending" || !got.NextAttemptAt.After(time.Now().Add(emailLease-time.Minute)) {
+			return errors.New("email not leased while sending")
+		}
+		if batch, err := claimEmails(DB); err != nil || len(batch) != 0 {
+			return errors.New("a leased email was claimed twice")
+		}
+		return DB.Model(&EmailOutbox{}).Where("id = ?", other.ID).Update("last_error", "touched").Error
+	})
+	if err := deliverEmailOutbox(context.Background(), sender); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+	if got := reloadEmail(t, email.ID); got.Status != "sent" {
+		t.Fatalf("email = %+v (last error %q), want sent", got, got.LastError)
+	}
+}
+
+func TestExpiredEmailLeaseIsReclaimed(t *testing.T) {
+	newTestDB(t)
+	email := queueEmail(t, "Abandoned")
+
+	// A worker claimed the email and died
+	if batch, err := claimEmails(DB); err != nil || len(batch) != 1 {
+		t.Fatalf("claim = %v, %v", batch, err)
+	}
+	if batch, err := claimEmails(DB); err != nil || len(batch) != 0 {
+		t.Fatalf("claimed a leased email: %v, %v", batch, err)
+	}
+
+	DB.Model(&EmailOutbox{}).Where("id = ?", email.ID).Update("next_attempt_at", time.Now().Add(-time.Second))
+	batch, err := claimEmails(DB)
+	if err != nil || len(batch) != 1 || batch[0].ID != email.ID {
+		t.Fatalf("expired lease not reclaimed: %v, %v", batch, err)
+	}
+	if got := reloadEmail(t, email.ID); got.Attempts != 2 {
+		t.Fatalf("attempts = %d, want both claims counted", got.Attempts)
+	}
+}
+
+// startStalledSMTPServer accepts connections and never answers, like a hung mail server
+func startStalledSMTPServer(t *testing.T) SMTPSender {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	return SMTPSender{Host: host, Port: port, From: "noreply@brocab.test"}
+}
+
+func TestSMTPSenderTimesOut(t *testing.T) {
+	sender := startStalledSMTPServer(t)
+	sender.Timeout = 100 * time.Millisecond
+
+	start := time.Now()
+	err := sender.Send(context.Background(), EmailMessage{To: "rider@example.com", Subject: "Hi", TextBody: "Hello"})
+	if err == nil {
+		t.Fatal("sent through a server that never answered")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("gave up after %s, want about %s", elapsed, sender.Timeout)
+	}
+}
+
+func TestSMTPSenderStopsOnCancel(t *testing.T) {
+	sender := startStalledSMTPServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	if err := sender.Send(ctx, EmailMessage{To: "rider@example.com", Subject: "Hi", TextBody: "Hello"}); err == nil {
+		t.Fatal("sent through a server that never answered")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("gave up after %s, want soon after the cancel", elapsed)
+	}
+}
+
+func TestDeliverEmailOutboxStopsOnShutdown(t *testing.T) {
+	newTestDB(t)
+	first := queueEmail(t, "First")
+	second := queueEmail(t, "Second")
+
+	// Shutdown starts while the first email is going out
+	ctx, cancel := context.WithCancel(context.Background())
+	sender := emailSenderFunc(func(msg EmailMessage) error {
+		cancel()
+		return nil
+	})
+	if err := deliverEmailOutbox(ctx, sender); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+
+	// The email that went out is recorded; the other waits for its lease to run out
+	if got := reloadEmail(t, first.ID); got.Status != "sent" {
+		t.Fatalf("first email = %+v, want sent", got)
+	}
+	if got := reloadEmail(t, second.ID); got.Status != "sending" {
+		t.Fatalf("second email = %+v, want left leased", got)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIError is the error body returned by every endpoint:
+//
+//	{"error": "Ride not found", "code": "RIDE_NOT_FOUND", "details": {...}}
+//
+// "error" stays human readable; clients should branch on "code", which is stable.
+type APIError struct {
+	Status  int                    `json:"-"`
+	Code    string                 `json:"code"`
+	Message string                 `json:"error"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// Error code catalog. Codes are part of the API contract: never rename one, add a new code instead.
+const (
+	// 400 Bad Request
+	CodeInvalidRequest = "INVALID_REQUEST" // Malformed body, query parameter or path parameter
+	CodeRideFull       = "RIDE_FULL"       // No seats left on the ride
+
+	// 401 Unauthorized
+	CodeAuthHeaderMissing = "AUTH_HEADER_MISSING" // No Authorization header
+	CodeAuthHeaderInvalid = "AUTH_HEADER_INVALID" // Authorization header is not "Bearer <token>"
+	CodeAuthTokenInvalid  = "AUTH_TOKEN_INVALID"  // Firebase ID token is invalid or expired
+	CodeUnauthenticated   = "UNAUTHENTICATED"     // No authenticated user on the request
+
+	// 403 Forbidden
+	CodeNotRideLeader = "NOT_RIDE_LEADER" // Only the ride leader may do this
+	CodeNoPrivilege   = "NO_PRIVILEGE"    // No approved join request for this ride
+
+	// 404 Not Found
+	CodeUserNotFound         = "USER_NOT_FOUND"
+	CodeRideNotFound         = "RIDE_NOT_FOUND"
+	CodeLeaderNotFound       = "LEADER_NOT_FOUND"
+	CodeRequestNotFound      = "REQUEST_NOT_FOUND" // Join request missing or no longer pending
+	CodeParticipantNotFound  = "PARTICIPANT_NOT_FOUND"
+	CodeNotificationNotFound = "NOTIFICATION_NOT_FOUND"
+	CodeDeviceNotFound       = "DEVICE_NOT_FOUND"
+	CodeSavedSearchNotFound  = "SAVED_SEARCH_NOT_FOUND"
+	CodeTripRequestNotFound  = "TRIP_REQUEST_NOT_FOUND" // Trip request missing or no longer open
+	CodeNoInvolvement        = "NO_INVOLVEMENT"         // User neither requested nor joined the ride
+
+	// 409 Conflict
+	CodeSameDayConflict    = "SAME_DAY_CONFLICT"   // Cannot both lead a ride and request to join one on the same date
+	CodeRequestPending     = "REQUEST_PENDING"     // A join request for this ride is already pending
+	CodeRequestApproved    = "REQUEST_APPROVED"    // A join request for this ride is already approved
+	CodeRequestCooldown    = "REQUEST_COOLDOWN"    // Request was revoked recently; details.remaining_cooldown_minutes
+	CodeAlreadyParticipant = "ALREADY_PARTICIPANT" // User already joined this ride
+	CodeSavedSearchLimit   = "SAVED_SEARCH_LIMIT"  // User has the maximum number of saved searches; details.limit
+	CodeTripRequestExists  = "TRIP_REQUEST_EXISTS" // User already has an open trip request for this date
+	CodeTripRequestClosed  = "TRIP_REQUEST_CLOSED" // Another leader answered the trip request first
+
+	// 429 Too Many Requests
+	CodeRateLimited = "RATE_LIMITED" // Rate limit exceeded; details.retry_after_seconds, also in Retry-After
+
+	// 500 Internal Server Error
+	CodeInternal = "INTERNAL_ERROR"
+
+	// 503 Service Unavailable
+	CodeDatabaseUnavailable = "DATABASE_UNAVAILABLE" // The database could not be reached
+
+	// 504 Gateway Timeout
+	CodeDatabaseTimeout = "DATABASE_TIMEOUT" // A database query took longer than DB_QUERY_TIMEOUT
+)
+
+// abortWithError records an error for ErrorHandler to render and stops the handler chain
+func abortWithError(c *gin.Context, status int, code, message string) {
+	abortWithAPIError(c, &APIError{Status: status, Code: code, Message: message})
+}
+
+// abortWithAPIError is abortWithError for errors that carry details
+func abortWithAPIError(c *gin.Context, err *APIError) {
+	_ = c.Error(err)
+	c.Abort()
+}
+
+// ErrorHandler renders the last error recorded on the context as an APIError response.
+// Errors that are not APIErrors are reported as a generic 500 without leaking internals.
+//
+// If a query timed out or the database was unreachable, the handler's error was almost certainly
+// caused by it (a lookup that timed out looks like "not found"), so 504 or 503 is returned instead.
+func ErrorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, dbFailure := trackDBFailures(c.Request.Context())
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+
+		err := c.Errors.Last().Err
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) {
+			apiErr = &APIError{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "Internal server error"}
+		}
+		if dbErr := dbFailure.Err(); dbErr != nil {
+			err = dbErr
+			apiErr = databaseAPIError(dbErr)
+		}
+
+		switch {
+		case errors.Is(ctx.Err(), context.Canceled):
+			// The client went away; nobody will read the response
+			slog.WarnContext(ctx, "request cancelled by client", "error", err)
+		case apiErr.Status >= http.StatusInternalServerError:
+			slog.ErrorContext(ctx, "request failed", "error", err)
+		}
+		apiErrorsTotal.WithLabelValues(apiErr.Code).Inc()
+		c.JSON(apiErr.Status, apiErr)
+	}
+}
+
+// databaseAPIError describes a database timeout or outage to the client
+func databaseAPIError(err error) *APIError {
+	if isDBTimeout(err) {
+		return &APIError{
+			Status:  http.StatusGatewayTimeout,
+			Code:    CodeDatabaseTimeout,
+			Message: "The database took too long to respond. Please try again.",
+		}
+	}
+	return &APIError{
+		Status:  http.StatusServiceUnavailable,
+		Code:    CodeDatabaseUnavailable,
+		Message: "The database is unavailable. Please try again shortly.",
+	}
+}
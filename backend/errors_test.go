@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func TestErrorHandlerDatabaseFailures(t *testing.T) {
+	tests := []struct {
+		name       string
+		dbErr      error // Hit by a statement while handling the request
+		wantStatus int
+		wantCode   string
+	}{
+		{"a statement timed out", fmt.Errorf("find ride: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, CodeDatabaseTimeout},
+		{"the connection was lost", sql.ErrConnDone, http.StatusServiceUnavailable, CodeDatabaseUnavailable},
+		{"the row doesn't exist", gorm.ErrRecordNotFound, http.StatusNotFound, CodeRideNotFound},
+		{"no database error", nil, http.StatusNotFound, CodeRideNotFound},
+	}
+	for _, tt := range tests {
+		// The handler reports the failed lookup as "not found", as handlers do
+		handler := func(c *gin.Context) {
+			if tt.dbErr != nil {
+				recordDBFailure(c.Request.Context(), tt.dbErr)
+			}
+			abortWithError(c, http.StatusNotFound, CodeRideNotFound, "Ride not found")
+		}
+
+		var body APIError
+		decodeJSON(t, serve(t, handler, "GET", "/ride/:rideID", "/ride/1", "", nil), tt.wantStatus, &body)
+		if body.Code != tt.wantCode {
+			t.Errorf("%s: code = %s, want %s", tt.name, body.Code, tt.wantCode)
+		}
+	}
+}
+
+func TestErrorHandlerHidesInternalErrors(t *testing.T) {
+	handler := func(c *gin.Context) {
+		c.Error(errors.New("pq: relation \"rides\" does not exist"))
+	}
+
+	var body APIError
+	decodeJSON(t, serve(t, handler, "GET", "/rides", "/rides", "", nil), http.StatusInternalServerError, &body)
+	if body.Code != CodeInternal || body.Message != "Internal server error" {
+		t.Fatalf("body = %+v, want a generic internal error", body)
+	}
+}
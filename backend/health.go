@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const readinessCheckTimeout = 2 * time.Second
+
+// Set when shutdown starts so load balancers stop routing new requests here while in-flight ones drain
+var shuttingDown atomic.Bool
+
+// GET /healthz - Liveness: the process is up and serving HTTP
+func Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// GET /readyz - Readiness: the database answers and the token verifier is initialized
+func Readyz(c *gin.Context) {
+	if shuttingDown.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "shutting down"})
+		return
+	}
+
+	checks := gin.H{"database": "ok", "auth": "ok"}
+	ready := true
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessCheckTimeout)
+	defer cancel()
+
+	if DB == nil {
+		checks["database"] = "not connected"
+		ready = false
+	} else if sqlDB, err := DB.DB(); err != nil {
+		checks["database"] = err.Error()
+		ready = false
+	} else if err := sqlDB.PingContext(ctx); err != nil {
+		checks["database"] = "unreachable"
+		ready = false
+	}
+
+	if authClient == nil {
+		checks["auth"] = "not initialized"
+		ready = false
+	}
+
+	if !ready {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "checks": checks})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "checks": checks})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"firebase.google.com/go/v4/auth"
+)
+
+// readinessResponse is the body of GET /readyz
+type readinessResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// withAuthClient stands in an initialized token verifier until the test ends
+func withAuthClient(t *testing.T) {
+	t.Helper()
+
+	prev := authClient
+	authClient = &auth.Client{}
+	t.Cleanup(func() { authClient = prev })
+}
+
+func TestHealthz(t *testing.T) {
+	// Liveness doesn't depend on anything but the process
+	var body readinessResponse
+	decodeJSON(t, serve(t, Healthz, "GET", "/healthz", "/healthz", "", nil), http.StatusOK, &body)
+	if body.Status != "ok" {
+		t.Fatalf("status = %q, want ok", body.Status)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	db := newTestDB(t)
+	withAuthClient(t)
+
+	var body readinessResponse
+	decodeJSON(t, serve(t, Readyz, "GET", "/readyz", "/readyz", "", nil), http.StatusOK, &body)
+	if body.Status != "ok" || body.Checks["database"] != "ok" || body.Checks["auth"] != "ok" {
+		t.Fatalf("body = %+v, want every check ok", body)
+	}
+
+	// The database stops answering
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.Close()
+	decodeJSON(t, serve(t, Readyz, "GET", "/readyz", "/readyz", "", nil), http.StatusServiceUnavailable, &body)
+	if body.Checks["database"] != "unreachable" || body.Checks["auth"] != "ok" {
+		t.Fatalf("checks = %v, want the database reported unreachable", body.Checks)
+	}
+}
+
+func TestReadyzWithoutAuth(t *testing.T) {
+	newTestDB(t)
+	prev := authClient
+	authClient = nil
+	t.Cleanup(func() { authClient = prev })
+
+	var body readinessResponse
+	decodeJSON(t, serve(t, Readyz, "GET", "/readyz", "/readyz", "", nil), http.StatusServiceUnavailable, &body)
+	if body.Checks["auth"] != "not initialized" || body.Checks["database"] != "ok" {
+		t.Fatalf("checks = %v, want auth reported not initialized", body.Checks)
+	}
+}
+
+func TestReadyzWhileShuttingDown(t *testing.T) {
+	newTestDB(t)
+	withAuthClient(t)
+	shuttingDown.Store(true)
+	t.Cleanup(func() { shuttingDown.Store(false) })
+
+	// Healthy otherwise, but load balancers must stop sending new requests
+	var body readinessResponse
+	decodeJSON(t, serve(t, Readyz, "GET", "/readyz", "/readyz", "", nil), http.StatusServiceUnavailable, &body)
+	if body.Status != "shutting down" {
+		t.Fatalf("status = %q, want shutting down", body.Status)
+	}
+	if w := serve(t, Healthz, "GET", "/healthz", "/healthz", "", nil); w.Code != http.StatusOK {
+		t.Fatalf("healthz = %d while draining, want 200", w.Code)
+	}
+}
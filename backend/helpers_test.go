@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newTestDB points DB at a fresh SQLite database with every table migrated, until the test ends.
+// SQLite stands in for Postgres, so handlers relying on Postgres-only SQL (ILIKE, SKIP LOCKED
+// row locks) aren't covered here.
+func newTestDB(tb testing.TB) *gorm.DB {
+	tb.Helper()
+
+	dsn := filepath.Join(tb.TempDir(), "test.db") + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		tb.Fatalf("open test database: %v", err)
+	}
+	if err := db.AutoMigrate(models...); err != nil {
+		tb.Fatalf("migrate test database: %v", err)
+	}
+
+	// Place lookups are cached as in production, and start empty for each database
+	prevDB, prevPlaces, prevTTL, prevMissTTL := DB, placeLookupCache, placeLookupTTL, placeMissTTL
+	DB = db
+	placeLookupCache = newMeteredCache[uint]("place", NewMemoryCache[uint](10000))
+	placeLookupTTL, placeMissTTL = time.Hour, time.Minute
+	tb.Cleanup(func() {
+		DB, placeLookupCache, placeLookupTTL, placeMissTTL = prevDB, prevPlaces, prevTTL, prevMissTTL
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// statementCounter counts the SQL statements run on a database
+type statementCounter struct{ n atomic.Int64 }
+
+func countStatements(tb testing.TB, db *gorm.DB) *statementCounter {
+	tb.Helper()
+
+	counter := &statementCounter{}
+	noop := func(string) func(*gorm.DB) { return func(*gorm.DB) {} }
+	count := func(string) func(*gorm.DB) { return func(*gorm.DB) { counter.n.Add(1) } }
+	if err := registerGormCallbacks(db, "test_counter", noop, count); err != nil {
+		tb.Fatalf("register statement counter: %v", err)
+	}
+	return counter
+}
+
+func (s *statementCounter) Count() int64 { return s.n.Load() }
+
+// serve runs one request through handler, mounted at route behind ErrorHandler as in the real
+// router. A non-empty uid stands in for the Firebase auth middleware.
+func serve(tb testing.TB, handler gin.HandlerFunc, method, route, path, uid string, body interface{}) *httptest.ResponseRecorder {
+	tb.Helper()
+
+	r := gin.New()
+	r.Use(ErrorHandler())
+	r.Handle(method, route, func(c *gin.Context) {
+		if uid != "" {
+			c.Set("uid", uid)
+		}
+	}, handler)
+
+	var raw []byte
+	if body != nil {
+		var err error
+		if raw, err = json.Marshal(body); err != nil {
+			tb.Fatalf("encode request body: %v", err)
+		}
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// decodeJSON unmarshals a response body into v, failing the test if the status isn't want
+func decodeJSON(tb testing.TB, w *httptest.ResponseRecorder, want int, v interface{}) {
+	tb.Helper()
+
+	if w.Code != want {
+		tb.Fatalf("status = %d, want %d; body: %s", w.Code, want, w.Body.String())
+	}
+	if v == nil {
+		return
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		tb.Fatalf("decode response: %v; body: %s", err, w.Body.String())
+	}
+}
+
+var seq atomic.Int64
+
+// seedUser creates a user with a unique name, email and Firebase UID
+func seedUser(tb testing.TB, db *gorm.DB) *User {
+	tb.Helper()
+
+	n := seq.Add(1)
+	user := User{
+		Name:        fmt.Sprintf("User %d", n),
+		Email:       fmt.Sprintf("user%d@example.com", n),
+		Phone:       "9999999999",
+		Gender:      "female",
+		FirebaseUID: fmt.Sprintf("uid-%d", n),
+	}
+	if err := db.Create(&user).Error; err != nil {
+		tb.Fatalf("seed user: %v", err)
+	}
+	return &user
+}
+
+// seedRide creates a ride led by leader between two places, resolving them as AddRide does
+func seedRide(tb testing.TB, db *gorm.DB, leader *User, origin, destination, date, t string, seats int) Ride {
+	tb.Helper()
+
+	ride := Ride{
+		LeaderID:    leader.ID,
+		Origin:      origin,
+		Destination: destination,
+		Date:        date,
+		Time:        t,
+		Seats:       seats,
+		Price:       100,
+	}
+	if err := assignRidePlaces(db, &ride); err != nil {
+		tb.Fatalf("seed ride places: %v", err)
+	}
+	if err := db.Create(&ride).Error; err != nil {
+		tb.Fatalf("seed ride: %v", err)
+	}
+	return ride
+}
+
+// seedRequest creates a join request from user to ride with the given status
+func seedRequest(tb testing.TB, db *gorm.DB, user *User, ride Ride, status string) Request {
+	tb.Helper()
+
+	request := Request{RideID: ride.ID, UserID: user.FirebaseUID, Status: status}
+	if err := db.Create(&request).Error; err != nil {
+		tb.Fatalf("seed request: %v", err)
+	}
+	return request
+}
+
+// daysFromNow formats the date n days from today as YYYY-MM-DD
+func daysFromNow(n int) string {
+	return time.Now().AddDate(0, 0, n).Format("2006-01-02")
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// Incoming request IDs are reused only if they look like an ID, so clients can't inject into logs
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// requestLogFields are attached to every log line written with a request's context.
+// The middleware creates them; later middleware fills in what it learns (e.g. the UID after auth).
+type requestLogFields struct {
+	RequestID string
+	UID       string
+	RideID    string
+}
+
+type requestLogFieldsKey struct{}
+
+func logFieldsFromContext(ctx context.Context) *requestLogFields {
+	if ctx == nil {
+		return nil
+	}
+	fields, _ := ctx.Value(requestLogFieldsKey{}).(*requestLogFields)
+	return fields
+}
+
+// setLogUID records the authenticated user for the rest of the request's log lines
+func setLogUID(c *gin.Context, uid string) {
+	if fields := logFieldsFromContext(c.Request.Context()); fields != nil {
+		fields.UID = uid
+	}
+}
+
+// contextHandler adds the request fields and trace IDs found in the context to each record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if fields := logFieldsFromContext(ctx); fields != nil {
+		r.AddAttrs(slog.String("request_id", fields.RequestID))
+		if fields.UID != "" {
+			r.AddAttrs(slog.String("uid", fields.UID))
+		}
+		if fields.RideID != "" {
+			r.AddAttrs(slog.String("ride_id", fields.RideID))
+		}
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// InitLogging makes a JSON (or text) slog logger the default, which the standard log package also writes through
+func InitLogging(cfg LogConfig) {
+	opts := &slog.HandlerOptions{Level: cfg.level()}
+
+	var handler slog.Handler
+	if cfg.Format == "text" {
+		handler = slog.NewTextHandler(os.Stdout, opts)
+	} else {
+		handler = slog.NewJSONHandler(os.Stdout, opts)
+	}
+	slog.SetDefault(slog.New(contextHandler{handler}))
+}
+
+func (cfg LogConfig) level() slog.Level {
+	switch cfg.Level {
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// fatal logs at error level and exits, the slog equivalent of log.Fatal
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// RequestLogging assigns each request an ID (reusing a valid incoming X-Request-ID), returns it in the
+// X-Request-ID response header, puts it in the request context for every log line, and writes one
+// access log line per request. It replaces gin's default logger.
+func RequestLogging() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		requestID := c.GetHeader(requestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		c.Header(requestIDHeader, requestID)
+
+		fields := &requestLogFields{RequestID: requestID, RideID: c.Param("rideID")}
+		ctx := context.WithValue(c.Request.Context(), requestLogFieldsKey{}, fields)
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		attrs := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", c.FullPath(),
+			"status", status,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"client_ip", c.ClientIP(),
+		}
+
+		var apiErr *APIError
+		if len(c.Errors) > 0 && errors.As(c.Errors.Last().Err, &apiErr) {
+			attrs = append(attrs, "error_code", apiErr.Code)
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+		slog.Log(ctx, level, "request", attrs...)
+	}
+}
+
+// gormLogger sends GORM's logs through slog. Failed and slow statements are logged at
+// error and warn; every other statement only at debug.
+type gormLogger struct {
+	SlowThreshold time.Duration
+	LogLevel      logger.LogLevel
+}
+
+func (l gormLogger) LogMode(level logger.LogLevel) logger.Interface {
+	l.LogLevel = level
+	return l
+}
+
+func (l gormLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	if l.LogLevel >= logger.Info {
+		slog.InfoContext(ctx, fmt.Sprintf(msg, args...), "component", "gorm")
+	}
+}
+
+func (l gormLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	if l.LogLevel >= logger.Warn {
+		slog.WarnContext(ctx, fmt.Sprintf(msg, args...), "component", "gorm")
+	}
+}
+
+func (l gormLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	if l.LogLevel >= logger.Error {
+		slog.ErrorContext(ctx, fmt.Sprintf(msg, args...), "component", "gorm")
+	}
+}
+
+func (l gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.LogLevel <= logger.Silent {
+		return
+	}
+
+	elapsed := time.Since(begin)
+	sql, rows := fc()
+	attrs := []any{
+		"component", "gorm",
+		"sql", strings.TrimSpace(sql),
+		"rows", rows,
+		"duration_ms", float64(elapsed.Microseconds()) / 1000,
+	}
+
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && l.LogLevel >= logger.Error:
+		slog.ErrorContext(ctx, "query failed", append(attrs, "error", err)...)
+	case l.SlowThreshold > 0 && elapsed > l.SlowThreshold && l.LogLevel >= logger.Warn:
+		slog.WarnContext(ctx, "slow query", attrs...)
+	case l.LogLevel >= logger.Info:
+		slog.DebugContext(ctx, "query", attrs...)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// captureLogs sends slog's JSON output to a buffer until the test ends
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(contextHandler{slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})}))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return &buf
+}
+
+// logLines decodes the captured log lines
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+
+	var lines []map[string]interface{}
+	for _, raw := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var line map[string]interface{}
+		if err := json.Unmarshal([]byte(raw), &line); err != nil {
+			t.Fatalf("decode log line %q: %v", raw, err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestRequestIDInLogs(t *testing.T) {
+	db := newTestDB(t)
+	logged := db.Session(&gorm.Session{Logger: gormLogger{LogLevel: logger.Info}})
+
+	r := gin.New()
+	r.Use(RequestLogging())
+	r.GET("/ride/:rideID", func(c *gin.Context) {
+		setLogUID(c, "uid-logged")
+		var ride Ride
+		logged.WithContext(c.Request.Context()).Limit(1).Find(&ride)
+		slog.InfoContext(c.Request.Context(), "handled")
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name     string
+		incoming string
+		reused   bool
+	}{
+		{"a valid incoming ID", "req-42.abc_DEF", true},
+		{"no incoming ID", "", false},
+		{"an ID that could inject into logs", "bad id\",\"uid\":\"admin", false},
+	}
+	for _, tt := range tests {
+		buf := captureLogs(t)
+
+		req := httptest.NewRequest("GET", "/ride/7", nil)
+		if tt.incoming != "" {
+			req.Header.Set(requestIDHeader, tt.incoming)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		id := w.Header().Get(requestIDHeader)
+		if tt.reused && id != tt.incoming {
+			t.Fatalf("%s: X-Request-ID = %q, want %q reused", tt.name, id, tt.incoming)
+		}
+		if !tt.reused && (id == tt.incoming || !validRequestID.MatchString(id)) {
+			t.Fatalf("%s: X-Request-ID = %q, want a newly generated ID", tt.name, id)
+		}
+
+		// The query, the handler's own line and the access log all carry the request's fields
+		lines := logLines(t, buf)
+		msgs := map[string]bool{}
+		for _, line := range lines {
+			msgs[line["msg"].(string)] = true
+			if line["request_id"] != id || line["uid"] != "uid-logged" || line["ride_id"] != "7" {
+				t.Errorf("%s: log line %v, want request_id %q, uid and ride_id", tt.name, line, id)
+			}
+		}
+		if !msgs["query"] || !msgs["handled"] || !msgs["request"] {
+			t.Errorf("%s: logged %v, want the query, handler and access lines", tt.name, msgs)
+		}
+	}
+}
@@ -1,100 +1,176 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
 )
 
 func main() {
-	// Load environment variables from .env file
-	err := godotenv.Load()
+	// Load configuration from defaults, .env, the environment and flags
+	cfg, err := LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		// -h printed the usage, which is what was asked for
+		os.Exit(0)
+	}
+	if err != nil {
+		fatal("❌ Invalid configuration", "error", err)
+	}
+
+	// Structured logs from here on
+	InitLogging(cfg.Log)
+	slog.Info("⚙️  Configuration loaded", "config", cfg)
+
+	// Export spans for requests, queries and notification dispatch
+	shutdownTracing, err := InitTracing(cfg.Tracing)
 	if err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
+		fatal("❌ Failed to initialize tracing", "error", err)
+	}
+	if cfg.Env == "production" {
+		gin.SetMode(gin.ReleaseMode)
 	}
 
 	// Initialize Database
-	InitDatabase()
+	InitDatabase(cfg.Database)
+
+	// In-memory caches for users and verified tokens
+	InitCaches(cfg.Cache)
 
 	// Initialize Firebase Admin SDK (for token verification)
-	err = InitFirebase()
+	err = InitFirebase(cfg.Firebase)
 	if err != nil {
-		log.Fatalf("Failed to initialize Firebase: %v", err)
+		fatal("❌ Failed to initialize Firebase", "error", err)
+	}
+
+	// Push notifications reuse the Firebase app; failing here only disables push
+	if err := InitPush(); err != nil {
+		slog.Warn("⚠️  Push notifications disabled", "error", err)
 	}
 
-	r := gin.Default()
+	// Email notifications are optional and only enabled when SMTP is configured
+	InitEmail(cfg.SMTP)
 
-	// Configure trusted proxies for security
-	r.SetTrustedProxies([]string{"127.0.0.1", "::1"}) // Only trust localhost
+	// Per-route rate limit budgets
+	if err := InitRateLimits(cfg.RateLimits); err != nil {
+		fatal("❌ Invalid RATE_LIMITS", "error", err)
+	}
+
+	// Ride search relevance weights
+	if err := InitRanking(cfg.RankingWeights); err != nil {
+		fatal("❌ Invalid RANKING_WEIGHTS", "error", err)
+	}
+
+	r := gin.New()
+
+	// Start a span per request first, so every log line and query below carries its trace ID
+	r.Use(TracingMiddleware())
+
+	// Tag each request with an ID and write structured access logs; recover from panics as a 500
+	r.Use(RequestLogging(), gin.Recovery())
+
+	// Count and time every request, including the errors ErrorHandler renders
+	r.Use(MetricsMiddleware())
+
+	// Render errors recorded by handlers as structured APIError responses
+	r.Use(ErrorHandler())
+
+	// Only trust X-Forwarded-For from known proxies, so ClientIP (used for rate limiting) cannot be spoofed
+	if err := r.SetTrustedProxies(cfg.HTTP.TrustedProxies); err != nil {
+		fatal("❌ Invalid TRUSTED_PROXIES", "error", err)
+	}
 
 	// Configure CORS to allow frontend communication
-	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"*"}, // Allow all origins for production deployment
-		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", "Authorization", "Accept", "X-Requested-With"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: false, // Must be false when AllowOrigins is "*"
-		MaxAge:           12 * time.Hour,
-	}))
-
-	// Public route example
-	r.GET("/public", func(c *gin.Context) {
-		c.JSON(200, gin.H{"message": "This is a public endpoint"})
-	})
-
-	// Ping endpoint for testing connectivity
-	r.GET("/ping", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
-			"message": "pong",
-		})
-	})
-
-	// Protected routes (require authentication)
-	protected := r.Group("/")
-	protected.Use(FirebaseAuthMiddleware())
-
-	// User APIs
-	protected.GET("/user", GetCurrentUser)                                        // GET /user - Get current user profile
-	protected.PUT("/user", UpdateCurrentUser)                                     // PUT /user - Update current user profile
-	protected.POST("/user", CreateUser)                                           // POST /user
-	protected.GET("/user/:userID", GetUserBasic)                                  // GET /user/:userID
-	protected.GET("/user/rides/posted", GetRidesPostedByUser)                     // GET /user/rides/posted
-	protected.GET("/user/rides/joined", GetRidesJoinedByUser)                     // GET /user/rides/joined
-	protected.GET("/user/privileges", GetUserPrivileges)                          // GET /user/privileges
-	protected.GET("/user/requests", GetUserSentRequests)                          // GET /user/requests
-	protected.DELETE("/user/clear-involvement/:date", ClearInvolvementForDate)    // DELETE /user/clear-involvement/:date
-	protected.GET("/user/notifications", GetUserNotifications)                    // GET /user/notifications
-	protected.GET("/user/notifications/unread-count", GetUnreadNotificationCount) // GET /user/notifications/unread-count
-	protected.DELETE("/user/cancel-ride/:rideID", CancelRideParticipation)        // DELETE /user/cancel-ride/:rideID (unified)
-
-	// Ride APIs
-	protected.POST("/ride", AddRide)                                    // POST /ride
-	protected.DELETE("/ride/:rideID", DeleteRide)                       // DELETE /ride/:rideID - Leader deletes their ride
-	protected.GET("/ride/:rideID/leader", GetRideLeader)                // GET /ride/:rideID/leader
-	r.GET("/ride/filter", FilterRides)                                  // GET /rides/filter?origin=College Campus&destination=City Airport&date=2025-06-10
-	protected.GET("/ride/:rideID/requests", GetJoinRequestsForRide)     // GET /ride/:rideID/requests
-	protected.POST("/ride/:rideID/join", SendJoinRequest)               // POST /ride/:rideID/join
-	protected.DELETE("/ride/:rideID/cancel-request", CancelJoinRequest) // DELETE /ride/:rideID/cancel-request
-	protected.POST("/ride/:rideID/join-ride", JoinRideWithPrivilege)    // POST /ride/:rideID/join-ride
-
-	// Participant Management APIs (Leaders only)
-	protected.GET("/ride/:rideID/participants", GetRideParticipants)                // GET /ride/:rideID/participants
-	protected.DELETE("/ride/:rideID/participant/:participantID", RemoveParticipant) // DELETE /ride/:rideID/participant/:participantID
-	protected.POST("/ride/:rideID/approve/:requestID", ApproveJoinRequest)          // POST /ride/:rideID/approve/:requestID
-	protected.POST("/ride/:rideID/reject/:requestID", RejectJoinRequest)            // POST /ride/:rideID/reject/:requestID
-
-	// Notification APIs
-	protected.POST("/notification/:notificationID/read", MarkNotificationAsRead) // POST /notification/:notificationID/read
-
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-	log.Printf("🚀 Brocab server running on port %s", port)
-	r.Run(":" + port)
+	r.Use(CORSMiddleware(cfg.HTTP))
+
+	// Probes and metrics for the platform, outside the versioned API and its rate limits
+	r.GET("/healthz", Healthz)                               // GET /healthz - Liveness
+	r.GET("/readyz", Readyz)                                 // GET /readyz - Readiness (database and auth)
+	r.GET("/metrics", MetricsHandler(cfg.HTTP.MetricsToken)) // GET /metrics - Prometheus metrics
+
+	// Mount every API version under its prefix
+	for _, v := range apiVersions {
+		v.mount(r)
+	}
+
+	// Unversioned routes predate /v1. They stay as deprecated aliases of v1 until deployed clients move over.
+	mountLegacyRoutes(r)
+
+	// Refuse to start if a route was added or removed without updating the OpenAPI spec
+	for _, v := range apiVersions {
+		if err := validateOpenAPIRoutes(r.Routes(), v.Prefix); err != nil {
+			fatal("❌ OpenAPI spec out of date", "error", err, "version", v.Prefix)
+		}
+	}
+
+	// Purge old read notifications in the background
+	retention := time.Duration(cfg.Notifications.RetentionDays) * 24 * time.Hour
+	StartNotificationRetention(retention, time.Hour)
+
+	// Deliver notifications recorded by handlers in the outbox
+	StartNotificationOutboxWorker(5 * time.Second)
+	StartOutboxPurge(time.Hour)
+
+	// Deliver notifications held back during quiet hours
+	StartDigestWorker(5 * time.Minute)
+
+	// Drop saved searches once their date has passed
+	StartSavedSearchExpiry(time.Hour)
+
+	// Remind riders of rides about to leave
+	StartRideReminders(cfg.Notifications.ReminderLead, time.Minute)
+
+	srv := &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           r,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		slog.Info("🚀 Brocab server running", "port", cfg.Port)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("❌ Server failed", "error", err)
+		}
+	}()
+
+	// Wait for the platform to ask us to stop
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	slog.Info("🛑 Shutting down", "readiness_drain", cfg.HTTP.ReadinessDrain.String(), "drain_timeout", cfg.HTTP.ShutdownTimeout.String())
+	shuttingDown.Store(true)
+
+	// Keep serving while load balancers see /readyz fail and stop routing new requests here
+	time.Sleep(cfg.HTTP.ReadinessDrain)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+	defer cancel()
+
+	// Stop accepting connections and let in-flight requests finish
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Warn("⚠️  HTTP server did not drain in time", "error", err)
+	}
+
+	// Let background workers finish their current run
+	if err := StopWorkers(ctx); err != nil {
+		slog.Warn("⚠️  Background workers did not stop in time", "error", err)
+	}
+
+	if sqlDB, err := DB.DB(); err == nil {
+		sqlDB.Close()
+	}
+
+	// Flush spans still buffered for export
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Warn("⚠️  Failed to flush traces", "error", err)
+	}
+	slog.Info("👋 Server stopped")
 }
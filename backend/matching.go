@@ -0,0 +1,314 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// MatchRidesRequest is a rider's trip intent: either one of their open trip requests, or a route,
+// date and optional time window given directly
+type MatchRidesRequest struct {
+	TripRequestID uint   `json:"trip_request_id"` // Takes the intent from this trip request
+	Origin        string `json:"origin"`
+	Destination   string `json:"destination"`
+	Date          string `json:"date"`         // YYYY-MM-DD
+	TimeFrom      string `json:"time_from"`    // HH:mm, optional
+	TimeTo        string `json:"time_to"`      // HH:mm, optional
+	SeatsNeeded   int    `json:"seats_needed"` // Defaults to 1
+	AutoRequest   int    `json:"auto_request"` // Send join requests to this many of the best matches, at most 3
+	Limit         int    `json:"limit"`        // Suggestions returned, default 10, at most 25
+}
+
+// RideMatch is a suggested ride with its match score and the signals behind it, each from 0 to 1
+type RideMatch struct {
+	Ride         Ride    `json:"ride"`
+	LeaderName   string  `json:"leader_name"`
+	Score        float64 `json:"score"`
+	RouteScore   float64 `json:"route_score"`
+	TimeScore    float64 `json:"time_score"`
+	SeatsScore   float64 `json:"seats_score"`
+	RequestSent  bool    `json:"request_sent"`
+	RequestError string  `json:"request_error,omitempty"` // Error code when auto_request skipped this ride, e.g. REQUEST_COOLDOWN
+}
+
+// MatchRidesResponse lists suggested rides, best first
+type MatchRidesResponse struct {
+	Matches      []RideMatch `json:"matches"`
+	RequestsSent int         `json:"requests_sent"`
+}
+
+// Weights of the match signals. The score is their weighted average, so it stays between 0 and 1.
+var matchWeights = struct{ Route, Time, Seats float64 }{Route: 3, Time: 2, Seats: 1}
+
+const (
+	// Places are names, not coordinates, so route proximity is how alike the names are (see
+	// trigramSimilarity). Rides whose origin or destination is less alike than this are not suggested.
+	minMatchPlaceSimilarity = 0.4
+
+	defaultMatchLimit = 10
+	maxMatchLimit     = 25
+	maxAutoRequests   = 3
+)
+
+// tripIntent is what matching needs from a MatchRidesRequest
+type tripIntent struct {
+	origin, destination string // Normalized
+	originPlaceID       uint   // 0 when the name matches no place
+	destinationPlaceID  uint
+	date                string
+	timeFrom, timeTo    string
+	seatsNeeded         int
+}
+
+// placeProximity scores how close a ride's place is to the one asked for
+func placeProximity(wantedID uint, wanted string, place Place) float64 {
+	if wantedID != 0 && wantedID == place.ID {
+		return 1
+	}
+	return trigramSimilarity(wanted, place.Normalized)
+}
+
+// timeWindowFit is 1 for a departure inside the window and falls to 0 at timeProximityWindow outside it
+func timeWindowFit(t, timeFrom, timeTo string) float64 {
+	if inTimeWindow(t, timeFrom, timeTo) {
+		return 1
+	}
+	edge := timeFrom
+	if timeTo != "" && t > timeTo {
+		edge = timeTo
+	}
+	diff, ok := minutesApart(t, edge)
+	if !ok {
+		return 0
+	}
+	return math.Max(0, 1-diff/timeProximityWindow.Minutes())
+}
+
+// matchRides scores the rides on the intent's date that the rider could join, best first. Rides
+// that have left, and rides the rider is in or has a pending or approved request for, are skipped.
+func matchRides(db *gorm.DB, rider *User, intent tripIntent) ([]RideMatch, error) {
+	query := db.Where("date = ? AND seats - seats_filled >= ? AND leader_id <> ?", intent.date, intent.seatsNeeded, rider.ID).
+		Where("id NOT IN (?)", db.Model(&Participant{}).Select("ride_id").Where("user_id = ?", rider.FirebaseUID)).
+		Where("id NOT IN (?)", db.Model(&Request{}).Select("ride_id").
+			Where("user_id = ? AND status IN ?", rider.FirebaseUID, []string{"pending", "approved"}))
+	if now := time.Now(); intent.date == now.Format("2006-01-02") {
+		query = query.Where("time > ?", now.Format("15:04"))
+	}
+
+	var rides []Ride
+	if err := query.Order("time, id").Find(&rides).Error; err != nil {
+		return nil, err
+	}
+
+	placeIDs := make([]uint, 0, 2*len(rides))
+	for _, r := range rides {
+		if r.OriginPlaceID != nil && r.DestinationPlaceID != nil {
+			placeIDs = append(placeIDs, *r.OriginPlaceID, *r.DestinationPlaceID)
+		}
+	}
+	places := map[uint]Place{}
+	if len(placeIDs) > 0 {
+		var rows []Place
+		if err := db.Where("id IN ?", placeIDs).Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, p := range rows {
+			places[p.ID] = p
+		}
+	}
+
+	totalWeight := matchWeights.Route + matchWeights.Time + matchWeights.Seats
+	var matches []RideMatch
+	for _, r := range rides {
+		if r.OriginPlaceID == nil || r.DestinationPlaceID == nil {
+			continue
+		}
+		origin := placeProximity(intent.originPlaceID, intent.origin, places[*r.OriginPlaceID])
+		destination := placeProximity(intent.destinationPlaceID, intent.destination, places[*r.DestinationPlaceID])
+		if origin < minMatchPlaceSimilarity || destination < minMatchPlaceSimilarity {
+			continue
+		}
+
+		m := RideMatch{
+			Ride:       r,
+			RouteScore: (origin + destination) / 2,
+			TimeScore:  timeWindowFit(r.Time, intent.timeFrom, intent.timeTo),
+			SeatsScore: math.Min(float64(r.Seats-r.SeatsFilled), seatsForFullScore) / seatsForFullScore,
+		}
+		if m.TimeScore == 0 {
+			continue // Too far outside the window to be of use
+		}
+		m.Score = (matchWeights.Route*m.RouteScore + matchWeights.Time*m.TimeScore + matchWeights.Seats*m.SeatsScore) / totalWeight
+		matches = append(matches, m)
+	}
+
+	// Rides arrive in time order, so equal scores keep the earlier departure first
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+	return matches, nil
+}
+
+// loadTripIntent builds the intent from the request body, or from the rider's trip request if it names one
+func loadTripIntent(db *gorm.DB, userID string, req MatchRidesRequest) (tripIntent, *APIError) {
+	if req.TripRequestID != 0 {
+		var trip TripRequest
+		if err := db.Where("id = ? AND user_id = ? AND status = ?", req.TripRequestID, userID, "open").First(&trip).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return tripIntent{}, &APIError{Status: http.StatusNotFound, Code: CodeTripRequestNotFound, Message: "Trip request not found or no longer open"}
+			}
+			return tripIntent{}, &APIError{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "Failed to fetch trip request"}
+		}
+		req.Origin, req.Destination, req.Date = trip.Origin, trip.Destination, trip.Date
+		req.TimeFrom, req.TimeTo, req.SeatsNeeded = trip.TimeFrom, trip.TimeTo, trip.SeatsNeeded
+	}
+
+	if normalizePlaceName(req.Origin) == "" || normalizePlaceName(req.Destination) == "" {
+		return tripIntent{}, &APIError{Status: http.StatusBadRequest, Code: CodeInvalidRequest, Message: "Origin and destination are required"}
+	}
+	if err := validateTripWindow(req.Date, req.TimeFrom, req.TimeTo); err != nil {
+		return tripIntent{}, &APIError{Status: http.StatusBadRequest, Code: CodeInvalidRequest, Message: err.Error()}
+	}
+
+	intent := tripIntent{
+		origin:      normalizePlaceName(req.Origin),
+		destination: normalizePlaceName(req.Destination),
+		date:        req.Date,
+		timeFrom:    req.TimeFrom,
+		timeTo:      req.TimeTo,
+		seatsNeeded: max(req.SeatsNeeded, 1),
+	}
+
+	// Names that resolve to a place match its rides exactly, whatever the spelling
+	origin, err := findPlace(db, req.Origin)
+	if err != nil {
+		return tripIntent{}, &APIError{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "Failed to resolve origin and destination"}
+	}
+	destination, err := findPlace(db, req.Destination)
+	if err != nil {
+		return tripIntent{}, &APIError{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "Failed to resolve origin and destination"}
+	}
+	if origin != nil {
+		intent.originPlaceID = origin.ID
+	}
+	if destination != nil {
+		intent.destinationPlaceID = destination.ID
+	}
+	return intent, nil
+}
+
+// POST /ride/match - Suggest rides for a trip intent, best first, optionally sending join requests to the top matches
+func MatchRides(c *gin.Context) {
+	ctx := c.Request.Context()
+	db := DB.WithContext(ctx)
+
+	userID := c.MustGet("uid").(string)
+
+	var req MatchRidesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request data")
+		return
+	}
+	if req.AutoRequest < 0 || req.AutoRequest > maxAutoRequests {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "auto_request must be between 0 and 3")
+		return
+	}
+	if req.Limit < 0 {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "limit must be a positive integer")
+		return
+	}
+	limit := defaultMatchLimit
+	if req.Limit > 0 {
+		limit = min(req.Limit, maxMatchLimit)
+	}
+
+	user, err := getUser(ctx, userID)
+	if err != nil {
+		abortWithError(c, http.StatusNotFound, CodeUserNotFound, "User not found")
+		return
+	}
+
+	intent, apiErr := loadTripIntent(db, userID, req)
+	if apiErr != nil {
+		abortWithAPIError(c, apiErr)
+		return
+	}
+
+	// Auto-requests follow the same-day rule of SendJoinRequest; refuse up front rather than fail per ride
+	if req.AutoRequest > 0 {
+		existingRideCount, err := countSameDayRides(db, user.ID, intent.date)
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to check existing rides")
+			return
+		}
+		if existingRideCount > 0 {
+			abortWithAPIError(c, &APIError{
+				Status:  http.StatusConflict,
+				Code:    CodeSameDayConflict,
+				Message: "You cannot send join requests and create a ride on the same day. You have already created a ride for " + intent.date,
+				Details: map[string]interface{}{"date": intent.date},
+			})
+			return
+		}
+	}
+
+	matches, err := matchRides(db, user, intent)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to match rides")
+		return
+	}
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	leaderIDs := make([]uint, 0, len(matches))
+	for _, m := range matches {
+		leaderIDs = append(leaderIDs, m.Ride.LeaderID)
+	}
+	leaders, err := getUsersByIDs(ctx, leaderIDs)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to fetch ride leaders")
+		return
+	}
+	for i := range matches {
+		matches[i].LeaderName = leaders[matches[i].Ride.LeaderID].Name
+	}
+
+	// Request the best matches in order. A ride the rider is cooling down from is skipped and the
+	// next one tried, until auto_request requests are sent. Each request counts towards the rider's
+	// join_request budget like one sent by hand; once it runs out no more are sent.
+	sent := 0
+	for i := range matches {
+		if sent == req.AutoRequest {
+			break
+		}
+		leader, ok := leaders[matches[i].Ride.LeaderID]
+		if !ok {
+			matches[i].RequestError = CodeLeaderNotFound
+			continue
+		}
+		if !chargeRateLimit(ctx, "join_request", userID) {
+			matches[i].RequestError = CodeRateLimited
+			break
+		}
+		if apiErr := sendJoinRequest(db, user, matches[i].Ride, &leader); apiErr != nil {
+			if apiErr.Status >= http.StatusInternalServerError {
+				abortWithAPIError(c, apiErr)
+				return
+			}
+			matches[i].RequestError = apiErr.Code
+			continue
+		}
+		matches[i].RequestSent = true
+		sent++
+	}
+
+	if matches == nil {
+		matches = []RideMatch{}
+	}
+	c.JSON(http.StatusOK, MatchRidesResponse{Matches: matches, RequestsSent: sent})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTimeWindowFit(t *testing.T) {
+	tests := []struct {
+		t, from, to string
+		want        float64
+	}{
+		{"09:00", "08:00", "10:00", 1},
+		{"08:00", "08:00", "10:00", 1}, // Edges are inside
+		{"10:00", "08:00", "10:00", 1},
+		{"07:30", "08:00", "10:00", 0.833},
+		{"11:00", "08:00", "10:00", 0.667},
+		{"13:00", "08:00", "10:00", 0}, // timeProximityWindow outside
+		{"14:00", "08:00", "10:00", 0},
+		{"06:30", "08:00", "", 0.5}, // Open-ended windows
+		{"23:00", "08:00", "", 1},
+		{"11:30", "", "10:00", 0.5},
+		{"03:00", "", "", 1},
+	}
+	for _, tt := range tests {
+		if got := timeWindowFit(tt.t, tt.from, tt.to); math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("timeWindowFit(%q, %q, %q) = %.3f, want %.3f", tt.t, tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestPlaceProximity(t *testing.T) {
+	campus := Place{ID: 1, Normalized: "college campus"}
+	tests := []struct {
+		name     string
+		wantedID uint
+		wanted   string
+		want     float64
+	}{
+		{"the same place", 1, "iit campus", 1}, // An alias resolves to the place whatever it looks like
+		{"a name matching no place", 0, "colege campus", 0.8},
+		{"another place with a similar name", 2, "colege campus", 0.8},
+		{"an unrelated name", 0, "railway station", 0},
+	}
+	for _, tt := range tests {
+		if got := placeProximity(tt.wantedID, tt.wanted, campus); math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("%s: placeProximity = %.3f, want %.3f", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMatchRidesRanking(t *testing.T) {
+	db := newTestDB(t)
+	leader, rider := seedUser(t, db), seedUser(t, db)
+	date := daysFromNow(1)
+
+	// Scores with the window 08:00-10:00: route 3, time 2 and seats 1, out of 6
+	inWindow := seedRide(t, db, leader, "College Campus", "Airport", date, "09:00", 4)   // 1
+	earlier := seedRide(t, db, leader, "College Campus", "Airport", date, "08:30", 4)    // 1, ties go to the earlier ride
+	justBefore := seedRide(t, db, leader, "College Campus", "Airport", date, "07:30", 4) // 0.944
+	hourAfter := seedRide(t, db, leader, "College Campus", "Airport", date, "11:00", 4)  // 0.889
+	oneSeat := seedRide(t, db, leader, "College Campus", "Airport", date, "09:30", 1)    // 0.875
+	seedRide(t, db, leader, "College Campus", "Airport", date, "13:30", 4)               // Too late
+	seedRide(t, db, leader, "Railway Station", "Airport", date, "09:00", 4)              // Another route
+	seedRide(t, db, leader, "College Campus", "Airport", daysFromNow(2), "09:00", 4)     // Another date
+	seedRide(t, db, rider, "College Campus", "Airport", date, "09:00", 4)                // The rider's own
+	pending := seedRide(t, db, leader, "College Campus", "Airport", date, "09:00", 4)    // Already requested
+	approved := seedRide(t, db, leader, "College Campus", "Airport", date, "09:00", 4)   // Already approved
+	rejected := seedRide(t, db, leader, "College Campus", "Airport", date, "09:45", 4)   // Rejected requests don't hide a ride
+	seedRequest(t, db, rider, pending, "pending")
+	seedRequest(t, db, rider, approved, "approved")
+	seedRequest(t, db, rider, rejected, "rejected")
+
+	intent, apiErr := loadTripIntent(db, rider.FirebaseUID, MatchRidesRequest{
+		Origin: "Colege Campus", Destination: "airport", Date: date, TimeFrom: "08:00", TimeTo: "10:00",
+	})
+	if apiErr != nil {
+		t.Fatalf("load intent: %s", apiErr.Message)
+	}
+	matches, err := matchRides(db, rider, intent)
+	if err != nil {
+		t.Fatalf("match rides: %v", err)
+	}
+
+	want := []struct {
+		ride  Ride
+		score float64
+	}{
+		{earlier, 1}, {inWindow, 1}, {rejected, 1}, {justBefore, 0.944}, {hourAfter, 0.889}, {oneSeat, 0.875},
+	}
+	if len(matches) != len(want) {
+		t.Fatalf("%d matches, want %d: %+v", len(matches), len(want), matches)
+	}
+	for i, w := range want {
+		if matches[i].Ride.ID != w.ride.ID || math.Abs(matches[i].Score-w.score) > 0.001 {
+			t.Errorf("match %d = ride at %s scoring %.3f, want ride at %s scoring %.3f",
+				i, matches[i].Ride.Time, matches[i].Score, w.ride.Time, w.score)
+		}
+	}
+}
+
+func TestMatchRidesSkipsDepartedRides(t *testing.T) {
+	if time.Now().Format("15:04") >= "23:59" {
+		t.Skip("no ride can still depart today")
+	}
+	db := newTestDB(t)
+	leader, rider := seedUser(t, db), seedUser(t, db)
+	today := daysFromNow(0)
+
+	seedRide(t, db, leader, "College Campus", "Airport", today, "00:00", 4)
+	later := seedRide(t, db, leader, "College Campus", "Airport", today, "23:59", 4)
+
+	intent, apiErr := loadTripIntent(db, rider.FirebaseUID, MatchRidesRequest{Origin: "College Campus", Destination: "Airport", Date: today})
+	if apiErr != nil {
+		t.Fatalf("load intent: %s", apiErr.Message)
+	}
+	matches, err := matchRides(db, rider, intent)
+	if err != nil {
+		t.Fatalf("match rides: %v", err)
+	}
+	if len(matches) != 1 || matches[0].Ride.ID != later.ID {
+		t.Fatalf("matches = %+v, want only the ride still to leave", matches)
+	}
+}
+
+func TestMatchRidesAutoRequestUsesJoinRequestBudget(t *testing.T) {
+	db := newTestDB(t)
+	prevStore, prevLimit := rateLimitStore, rateLimits["join_request"]
+	rateLimitStore = NewMemoryRateLimitStore()
+	rateLimits["join_request"] = RateLimit{Requests: 2, Period: time.Hour, Burst: 2}
+	t.Cleanup(func() { rateLimitStore, rateLimits["join_request"] = prevStore, prevLimit })
+
+	leader, rider := seedUser(t, db), seedUser(t, db)
+	date := daysFromNow(1)
+	for _, at := range []string{"08:00", "09:00", "10:00"} {
+		seedRide(t, db, leader, "College Campus", "Airport", date, at, 4)
+	}
+
+	// A request sent by hand takes one of the two tokens
+	if allowed, _ := takeRateLimitToken(context.Background(), "join_request", rateLimits["join_request"], "uid:"+rider.FirebaseUID); !allowed {
+		t.Fatal("the budget was empty from the start")
+	}
+
+	var resp MatchRidesResponse
+	w := serve(t, MatchRides, "POST", "/ride/match", "/ride/match", rider.FirebaseUID,
+		MatchRidesRequest{Origin: "College Campus", Destination: "Airport", Date: date, AutoRequest: 3})
+	decodeJSON(t, w, http.StatusOK, &resp)
+
+	if resp.RequestsSent != 1 || len(resp.Matches) != 3 {
+		t.Fatalf("sent %d requests for %d matches, want 1 for 3", resp.RequestsSent, len(resp.Matches))
+	}
+	if !resp.Matches[0].RequestSent || resp.Matches[1].RequestError != CodeRateLimited || resp.Matches[2].RequestSent {
+		t.Fatalf("matches = %+v, want the first requested and the rest stopped by the budget", resp.Matches)
+	}
+	var requests int64
+	db.Model(&Request{}).Where("user_id = ?", rider.FirebaseUID).Count(&requests)
+	if requests != 1 {
+		t.Fatalf("%d join requests, want 1", requests)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
+)
+
+// HTTP metrics
+var (
+	httpRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "brocab_http_requests_total",
+		Help: "HTTP requests by route and status.",
+	}, []string{"method", "route", "status"})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "brocab_http_request_duration_seconds",
+		Help:    "HTTP request latency by route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	apiErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "brocab_api_errors_total",
+		Help: "Error responses by API error code, e.g. RIDE_FULL.",
+	}, []string{"code"})
+)
+
+// Database metrics
+var dbQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "brocab_db_query_duration_seconds",
+	Help:    "GORM statement latency by operation and table.",
+	Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+}, []string{"operation", "table"})
+
+// Cache metrics
+var cacheRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "brocab_cache_requests_total",
+	Help: "Cache lookups by cache and result (hit or miss).",
+}, []string{"cache", "result"})
+
+// Domain metrics
+var (
+	ridesCreatedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "brocab_rides_created_total",
+		Help: "Rides posted.",
+	})
+
+	joinRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "brocab_join_requests_total",
+		Help: "Join request events: sent, approved, rejected or cancelled.",
+	}, []string{"event"})
+
+	rideJoinsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "brocab_ride_joins_total",
+		Help: "Rides joined using a privilege.",
+	})
+
+	cancellationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "brocab_cancellations_total",
+		Help: "Cancellations: ride (deleted by leader), participation (passenger left), participant_removed (by leader) or privilege (cleared for a date).",
+	}, []string{"kind"})
+
+	notificationsCreatedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "brocab_notifications_created_total",
+		Help: "Notifications recorded in the outbox, by type.",
+	}, []string{"type"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		httpRequestsTotal, httpRequestDuration, apiErrorsTotal,
+		dbQueryDuration,
+		cacheRequestsTotal,
+		ridesCreatedTotal, joinRequestsTotal, rideJoinsTotal, cancellationsTotal, notificationsCreatedTotal,
+	)
+}
+
+// MetricsMiddleware records request counts and latency. It must run before ErrorHandler
+// so the status it sees is the one ErrorHandler rendered.
+func MetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		// Label by route template, not raw path, to keep cardinality bounded
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+		httpRequestsTotal.WithLabelValues(method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		httpRequestDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// GET /metrics - Prometheus metrics. When token is set, scrapers must send "Authorization: Bearer <token>".
+func MetricsHandler(token string) gin.HandlerFunc {
+	handler := promhttp.Handler()
+	expected := []byte("Bearer " + token)
+
+	return func(c *gin.Context) {
+		if token != "" && subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), expected) != 1 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// registerDBMetrics exports the connection pool stats (open, in use, idle, waits) and times every GORM statement
+func registerDBMetrics(db *gorm.DB, sqlDB *sql.DB) error {
+	if err := prometheus.Register(collectors.NewDBStatsCollector(sqlDB, "brocab")); err != nil {
+		return err
+	}
+	return db.Use(gormMetricsPlugin{})
+}
+
+// gormMetricsPlugin times statements through GORM callbacks
+type gormMetricsPlugin struct{}
+
+const gormMetricsStartKey = "metrics:start"
+
+func (gormMetricsPlugin) Name() string { return "brocab:metrics" }
+
+func (gormMetricsPlugin) Initialize(db *gorm.DB) error {
+	before := func(string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			tx.InstanceSet(gormMetricsStartKey, time.Now())
+		}
+	}
+	after := func(operation string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			start, ok := tx.InstanceGet(gormMetricsStartKey)
+			if !ok {
+				return
+			}
+			table := tx.Statement.Table
+			if table == "" {
+				table = "unknown"
+			}
+			dbQueryDuration.WithLabelValues(operation, table).Observe(time.Since(start.(time.Time)).Seconds())
+		}
+	}
+	return registerGormCallbacks(db, "metrics", before, after)
+}
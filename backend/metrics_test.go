@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMetricsRouteLabels(t *testing.T) {
+	r := gin.New()
+	r.Use(MetricsMiddleware(), ErrorHandler())
+	r.GET("/metrics", MetricsHandler(""))
+	r.GET("/metrics-test/ride/:rideID", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	// Scanners probing random paths, and IDs in matched paths, must not each become a series
+	for _, path := range []string{
+		"/metrics-test/ride/1", "/metrics-test/ride/2",
+		"/metrics-test/wp-admin.php", "/metrics-test/.env", "/metrics-test/probe/9f8e7d",
+	} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /metrics = %d", w.Code)
+	}
+
+	var series []string
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if strings.HasPrefix(line, "brocab_http_requests_total{") {
+			series = append(series, line)
+		}
+	}
+	body := strings.Join(series, "\n")
+	if !strings.Contains(body, `route="/metrics-test/ride/:rideID",status="200"}`) {
+		t.Errorf("matched requests aren't counted under their route template:\n%s", body)
+	}
+	if !strings.Contains(body, `route="unmatched",status="404"}`) {
+		t.Errorf("unmatched requests aren't counted as unmatched:\n%s", body)
+	}
+	for _, raw := range []string{"/metrics-test/ride/1", "wp-admin", ".env", "9f8e7d"} {
+		if strings.Contains(body, raw) {
+			t.Errorf("a raw path (%s) became a route label:\n%s", raw, body)
+		}
+	}
+}
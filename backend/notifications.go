@@ -1,114 +1,316 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // Notification represents a notification sent to a user
 type Notification struct {
-	ID        uint   `gorm:"primaryKey"`
-	UserID    string `gorm:"not null" json:"-"` // Firebase UID of the recipient - hidden from JSON
-	Title     string `gorm:"type:varchar(200);not null"`
-	Message   string `gorm:"type:text;not null"`
-	Type      string `gorm:"type:varchar(50);not null"` // "participant_removed", "ride_cancelled"
-	RideID    uint   `gorm:"not null"`
-	IsRead    bool   `gorm:"default:false"`
+	ID       uint   `gorm:"primaryKey"`
+	UserID   string `gorm:"not null" json:"-"` // Firebase UID of the recipient - hidden from JSON
+	Title    string `gorm:"type:varchar(200);not null"`
+	Message  string `gorm:"type:text;not null"`
+	Type     string `gorm:"type:varchar(50);not null"` // "participant_removed", "ride_cancelled"
+	RideID   uint   `gorm:"not null"`
+	IsRead   bool   `gorm:"default:false"`
+	OutboxID *uint  `gorm:"uniqueIndex" json:"-"` // Outbox event this was delivered from; nil for digest summaries
+
+	// Snapshot of the ride when the notification was created, so it survives ride deletion
+	Origin      string
+	Destination string
+	Date        string
+	Time        string
+
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
-// Create a notification and save it to database
-func createNotification(userID string, title, message, notificationType string, rideID uint) error {
-	notification := Notification{
-		UserID:    userID,
-		Title:     title,
-		Message:   message,
-		Type:      notificationType,
-		RideID:    rideID,
-		IsRead:    false,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+// UnreadCountResponse is the number of unread notifications
+type UnreadCountResponse struct {
+	UnreadCount int64 `json:"unread_count"`
+}
+
+// MarkAllReadResponse reports how many notifications were marked as read
+type MarkAllReadResponse struct {
+	Message string `json:"message"`
+	Updated int64  `json:"updated"`
+}
+
+// DeleteAllNotificationsResponse reports how many notifications were deleted
+type DeleteAllNotificationsResponse struct {
+	Message string `json:"message"`
+	Deleted int64  `json:"deleted"`
+}
+
+// NotificationResponse is a notification together with the ride details captured when it was sent
+type NotificationResponse struct {
+	ID          uint      `json:"id"`
+	Title       string    `json:"title"`
+	Message     string    `json:"message"`
+	Type        string    `json:"type"`
+	RideID      uint      `json:"ride_id"`
+	Origin      string    `json:"origin"`
+	Destination string    `json:"destination"`
+	Date        string    `json:"date"`
+	Time        string    `json:"time"`
+	IsRead      bool      `json:"is_read"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Record a notification, snapshotting the ride details, in the notification outbox using tx.
+// Call it inside the transaction of the change being notified about; the outbox worker delivers it
+// per the user's preferences once the transaction commits.
+func createNotification(tx *gorm.DB, userID string, title, message, notificationType string, ride Ride) error {
+	event := NotificationOutbox{
+		UserID:        userID,
+		Title:         title,
+		Message:       message,
+		Type:          notificationType,
+		RideID:        ride.ID,
+		Origin:        ride.Origin,
+		Destination:   ride.Destination,
+		Date:          ride.Date,
+		Time:          ride.Time,
+		Status:        "pending",
+		NextAttemptAt: time.Now(),
 	}
 
-	if err := DB.Create(&notification).Error; err != nil {
+	if err := tx.Create(&event).Error; err != nil {
 		return err
 	}
-
+	notificationsCreatedTotal.WithLabelValues(notificationType).Inc()
 	return nil
 }
 
-// GET /user/notifications - Get all notifications for the authenticated user
+// backfillNotificationSnapshots copies ride details onto notifications created before snapshots existed
+func backfillNotificationSnapshots() error {
+	return DB.Exec(`UPDATE notifications
+		SET origin = rides.origin, destination = rides.destination, date = rides.date, time = rides.time
+		FROM rides
+		WHERE notifications.ride_id = rides.id AND (notifications.origin IS NULL OR notifications.origin = '')`).Error
+}
+
+// GET /user/notifications?is_read=false&type=join_request&limit=20&cursor=... - Get notifications for the authenticated user
 func GetUserNotifications(c *gin.Context) {
+	db := DB.WithContext(c.Request.Context())
+
 	userID := c.MustGet("uid").(string)
 
-	var notifications []Notification
-	if err := DB.Where("user_id = ?", userID).Order("created_at DESC").Find(&notifications).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch notifications"})
+	page, err := parsePagination(c, "notifications", 0)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
 		return
 	}
 
-	// Build response with ride details
-	var response []map[string]interface{}
-	for _, n := range notifications {
-		var ride Ride
-		if err := DB.First(&ride, "id = ?", n.RideID).Error; err != nil {
-			continue
+	query := db.Where("user_id = ?", userID)
+	if isReadParam := c.Query("is_read"); isReadParam != "" {
+		isRead, err := strconv.ParseBool(isReadParam)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "is_read must be true or false")
+			return
 		}
+		query = query.Where("is_read = ?", isRead)
+	}
+	if notificationType := c.Query("type"); notificationType != "" {
+		query = query.Where("type = ?", notificationType)
+	}
 
-		entry := map[string]interface{}{
-			"id":          n.ID,
-			"title":       n.Title,
-			"message":     n.Message,
-			"type":        n.Type,
-			"ride_id":     n.RideID,
-			"origin":      ride.Origin,
-			"destination": ride.Destination,
-			"date":        ride.Date,
-			"time":        ride.Time,
-			"is_read":     n.IsRead,
-			"created_at":  n.CreatedAt,
-		}
-		response = append(response, entry)
+	var notifications []Notification
+	query = page.limitQuery(page.afterIDDesc(query, "id")).Order("id DESC")
+	if err := query.Find(&notifications).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to fetch notifications")
+		return
+	}
+
+	notifications, nextCursor := pageRows(page, notifications, func(n Notification) pageCursor {
+		return pageCursor{ID: n.ID}
+	})
+
+	var response []NotificationResponse
+	for _, n := range notifications {
+		response = append(response, NotificationResponse{
+			ID:          n.ID,
+			Title:       n.Title,
+			Message:     n.Message,
+			Type:        n.Type,
+			RideID:      n.RideID,
+			Origin:      n.Origin,
+			Destination: n.Destination,
+			Date:        n.Date,
+			Time:        n.Time,
+			IsRead:      n.IsRead,
+			CreatedAt:   n.CreatedAt,
+		})
 	}
 
-	c.JSON(http.StatusOK, response)
+	respondList(c, page, response, nextCursor)
 }
 
 // POST /notification/:notificationID/read - Mark notification as read
 func MarkNotificationAsRead(c *gin.Context) {
+	db := DB.WithContext(c.Request.Context())
+
 	notificationID := c.Param("notificationID")
 	userID := c.MustGet("uid").(string)
 
 	// Update notification as read only if it belongs to the authenticated user
-	result := DB.Model(&Notification{}).
+	result := db.Model(&Notification{}).
 		Where("id = ? AND user_id = ?", notificationID, userID).
 		Update("is_read", true)
 
 	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark notification as read"})
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to mark notification as read")
 		return
 	}
 
 	if result.RowsAffected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+		abortWithError(c, http.StatusNotFound, CodeNotificationNotFound, "Notification not found")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Notification marked as read"})
+	c.JSON(http.StatusOK, MessageResponse{Message: "Notification marked as read"})
 }
 
 // GET /user/notifications/unread-count - Get count of unread notifications
 func GetUnreadNotificationCount(c *gin.Context) {
+	db := DB.WithContext(c.Request.Context())
+
 	userID := c.MustGet("uid").(string)
 
 	var count int64
-	if err := DB.Model(&Notification{}).Where("user_id = ? AND is_read = ?", userID, false).Count(&count).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count notifications"})
+	if err := db.Model(&Notification{}).Where("user_id = ? AND is_read = ?", userID, false).Count(&count).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to count notifications")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"unread_count": count})
+	c.JSON(http.StatusOK, UnreadCountResponse{UnreadCount: count})
+}
+
+// POST /user/notifications/read-all?type=join_request&ride_id=12 - Mark all (optionally filtered) notifications as read
+func MarkAllNotificationsAsRead(c *gin.Context) {
+	db := DB.WithContext(c.Request.Context())
+
+	userID := c.MustGet("uid").(string)
+
+	query := db.Model(&Notification{}).Where("user_id = ? AND is_read = ?", userID, false)
+	if notificationType := c.Query("type"); notificationType != "" {
+		query = query.Where("type = ?", notificationType)
+	}
+	if rideIDParam := c.Query("ride_id"); rideIDParam != "" {
+		rideID, err := strconv.Atoi(rideIDParam)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid ride ID")
+			return
+		}
+		query = query.Where("ride_id = ?", rideID)
+	}
+
+	result := query.Updates(map[string]interface{}{
+		"is_read":    true,
+		"updated_at": time.Now(),
+	})
+	if result.Error != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to mark notifications as read")
+		return
+	}
+
+	c.JSON(http.StatusOK, MarkAllReadResponse{
+		Message: fmt.Sprintf("%d notifications marked as read", result.RowsAffected),
+		Updated: result.RowsAffected,
+	})
+}
+
+// DELETE /notification/:notificationID - Delete a single notification
+func DeleteNotification(c *gin.Context) {
+	db := DB.WithContext(c.Request.Context())
+
+	notificationID := c.Param("notificationID")
+	userID := c.MustGet("uid").(string)
+
+	// Delete only if the notification belongs to the authenticated user
+	result := db.Where("id = ? AND user_id = ?", notificationID, userID).Delete(&Notification{})
+	if result.Error != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to delete notification")
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		abortWithError(c, http.StatusNotFound, CodeNotificationNotFound, "Notification not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, MessageResponse{Message: "Notification deleted"})
+}
+
+// DELETE /user/notifications?read_only=true - Delete all of the user's notifications (or only the read ones)
+func DeleteAllNotifications(c *gin.Context) {
+	db := DB.WithContext(c.Request.Context())
+
+	userID := c.MustGet("uid").(string)
+
+	query := db.Where("user_id = ?", userID)
+	if readOnlyParam := c.Query("read_only"); readOnlyParam != "" {
+		readOnly, err := strconv.ParseBool(readOnlyParam)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "read_only must be true or false")
+			return
+		}
+		if readOnly {
+			query = query.Where("is_read = ?", true)
+		}
+	}
+
+	result := query.Delete(&Notification{})
+	if result.Error != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to delete notifications")
+		return
+	}
+
+	c.JSON(http.StatusOK, DeleteAllNotificationsResponse{
+		Message: fmt.Sprintf("%d notifications deleted", result.RowsAffected),
+		Deleted: result.RowsAffected,
+	})
+}
+
+// purgeReadNotifications deletes read notifications last updated before the cutoff
+func purgeReadNotifications(cutoff time.Time) (int64, error) {
+	result := DB.Where("is_read = ? AND updated_at < ?", true, cutoff).Delete(&Notification{})
+	return result.RowsAffected, result.Error
+}
+
+// StartNotificationRetention purges read notifications older than retention every interval.
+// A zero retention disables the purge.
+func StartNotificationRetention(retention, interval time.Duration) {
+	if retention <= 0 {
+		slog.Info("ℹ️  Notification retention disabled")
+		return
+	}
+
+	runWorker(func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			purged, err := purgeReadNotifications(time.Now().Add(-retention))
+			if err != nil {
+				slog.Error("❌ Failed to purge old notifications", "error", err)
+			} else if purged > 0 {
+				slog.Info("🧹 Purged old read notifications", "count", purged, "retention", retention.String())
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
 }
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDeleteAllNotificationsReadOnly(t *testing.T) {
+	tests := []struct {
+		query     string
+		status    int
+		remaining int64 // Of one read and one unread notification
+	}{
+		{"", http.StatusOK, 0},
+		{"?read_only=true", http.StatusOK, 1},
+		{"?read_only=1", http.StatusOK, 1},
+		{"?read_only=false", http.StatusOK, 0},
+		{"?read_only=yes", http.StatusBadRequest, 2},
+		{"?read_only=TRUE1", http.StatusBadRequest, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			db := newTestDB(t)
+			user := seedUser(t, db)
+			for _, read := range []bool{true, false} {
+				n := Notification{UserID: user.FirebaseUID, Title: "Title", Message: "Message", Type: "join_request", IsRead: read}
+				if err := db.Create(&n).Error; err != nil {
+					t.Fatalf("seed notification: %v", err)
+				}
+			}
+
+			w := serve(t, DeleteAllNotifications, "DELETE", "/user/notifications", "/user/notifications"+tt.query, user.FirebaseUID, nil)
+			decodeJSON(t, w, tt.status, nil)
+
+			var remaining int64
+			db.Model(&Notification{}).Where("user_id = ?", user.FirebaseUID).Count(&remaining)
+			if remaining != tt.remaining {
+				t.Fatalf("%d notifications left, want %d", remaining, tt.remaining)
+			}
+		})
+	}
+}
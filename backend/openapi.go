@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiOperation documents one route. Schemas are reflected from the same Go types the handlers
+// bind and return, and validateOpenAPIRoutes checks the table against the routes Gin registered,
+// so the spec cannot silently drift from the code.
+type apiOperation struct {
+	Method    string
+	Path      string // Gin syntax, e.g. /ride/:rideID
+	Summary   string
+	Tag       string
+	Public    bool
+	Query     []apiParam
+	Body      interface{} // Zero value of the request body type, nil when there is none
+	Response  interface{} // Zero value of the success response type
+	Status    int         // Success status, 200 when zero
+	Paginated bool        // Response is Response (a slice) or, with ?limit/?cursor, a Page of its elements
+}
+
+// apiParam is a query parameter
+type apiParam struct {
+	Name        string
+	Type        string // "string", "integer" or "boolean"
+	Description string
+	Required    bool
+}
+
+var paginationParams = []apiParam{
+	{Name: "limit", Type: "integer", Description: fmt.Sprintf("Page size (default %d, max %d). Enables the paginated response.", defaultPageLimit, maxPageLimit)},
+	{Name: "cursor", Type: "string", Description: "Opaque cursor from this endpoint's previous next_cursor. Enables the paginated response."},
+}
+
+var rideWhenParam = apiParam{Name: "when", Type: "string", Description: "upcoming (today onwards) or past"}
+
+// Path parameters that are numeric IDs; everything else is a string
+var integerPathParams = map[string]bool{
+	"rideID": true, "requestID": true, "participantID": true, "notificationID": true, "deviceID": true,
+}
+
+// openAPIDocument is the free-form type of the spec itself
+type openAPIDocument map[string]interface{}
+
+var apiOperations = []apiOperation{
+	{Method: "GET", Path: "/public", Summary: "Public test endpoint", Tag: "Meta", Public: true, Response: MessageResponse{}},
+	{Method: "GET", Path: "/ping", Summary: "Connectivity check", Tag: "Meta", Public: true, Response: MessageResponse{}},
+	{Method: "GET", Path: "/openapi.json", Summary: "This OpenAPI document", Tag: "Meta", Public: true, Response: openAPIDocument{}},
+
+	{Method: "GET", Path: "/user", Summary: "Get current user profile", Tag: "Users", Response: User{}},
+	{Method: "PUT", Path: "/user", Summary: "Update current user profile", Tag: "Users", Body: UpdateUserRequest{}, Response: User{}},
+	{Method: "POST", Path: "/user", Summary: "Create the current user's profile", Tag: "Users", Body: CreateUserRequest{}, Response: User{}, Status: http.StatusCreated},
+	{Method: "GET", Path: "/user/:userID", Summary: "Get a user's public details", Tag: "Users", Response: UserBasicResponse{}},
+	{Method: "GET", Path: "/user/rides/posted", Summary: "Rides led by the current user", Tag: "Rides",
+		Query: append([]apiParam{rideWhenParam}, paginationParams...), Response: []Ride{}, Paginated: true},
+	{Method: "GET", Path: "/user/rides/joined", Summary: "Rides the current user joined", Tag: "Rides",
+		Query: append([]apiParam{rideWhenParam}, paginationParams...), Response: []Ride{}, Paginated: true},
+	{Method: "GET", Path: "/user/privileges", Summary: "Approved join requests (privileges)", Tag: "Requests", Query: paginationParams, Response: []PrivilegeResponse{}, Paginated: true},
+	{Method: "GET", Path: "/user/requests", Summary: "Join requests sent by the current user", Tag: "Requests",
+		Query:    append([]apiParam{{Name: "status", Type: "string", Description: "pending, approved or revoked"}}, paginationParams...),
+		Response: []SentRequestResponse{}, Paginated: true},
+	{Method: "DELETE", Path: "/user/clear-involvement/:date", Summary: "Cancel all requests and privileges for a date", Tag: "Requests", Response: ClearInvolvementResponse{}},
+	{Method: "DELETE", Path: "/user/cancel-ride/:rideID", Summary: "Cancel a pending request or leave a joined ride", Tag: "Requests", Response: CancelParticipationResponse{}},
+
+	{Method: "POST", Path: "/ride", Summary: "Create a ride", Tag: "Rides", Body: Ride{}, Response: AddRideResponse{}},
+	{Method: "DELETE", Path: "/ride/:rideID", Summary: "Delete a ride (leader only)", Tag: "Rides", Response: DeleteRideResponse{}},
+	{Method: "GET", Path: "/ride/:rideID/leader", Summary: "Get a ride's leader", Tag: "Rides", Response: RideLeaderResponse{}},
+	{Method: "GET", Path: "/ride/filter", Summary: "Search rides", Tag: "Rides", Public: true,
+		Query: append([]apiParam{
+			{Name: "origin", Type: "string", Required: true},
+			{Name: "destination", Type: "string", Required: true},
+			{Name: "date", Type: "string", Description: "YYYY-MM-DD", Required: true},
+			{Name: "sort", Type: "string", Description: "time (default), price, seats or relevance"},
+			{Name: "time", Type: "string", Description: "HH:mm the searcher wants to leave; used by sort=relevance"},
+		}, paginationParams...),
+		Response: []Ride{}, Paginated: true},
+	{Method: "POST", Path: "/ride/match", Summary: "Suggest rides for a trip, optionally requesting the best", Tag: "Requests", Body: MatchRidesRequest{}, Response: MatchRidesResponse{}},
+	{Method: "GET", Path: "/ride/:rideID/requests", Summary: "Pending join requests for a ride (leader only)", Tag: "Requests", Query: paginationParams, Response: []JoinRequestResponse{}, Paginated: true},
+	{Method: "POST", Path: "/ride/:rideID/join", Summary: "Send a join request", Tag: "Requests", Response: MessageResponse{}},
+	{Method: "DELETE", Path: "/ride/:rideID/cancel-request", Summary: "Cancel a pending join request", Tag: "Requests", Response: MessageResponse{}},
+	{Method: "POST", Path: "/ride/:rideID/join-ride", Summary: "Join a ride using a privilege", Tag: "Requests", Response: JoinRideResponse{}},
+
+	{Method: "GET", Path: "/places/autocomplete", Summary: "Autocomplete place names", Tag: "Places", Public: true,
+		Query: []apiParam{
+			{Name: "q", Type: "string", Description: "Start of a place name or of any word in it", Required: true},
+			{Name: "limit", Type: "integer", Description: "Maximum places returned, default 10, at most 25"},
+		},
+		Response: []Place{}},
+	{Method: "POST", Path: "/trip-request", Summary: "Post a trip request (looking for a ride)", Tag: "Trip Requests", Body: CreateTripRequestRequest{}, Response: TripRequest{}},
+	{Method: "GET", Path: "/trip-requests", Summary: "Browse open trip requests", Tag: "Trip Requests",
+		Query: append([]apiParam{
+			{Name: "origin", Type: "string"},
+			{Name: "destination", Type: "string"},
+			{Name: "date", Type: "string", Description: "YYYY-MM-DD"},
+		}, paginationParams...),
+		Response: []TripRequestResponse{}, Paginated: true},
+	{Method: "GET", Path: "/user/trip-requests", Summary: "List the user's trip requests", Tag: "Trip Requests", Query: paginationParams, Response: []TripRequest{}, Paginated: true},
+	{Method: "DELETE", Path: "/trip-request/:tripRequestID", Summary: "Cancel an open trip request", Tag: "Trip Requests", Response: MessageResponse{}},
+	{Method: "POST", Path: "/trip-request/:tripRequestID/offer", Summary: "Offer a ride for a trip request", Tag: "Trip Requests", Body: OfferRideRequest{}, Response: OfferRideResponse{}},
+	{Method: "GET", Path: "/ride/:rideID/participants", Summary: "Participants of a ride", Tag: "Participants", Query: paginationParams, Response: []ParticipantResponse{}, Paginated: true},
+	{Method: "DELETE", Path: "/ride/:rideID/participant/:participantID", Summary: "Remove a participant (leader only)", Tag: "Participants", Response: MessageResponse{}},
+	{Method: "POST", Path: "/ride/:rideID/approve/:requestID", Summary: "Approve a join request (leader only)", Tag: "Participants", Response: MessageResponse{}},
+	{Method: "POST", Path: "/ride/:rideID/reject/:requestID", Summary: "Reject a join request (leader only)", Tag: "Participants", Response: MessageResponse{}},
+
+	{Method: "GET", Path: "/user/notifications", Summary: "Notifications for the current user", Tag: "Notifications",
+		Query: append([]apiParam{
+			{Name: "is_read", Type: "boolean"},
+			{Name: "type", Type: "string"},
+		}, paginationParams...),
+		Response: []NotificationResponse{}, Paginated: true},
+	{Method: "GET", Path: "/user/notifications/unread-count", Summary: "Unread notification count", Tag: "Notifications", Response: UnreadCountResponse{}},
+	{Method: "POST", Path: "/notification/:notificationID/read", Summary: "Mark a notification as read", Tag: "Notifications", Response: MessageResponse{}},
+	{Method: "DELETE", Path: "/notification/:notificationID", Summary: "Delete a notification", Tag: "Notifications", Response: MessageResponse{}},
+	{Method: "POST", Path: "/user/notifications/read-all", Summary: "Mark all notifications as read", Tag: "Notifications",
+		Query: []apiParam{{Name: "type", Type: "string"}, {Name: "ride_id", Type: "integer"}}, Response: MarkAllReadResponse{}},
+	{Method: "DELETE", Path: "/user/notifications", Summary: "Delete all notifications", Tag: "Notifications",
+		Query: []apiParam{{Name: "read_only", Type: "boolean", Description: "Only delete read notifications"}}, Response: DeleteAllNotificationsResponse{}},
+	{Method: "GET", Path: "/user/notification-preferences", Summary: "Get notification preferences", Tag: "Notifications", Response: NotificationPreferencesResponse{}},
+	{Method: "PUT", Path: "/user/notification-preferences", Summary: "Update notification preferences", Tag: "Notifications",
+		Body: UpdateNotificationPreferencesRequest{}, Response: NotificationPreferencesResponse{}},
+
+	{Method: "POST", Path: "/user/saved-searches", Summary: "Save a search and get alerts for matching rides", Tag: "Saved Searches", Body: SaveSearchRequest{}, Response: SavedSearch{}},
+	{Method: "GET", Path: "/user/saved-searches", Summary: "List saved searches", Tag: "Saved Searches", Query: paginationParams, Response: []SavedSearch{}, Paginated: true},
+	{Method: "DELETE", Path: "/user/saved-searches/:searchID", Summary: "Delete a saved search", Tag: "Saved Searches", Response: MessageResponse{}},
+	{Method: "POST", Path: "/user/devices", Summary: "Register a push device", Tag: "Devices", Body: RegisterDeviceRequest{}, Response: DeviceToken{}},
+	{Method: "GET", Path: "/user/devices", Summary: "List push devices", Tag: "Devices", Response: []DeviceToken{}},
+	{Method: "PUT", Path: "/user/devices/:deviceID", Summary: "Opt a device in or out of push", Tag: "Devices", Body: UpdateDeviceRequest{}, Response: UpdateDeviceResponse{}},
+	{Method: "DELETE", Path: "/user/devices/:deviceID", Summary: "Unregister a push device", Tag: "Devices", Response: MessageResponse{}},
+}
+
+// schemaBuilder reflects Go types into OpenAPI schemas, collecting named structs as components
+type schemaBuilder struct {
+	components map[string]interface{}
+}
+
+var nonIdentChars = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+
+// componentName turns a Go type name (including generic instantiations) into a component key
+func componentName(t reflect.Type) string {
+	name := strings.ReplaceAll(t.Name(), t.PkgPath()+".", "")
+	name = strings.ReplaceAll(name, "main.", "")
+	return strings.Trim(nonIdentChars.ReplaceAllString(name, "_"), "_")
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]interface{} {
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := b.schema(t.Elem())
+		return map[string]interface{}{"allOf": []interface{}{s}, "nullable": true}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map, reflect.Interface:
+		return map[string]interface{}{"type": "object", "additionalProperties": true}
+	case reflect.Struct:
+		name := componentName(t)
+		if _, ok := b.components[name]; !ok {
+			b.components[name] = nil // reserve to stop recursion
+			b.components[name] = b.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// structSchema follows encoding/json: json tags name fields, "-" hides them, omitempty makes them optional
+func (b *schemaBuilder) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := field.Name
+		omitEmpty := false
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			parts := strings.Split(tag, ",")
+			if parts[0] == "-" {
+				continue
+			}
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			for _, opt := range parts[1:] {
+				if opt == "omitempty" {
+					omitEmpty = true
+				}
+			}
+		}
+
+		properties[name] = b.schema(field.Type)
+		if !omitEmpty && field.Type.Kind() != reflect.Ptr {
+			required = append(required, name)
+		}
+	}
+
+	s := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		s["required"] = required
+	}
+	return s
+}
+
+// openAPIPath converts /ride/:rideID to /ride/{rideID} and returns the parameter names
+func openAPIPath(ginPath string) (string, []string) {
+	var params []string
+	segments := strings.Split(ginPath, "/")
+	for i, seg := range segments {
+		if strings.HasPrefix(seg, ":") {
+			params = append(params, seg[1:])
+			segments[i] = "{" + seg[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// operationID derives a stable identifier, e.g. DELETE /ride/:rideID -> deleteRideRideID
+func operationID(method, ginPath string) string {
+	id := strings.ToLower(method)
+	for _, part := range nonIdentChars.Split(ginPath, -1) {
+		if part != "" {
+			id += strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return id
+}
+
+// buildOpenAPISpec assembles the OpenAPI 3 document of an API version from apiOperations
+func buildOpenAPISpec(v apiVersion) map[string]interface{} {
+	b := &schemaBuilder{components: map[string]interface{}{}}
+	errorSchema := b.schema(reflect.TypeOf(APIError{}))
+	paths := map[string]interface{}{}
+
+	for _, op := range apiOperations {
+		path, pathParams := openAPIPath(op.Path)
+
+		var params []interface{}
+		for _, name := range pathParams {
+			paramType := "string"
+			if integerPathParams[name] {
+				paramType = "integer"
+			}
+			params = append(params, map[string]interface{}{
+				"name": name, "in": "path", "required": true,
+				"schema": map[string]interface{}{"type": paramType},
+			})
+		}
+		for _, q := range op.Query {
+			params = append(params, map[string]interface{}{
+				"name": q.Name, "in": "query", "required": q.Required, "description": q.Description,
+				"schema": map[string]interface{}{"type": q.Type},
+			})
+		}
+
+		responseType := reflect.TypeOf(op.Response)
+		responseSchema := b.schema(responseType)
+		if op.Paginated {
+			page := map[string]interface{}{
+				"type":     "object",
+				"required": []string{"items", "next_cursor"},
+				"properties": map[string]interface{}{
+					"items":       responseSchema,
+					"next_cursor": map[string]interface{}{"type": "string", "description": "Empty when there are no more results"},
+				},
+			}
+			responseSchema = map[string]interface{}{"oneOf": []interface{}{responseSchema, page}}
+		}
+
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		operation := map[string]interface{}{
+			"summary":     op.Summary,
+			"tags":        []string{op.Tag},
+			"operationId": operationID(op.Method, op.Path),
+			"parameters":  params,
+			"responses": map[string]interface{}{
+				strconv.Itoa(status): map[string]interface{}{
+					"description": http.StatusText(status),
+					"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": responseSchema}},
+				},
+				"default": map[string]interface{}{
+					"description": "Error",
+					"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": errorSchema}},
+				},
+			},
+		}
+		if !op.Public {
+			operation["security"] = []interface{}{map[string]interface{}{"firebaseAuth": []string{}}}
+		}
+		if op.Body != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": b.schema(reflect.TypeOf(op.Body))}},
+			}
+		}
+
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[path] = item
+		}
+		item[strings.ToLower(op.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "BroCab API",
+			"version": strings.TrimPrefix(v.Prefix, "/v") + ".0.0",
+		},
+		"servers": []interface{}{map[string]interface{}{"url": v.Prefix}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": b.components,
+			"securitySchemes": map[string]interface{}{
+				"firebaseAuth": map[string]interface{}{
+					"type": "http", "scheme": "bearer", "bearerFormat": "Firebase ID token",
+				},
+			},
+		},
+	}
+}
+
+// validateOpenAPIRoutes reports routes registered under prefix but missing from the spec, and vice versa
+func validateOpenAPIRoutes(routes gin.RoutesInfo, prefix string) error {
+	documented := make(map[string]bool, len(apiOperations))
+	for _, op := range apiOperations {
+		documented[op.Method+" "+op.Path] = true
+	}
+
+	var problems []string
+	registered := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		if !strings.HasPrefix(route.Path, prefix+"/") {
+			continue
+		}
+		key := route.Method + " " + strings.TrimPrefix(route.Path, prefix)
+		registered[key] = true
+		if !documented[key] {
+			problems = append(problems, "undocumented route "+key)
+		}
+	}
+	for key := range documented {
+		if !registered[key] {
+			problems = append(problems, "documented route not registered: "+key)
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// OpenAPIHandler serves the version's spec, built once
+func OpenAPIHandler(v apiVersion) gin.HandlerFunc {
+	spec, err := json.Marshal(buildOpenAPISpec(v))
+	if err != nil {
+		panic(fmt.Sprintf("failed to build OpenAPI spec: %v", err))
+	}
+
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json; charset=utf-8", spec)
+	}
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"firebase.google.com/go/v4/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// checkSchema reports where v, a decoded JSON value, does not match schema. Objects may not have
+// fields the schema doesn't document, unless it allows additional properties.
+func checkSchema(spec map[string]interface{}, schema map[string]interface{}, v interface{}, at string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		components := spec["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+		return checkSchema(spec, components[strings.TrimPrefix(ref, "#/components/schemas/")].(map[string]interface{}), v, at)
+	}
+	if v == nil {
+		if schema["nullable"] == true || len(schema) == 0 {
+			return nil
+		}
+		return []string{at + ": null where the spec doesn't allow it"}
+	}
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		var problems []string
+		for _, s := range allOf {
+			problems = append(problems, checkSchema(spec, s.(map[string]interface{}), v, at)...)
+		}
+		return problems
+	}
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		var all []string
+		for _, s := range oneOf {
+			problems := checkSchema(spec, s.(map[string]interface{}), v, at)
+			if len(problems) == 0 {
+				return nil
+			}
+			all = append(all, problems...)
+		}
+		return append([]string{at + ": matches none of oneOf"}, all...)
+	}
+
+	mismatch := func() []string {
+		return []string{fmt.Sprintf("%s: got %T %v, spec says %v", at, v, v, schema["type"])}
+	}
+	switch schema["type"] {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return mismatch()
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		var problems []string
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: missing required field %q", at, name))
+			}
+		}
+		for name, field := range obj {
+			if s, ok := properties[name]; ok {
+				problems = append(problems, checkSchema(spec, s.(map[string]interface{}), field, at+"."+name)...)
+			} else if schema["additionalProperties"] != true {
+				problems = append(problems, fmt.Sprintf("%s: undocumented field %q", at, name))
+			}
+		}
+		return problems
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			return mismatch()
+		}
+		var problems []string
+		for i, item := range items {
+			problems = append(problems, checkSchema(spec, schema["items"].(map[string]interface{}), item, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+		return problems
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			return mismatch()
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				return []string{fmt.Sprintf("%s: %q is not a date-time", at, s)}
+			}
+		}
+	case "integer":
+		if n, ok := v.(float64); !ok || n != math.Trunc(n) {
+			return mismatch()
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			return mismatch()
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return mismatch()
+		}
+	}
+	return nil
+}
+
+// loadSpec builds a version's spec and decodes it as a client would
+func loadSpec(t *testing.T, v apiVersion) map[string]interface{} {
+	t.Helper()
+
+	raw, err := json.Marshal(buildOpenAPISpec(v))
+	if err != nil {
+		t.Fatalf("encode spec: %v", err)
+	}
+	var spec map[string]interface{}
+	if err := json.Unmarshal(raw, &spec); err != nil {
+		t.Fatalf("decode spec: %v", err)
+	}
+	return spec
+}
+
+// checkResponse validates a response against the operation's documented responses, falling back
+// to the default (error) response for undocumented statuses
+func checkResponse(t *testing.T, spec map[string]interface{}, method, ginPath string, w *httptest.ResponseRecorder) []string {
+	t.Helper()
+
+	path, _ := openAPIPath(ginPath)
+	item, ok := spec["paths"].(map[string]interface{})[path].(map[string]interface{})
+	if !ok {
+		return []string{"path not in spec"}
+	}
+	operation, ok := item[strings.ToLower(method)].(map[string]interface{})
+	if !ok {
+		return []string{"method not in spec"}
+	}
+	responses := operation["responses"].(map[string]interface{})
+	response, ok := responses[strconv.Itoa(w.Code)].(map[string]interface{})
+	if !ok {
+		response = responses["default"].(map[string]interface{})
+	}
+	schema := response["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"].(map[string]interface{})
+
+	var body interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		return []string{fmt.Sprintf("response is not JSON: %v", err)}
+	}
+	return checkSchema(spec, schema, body, "body")
+}
+
+// contractFixture is the data every contract case starts from: a ride led by leader, with a
+// pending request from rider, an approved one (a privilege) from joiner and member on board
+type contractFixture struct {
+	leader, rider, joiner, member, stranger *User
+
+	ride              Ride
+	pending, approved Request
+	participant       Participant
+	notification      Notification
+	tripRequest       TripRequest
+	savedSearch       SavedSearch
+	device            DeviceToken
+	date, otherDate   string
+}
+
+func seedContractFixture(t *testing.T) *contractFixture {
+	t.Helper()
+
+	db := newTestDB(t)
+	f := &contractFixture{date: daysFromNow(1), otherDate: daysFromNow(2)}
+	f.leader, f.rider, f.joiner, f.member, f.stranger = seedUser(t, db), seedUser(t, db), seedUser(t, db), seedUser(t, db), seedUser(t, db)
+
+	f.ride = seedRide(t, db, f.leader, "Campus", "Airport", f.date, "09:00", 4)
+	f.pending = seedRequest(t, db, f.rider, f.ride, "pending")
+	f.approved = seedRequest(t, db, f.joiner, f.ride, "approved")
+
+	f.participant = Participant{RideID: f.ride.ID, UserID: f.member.FirebaseUID, JoinedAt: time.Now()}
+	f.notification = Notification{UserID: f.rider.FirebaseUID, Title: "Title", Message: "Message", Type: "join_request", RideID: f.ride.ID}
+	f.device = DeviceToken{UserID: f.rider.FirebaseUID, Token: "phone", Platform: "android", Enabled: true}
+	for _, row := range []interface{}{&f.participant, &f.notification, &f.device} {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Model(&f.ride).Update("seats_filled", 1).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	f.tripRequest = TripRequest{
+		UserID: f.stranger.FirebaseUID, Origin: "Campus", Destination: "Airport", Date: f.otherDate,
+		TimeFrom: "08:00", TimeTo: "10:00", SeatsNeeded: 1, Status: "open",
+		OriginNormalized: "campus", DestinationNormalized: "airport",
+		OriginPlaceID: f.ride.OriginPlaceID, DestinationPlaceID: f.ride.DestinationPlaceID,
+	}
+	f.savedSearch = SavedSearch{
+		UserID: f.rider.FirebaseUID, Origin: "Campus", Destination: "Airport", Date: f.otherDate,
+		OriginNormalized: "campus", DestinationNormalized: "airport",
+		OriginPlaceID: f.ride.OriginPlaceID, DestinationPlaceID: f.ride.DestinationPlaceID,
+	}
+	for _, row := range []interface{}{&f.tripRequest, &f.savedSearch} {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f
+}
+
+// contractCase exercises one documented operation; uid is empty for anonymous requests
+type contractCase struct {
+	op      string // Method and Gin path, as in apiOperations
+	request func(f *contractFixture) (path, uid string, body interface{})
+}
+
+// Operations whose handlers use Postgres-only SQL (ILIKE), so they can't run on SQLite
+var postgresOnlyOps = map[string]bool{
+	"DELETE /user/clear-involvement/:date": true,
+	"DELETE /ride/:rideID/cancel-request":  true,
+}
+
+var contractCases = []contractCase{
+	{"GET /public", func(f *contractFixture) (string, string, interface{}) { return "/public", "", nil }},
+	{"GET /ping", func(f *contractFixture) (string, string, interface{}) { return "/ping", "", nil }},
+	{"GET /openapi.json", func(f *contractFixture) (string, string, interface{}) { return "/openapi.json", "", nil }},
+
+	{"GET /user", func(f *contractFixture) (string, string, interface{}) { return "/user", f.rider.FirebaseUID, nil }},
+	{"PUT /user", func(f *contractFixture) (string, string, interface{}) {
+		return "/user", f.rider.FirebaseUID, UpdateUserRequest{Name: "Renamed"}
+	}},
+	{"POST /user", func(f *contractFixture) (string, string, interface{}) {
+		return "/user", "uid-new", CreateUserRequest{Name: "New", Email: "new@example.com", Phone: "9999999999"}
+	}},
+	{"GET /user/:userID", func(f *contractFixture) (string, string, interface{}) {
+		return "/user/" + f.leader.FirebaseUID, f.rider.FirebaseUID, nil
+	}},
+	{"GET /user/rides/posted", func(f *contractFixture) (string, string, interface{}) {
+		return "/user/rides/posted", f.leader.FirebaseUID, nil
+	}},
+	{"GET /user/rides/joined", func(f *contractFixture) (string, string, interface{}) {
+		return "/user/rides/joined", f.member.FirebaseUID, nil
+	}},
+	{"GET /user/privileges", func(f *contractFixture) (string, string, interface{}) {
+		return "/user/privileges", f.joiner.FirebaseUID, nil
+	}},
+	{"GET /user/requests", func(f *contractFixture) (string, string, interface{}) {
+		return "/user/requests", f.rider.FirebaseUID, nil
+	}},
+	{"DELETE /user/clear-involvement/:date", func(f *contractFixture) (string, string, interface{}) {
+		return "/user/clear-involvement/" + f.date, f.rider.FirebaseUID, nil
+	}},
+	{"DELETE /user/cancel-ride/:rideID", func(f *contractFixture) (string, string, interface{}) {
+		return fmt.Sprintf("/user/cancel-ride/%d", f.ride.ID), f.member.FirebaseUID, nil
+	}},
+
+	{"POST /ride", func(f *contractFixture) (string, string, interface{}) {
+		return "/ride", f.leader.FirebaseUID, Ride{Origin: "Campus", Destination: "Station", Date: f.otherDate, Time: "18:00", Seats: 3, Price: 50}
+	}},
+	{"DELETE /ride/:rideID", func(f *contractFixture) (string, string, interface{}) {
+		return fmt.Sprintf("/ride/%d", f.ride.ID), f.leader.FirebaseUID, nil
+	}},
+	{"GET /ride/:rideID/leader", func(f *contractFixture) (string, string, interface{}) {
+		return fmt.Sprintf("/ride/%d/leader", f.ride.ID), f.rider.FirebaseUID, nil
+	}},
+	{"GET /ride/filter", func(f *contractFixture) (string, string, interface{}) {
+		return "/ride/filter?origin=Campus&destination=Airport&date=" + f.date, "", nil
+	}},
+	{"POST /ride/match", func(f *contractFixture) (string, string, interface{}) {
+		return "/ride/match", f.stranger.FirebaseUID, MatchRidesRequest{Origin: "Campus", Destination: "Airport", Date: f.date}
+	}},
+	{"GET /ride/:rideID/requests", func(f *contractFixture) (string, string, interface{}) {
+		return fmt.Sprintf("/ride/%d/requests", f.ride.ID), f.leader.FirebaseUID, nil
+	}},
+	{"POST /ride/:rideID/join", func(f *contractFixture) (string, string, interface{}) {
+		return fmt.Sprintf("/ride/%d/join", f.ride.ID), f.stranger.FirebaseUID, nil
+	}},
+	{"DELETE /ride/:rideID/cancel-request", func(f *contractFixture) (string, string, interface{}) {
+		return fmt.Sprintf("/ride/%d/cancel-request", f.ride.ID), f.rider.FirebaseUID, nil
+	}},
+	{"POST /ride/:rideID/join-ride", func(f *contractFixture) (string, string, interface{}) {
+		return fmt.Sprintf("/ride/%d/join-ride", f.ride.ID), f.joiner.FirebaseUID, nil
+	}},
+
+	{"GET /places/autocomplete", func(f *contractFixture) (string, string, interface{}) {
+		return "/places/autocomplete?q=cam", "", nil
+	}},
+	{"POST /trip-request", func(f *contractFixture) (string, string, interface{}) {
+		return "/trip-request", f.rider.FirebaseUID, CreateTripRequestRequest{Origin: "Campus", Destination: "Airport", Date: f.otherDate}
+	}},
+	{"GET /trip-requests", func(f *contractFixture) (string, string, interface{}) {
+		return "/trip-requests", f.leader.FirebaseUID, nil
+	}},
+	{"GET /user/trip-requests", func(f *contractFixture) (string, string, interface{}) {
+		return "/user/trip-requests", f.stranger.FirebaseUID, nil
+	}},
+	{"DELETE /trip-request/:tripRequestID", func(f *contractFixture) (string, string, interface{}) {
+		return fmt.Sprintf("/trip-request/%d", f.tripRequest.ID), f.stranger.FirebaseUID, nil
+	}},
+	{"POST /trip-request/:tripRequestID/offer", func(f *contractFixture) (string, string, interface{}) {
+		return fmt.Sprintf("/trip-request/%d/offer", f.tripRequest.ID), f.leader.FirebaseUID, OfferRideRequest{Time: "09:00", Seats: 3, Price: 80}
+	}},
+	{"GET /ride/:rideID/participants", func(f *contractFixture) (string, string, interface{}) {
+		return fmt.Sprintf("/ride/%d/participants", f.ride.ID), f.leader.FirebaseUID, nil
+	}},
+	{"DELETE /ride/:rideID/participant/:participantID", func(f *contractFixture) (string, string, interface{}) {
+		return fmt.Sprintf("/ride/%d/participant/%d", f.ride.ID, f.participant.ID), f.leader.FirebaseUID, nil
+	}},
+	{"POST /ride/:rideID/approve/:requestID", func(f *contractFixture) (string, string, interface{}) {
+		return fmt.Sprintf("/ride/%d/approve/%d", f.ride.ID, f.pending.ID), f.leader.FirebaseUID, nil
+	}},
+	{"POST /ride/:rideID/reject/:requestID", func(f *contractFixture) (string, string, interface{}) {
+		return fmt.Sprintf("/ride/%d/reject/%d", f.ride.ID, f.pending.ID), f.leader.FirebaseUID, nil
+	}},
+
+	{"GET /user/notifications", func(f *contractFixture) (string, string, interface{}) {
+		return "/user/notifications", f.rider.FirebaseUID, nil
+	}},
+	{"GET /user/notifications/unread-count", func(f *contractFixture) (string, string, interface{}) {
+		return "/user/notifications/unread-count", f.rider.FirebaseUID, nil
+	}},
+	{"POST /notification/:notificationID/read", func(f *contractFixture) (string, string, interface{}) {
+		return fmt.Sprintf("/notification/%d/read", f.notification.ID), f.rider.FirebaseUID, nil
+	}},
+	{"DELETE /notification/:notificationID", func(f *contractFixture) (string, string, interface{}) {
+		return fmt.Sprintf("/notification/%d", f.notification.ID), f.rider.FirebaseUID, nil
+	}},
+	{"POST /user/notifications/read-all", func(f *contractFixture) (string, string, interface{}) {
+		return "/user/notifications/read-all", f.rider.FirebaseUID, nil
+	}},
+	{"DELETE /user/notifications", func(f *contractFixture) (string, string, interface{}) {
+		return "/user/notifications?read_only=false", f.rider.FirebaseUID, nil
+	}},
+	{"GET /user/notification-preferences", func(f *contractFixture) (string, string, interface{}) {
+		return "/user/notification-preferences", f.rider.FirebaseUID, nil
+	}},
+	{"PUT /user/notification-preferences", func(f *contractFixture) (string, string, interface{}) {
+		return "/user/notification-preferences", f.rider.FirebaseUID, UpdateNotificationPreferencesRequest{
+			Preferences: []NotificationPreferenceEntry{{Type: "join_request", Enabled: true, Channels: []string{"in_app"}}},
+		}
+	}},
+
+	{"POST /user/saved-searches", func(f *contractFixture) (string, string, interface{}) {
+		return "/user/saved-searches", f.rider.FirebaseUID, SaveSearchRequest{Origin: "Campus", Destination: "Station", Date: f.otherDate}
+	}},
+	{"GET /user/saved-searches", func(f *contractFixture) (string, string, interface{}) {
+		return "/user/saved-searches", f.rider.FirebaseUID, nil
+	}},
+	{"DELETE /user/saved-searches/:searchID", func(f *contractFixture) (string, string, interface{}) {
+		return fmt.Sprintf("/user/saved-searches/%d", f.savedSearch.ID), f.rider.FirebaseUID, nil
+	}},
+	{"POST /user/devices", func(f *contractFixture) (string, string, interface{}) {
+		return "/user/devices", f.rider.FirebaseUID, RegisterDeviceRequest{Token: "tablet", Platform: "ios"}
+	}},
+	{"GET /user/devices", func(f *contractFixture) (string, string, interface{}) {
+		return "/user/devices", f.rider.FirebaseUID, nil
+	}},
+	{"PUT /user/devices/:deviceID", func(f *contractFixture) (string, string, interface{}) {
+		enabled := false
+		return fmt.Sprintf("/user/devices/%d", f.device.ID), f.rider.FirebaseUID, UpdateDeviceRequest{Enabled: &enabled}
+	}},
+	{"DELETE /user/devices/:deviceID", func(f *contractFixture) (string, string, interface{}) {
+		return fmt.Sprintf("/user/devices/%d", f.device.ID), f.rider.FirebaseUID, nil
+	}},
+}
+
+// signIn makes a bearer token the auth middleware accepts for uid, by caching it as verified
+func signIn(t *testing.T, uid string) string {
+	t.Helper()
+
+	prev := tokenCache
+	tokenCache = NewMemoryCache[*auth.Token](100)
+	t.Cleanup(func() { tokenCache = prev })
+
+	idToken := "test-token-" + uid
+	sum := sha256.Sum256([]byte(idToken))
+	tokenCache.Set(hex.EncodeToString(sum[:]), &auth.Token{UID: uid, Expires: time.Now().Add(time.Hour).Unix()}, time.Hour)
+	return idToken
+}
+
+// TestResponsesMatchOpenAPISpec sends every documented operation through the real router of each
+// version and checks the response against that version's spec
+func TestResponsesMatchOpenAPISpec(t *testing.T) {
+	prevStore := rateLimitStore
+	rateLimitStore = NewMemoryRateLimitStore()
+	t.Cleanup(func() { rateLimitStore = prevStore })
+
+	covered := map[string]bool{}
+	for _, tc := range contractCases {
+		covered[tc.op] = true
+	}
+	var missing []string
+	for _, op := range apiOperations {
+		if !covered[op.Method+" "+op.Path] {
+			missing = append(missing, op.Method+" "+op.Path)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		t.Fatalf("operations without a contract case: %v", missing)
+	}
+
+	for _, v := range apiVersions {
+		spec := loadSpec(t, v)
+		for _, tc := range contractCases {
+			t.Run(v.Prefix+" "+tc.op, func(t *testing.T) {
+				if postgresOnlyOps[tc.op] {
+					t.Skip("needs Postgres")
+				}
+				f := seedContractFixture(t)
+				r := gin.New()
+				r.Use(ErrorHandler())
+				v.mount(r)
+
+				path, uid, body := tc.request(f)
+				var raw []byte
+				if body != nil {
+					var err error
+					if raw, err = json.Marshal(body); err != nil {
+						t.Fatal(err)
+					}
+				}
+				method, ginPath, _ := strings.Cut(tc.op, " ")
+				req := httptest.NewRequest(method, v.Prefix+path, bytes.NewReader(raw))
+				req.Header.Set("Content-Type", "application/json")
+				if uid != "" {
+					req.Header.Set("Authorization", "Bearer "+signIn(t, uid))
+				}
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+
+				// Each case is set up to succeed, so its success schema is what gets checked
+				if w.Code >= 300 {
+					t.Fatalf("status = %d; body: %s", w.Code, w.Body.String())
+				}
+				if problems := checkResponse(t, spec, method, ginPath, w); len(problems) > 0 {
+					t.Fatalf("response doesn't match the spec:\n%s\nbody: %s", strings.Join(problems, "\n"), w.Body.String())
+				}
+			})
+		}
+	}
+}
+
+func TestCheckSchemaCatchesDrift(t *testing.T) {
+	spec := loadSpec(t, apiVersions[0])
+	schema := map[string]interface{}{"$ref": "#/components/schemas/MessageResponse"}
+
+	tests := []struct {
+		name string
+		body string
+		ok   bool
+	}{
+		{"matches", `{"message": "hi"}`, true},
+		{"missing field", `{}`, false},
+		{"undocumented field", `{"message": "hi", "extra": 1}`, false},
+		{"wrong type", `{"message": 1}`, false},
+		{"null", `null`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body interface{}
+			if err := json.Unmarshal([]byte(tt.body), &body); err != nil {
+				t.Fatal(err)
+			}
+			if problems := checkSchema(spec, schema, body, "body"); (len(problems) == 0) != tt.ok {
+				t.Fatalf("problems = %v, want ok = %v", problems, tt.ok)
+			}
+		})
+	}
+}
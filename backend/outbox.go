@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	outboxMaxAttempts  = 10
+	outboxBatchSize    = 50
+	outboxRetryBackoff = 5 * time.Second
+
+	// How long a worker has to dispatch a claimed batch before another worker may claim it again.
+	// Push alone may take pushSendTimeout per event.
+	outboxLease = 10 * time.Minute
+
+	// Dispatched events are kept this long, then purged
+	outboxRetention = 7 * 24 * time.Hour
+)
+
+// NotificationOutbox is a notification event recorded in the same transaction as the state
+// change that caused it. The outbox worker delivers it afterwards, so a committed change always
+// produces its notification and a rolled back one never does.
+//
+// An event can be dispatched more than once, e.g. when a worker dies before recording the outcome,
+// so channels deliver at most once per event: the feed and email rows are unique per outbox ID,
+// and pushes carry it as their collapse key.
+type NotificationOutbox struct {
+	ID      uint   `gorm:"primaryKey"`
+	UserID  string `gorm:"not null"` // Firebase UID of the recipient
+	Title   string `gorm:"type:varchar(200);not null"`
+	Message string `gorm:"type:text;not null"`
+	Type    string `gorm:"type:varchar(50);not null"`
+	RideID  uint   `gorm:"not null"`
+
+	// Snapshot of the ride at the time of the event
+	Origin      string
+	Destination string
+	Date        string
+	Time        string
+
+	Status            string    `gorm:"type:varchar(20);index;not null"` // "pending", "processing", "dispatched" or "failed"
+	Attempts          int       `gorm:"not null"`
+	DeliveredChannels string    `gorm:"type:varchar(200)"` // Channels already delivered, skipped on retry
+	NextAttemptAt     time.Time `gorm:"index;not null"`    // While processing, when the worker's lease runs out
+	LastError         string    `gorm:"type:text"`
+	DispatchedAt      *time.Time
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
+// notification converts the outbox event into the notification delivered to channels
+func (o NotificationOutbox) notification() Notification {
+	outboxID := o.ID
+	return Notification{
+		UserID:      o.UserID,
+		Title:       o.Title,
+		Message:     o.Message,
+		Type:        o.Type,
+		RideID:      o.RideID,
+		Origin:      o.Origin,
+		Destination: o.Destination,
+		Date:        o.Date,
+		Time:        o.Time,
+		OutboxID:    &outboxID,
+		CreatedAt:   o.CreatedAt,
+		UpdatedAt:   o.CreatedAt,
+	}
+}
+
+// deliveredSet returns the channels that already received this event
+func (o NotificationOutbox) deliveredSet() map[string]bool {
+	delivered := make(map[string]bool)
+	for _, name := range strings.Split(o.DeliveredChannels, ",") {
+		if name != "" {
+			delivered[name] = true
+		}
+	}
+	return delivered
+}
+
+// Wakes the worker right after a handler commits, instead of waiting for the next tick
+var outboxWake = make(chan struct{}, 1)
+
+// wakeNotificationOutbox asks the worker to run now; it never blocks
+func wakeNotificationOutbox() {
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// claimOutboxEvents leases up to outboxBatchSize due events to the caller. Claimed events are
+// marked "processing" until the lease runs out, so other workers skip them while they are
+// dispatched outside any transaction, and pick them up again if this worker dies first. The claim
+// counts as an attempt.
+func claimOutboxEvents(db *gorm.DB) ([]NotificationOutbox, error) {
+	var batch []NotificationOutbox
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status IN ? AND next_attempt_at <= ?", []string{"pending", "processing"}, now).
+			Order("id").Limit(outboxBatchSize).
+			Find(&batch).Error; err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, 0, len(batch))
+		for _, event := range batch {
+			ids = append(ids, event.ID)
+		}
+		return tx.Model(&NotificationOutbox{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+			"status":          "processing",
+			"attempts":        gorm.Expr("attempts + 1"),
+			"next_attempt_at": now.Add(outboxLease),
+		}).Error
+	})
+	return batch, err
+}
+
+// processNotificationOutbox dispatches due events, rescheduling failures with exponential backoff
+func processNotificationOutbox(ctx context.Context) error {
+	db := DB.WithContext(ctx)
+
+	batch, err := claimOutboxEvents(db)
+	if err != nil {
+		return err
+	}
+
+	for _, event := range batch {
+		attempts := event.Attempts + 1 // Counted by the claim
+		delivered := event.deliveredSet()
+		err := dispatchNotification(ctx, event.notification(), delivered)
+
+		names := make([]string, 0, len(delivered))
+		for name := range delivered {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		updates := map[string]interface{}{"delivered_channels": strings.Join(names, ",")}
+		switch {
+		case err == nil:
+			updates["status"] = "dispatched"
+			updates["dispatched_at"] = time.Now()
+			updates["last_error"] = ""
+		case attempts >= outboxMaxAttempts:
+			updates["status"] = "failed"
+			updates["last_error"] = err.Error()
+			slog.Error("❌ Giving up on notification", "outbox_id", event.ID, "uid", event.UserID, "ride_id", event.RideID, "type", event.Type, "attempts", attempts, "error", err)
+		default:
+			updates["status"] = "pending"
+			updates["last_error"] = err.Error()
+			updates["next_attempt_at"] = time.Now().Add(outboxRetryBackoff << event.Attempts)
+		}
+
+		if err := db.Model(&NotificationOutbox{}).Where("id = ? AND status = ?", event.ID, "processing").Updates(updates).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeDispatchedOutbox deletes events dispatched before cutoff, and emails sent before it.
+// Failed events and emails are kept for inspection.
+func purgeDispatchedOutbox(ctx context.Context, cutoff time.Time) (int64, error) {
+	db := DB.WithContext(ctx)
+
+	result := db.Where("status = ? AND dispatched_at < ?", "dispatched", cutoff).Delete(&NotificationOutbox{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	emails := db.Where("status = ? AND sent_at < ?", "sent", cutoff).Delete(&EmailOutbox{})
+	return result.RowsAffected + emails.RowsAffected, emails.Error
+}
+
+// StartOutboxPurge purges dispatched events and sent emails older than outboxRetention every interval
+func StartOutboxPurge(interval time.Duration) {
+	runWorker(func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			// Let a run in progress finish even if shutdown starts
+			purged, err := purgeDispatchedOutbox(context.WithoutCancel(ctx), time.Now().Add(-outboxRetention))
+			if err != nil {
+				slog.Error("❌ Failed to purge the notification outbox", "error", err)
+			} else if purged > 0 {
+				slog.Info("🧹 Purged dispatched outbox rows", "count", purged)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// StartNotificationOutboxWorker dispatches pending notifications every interval or when woken, until shutdown.
+// Events still pending at shutdown stay in the outbox for the next start.
+func StartNotificationOutboxWorker(interval time.Duration) {
+	runWorker(func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-outboxWake:
+			}
+
+			// Let a run in progress finish even if shutdown starts
+			if err := processNotificationOutbox(context.WithoutCancel(ctx)); err != nil {
+				slog.Error("❌ Failed to process notification outbox", "error", err)
+			}
+		}
+	})
+}
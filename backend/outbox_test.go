@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// queueOutboxEvent records a notification for user the way handlers do
+func queueOutboxEvent(t *testing.T, user *User, notificationType string) NotificationOutbox {
+	t.Helper()
+
+	ride := Ride{ID: 9, Origin: "Campus", Destination: "Airport", Date: "2026-10-20", Time: "09:00"}
+	if err := createNotification(DB, user.FirebaseUID, "Title", "Message", notificationType, ride); err != nil {
+		t.Fatalf("create notification: %v", err)
+	}
+	var event NotificationOutbox
+	if err := DB.Order("id DESC").First(&event).Error; err != nil {
+		t.Fatalf("load outbox event: %v", err)
+	}
+	return event
+}
+
+func reloadOutboxEvent(t *testing.T, id uint) NotificationOutbox {
+	t.Helper()
+
+	var event NotificationOutbox
+	if err := DB.First(&event, id).Error; err != nil {
+		t.Fatalf("reload outbox event: %v", err)
+	}
+	return event
+}
+
+func TestOutboxRedeliveryIsIdempotent(t *testing.T) {
+	db := newTestDB(t)
+	sender := &fakePushSender{}
+	// Registered for cleanup, then swapped for the real channels
+	registerTestChannel(t, "email")
+	registerTestChannel(t, "push")
+	notificationChannels["email"] = EmailChannel{}
+	notificationChannels["push"] = PushChannel{sender: sender}
+
+	user := seedUser(t, db)
+	seedDevice(t, user, "phone", true)
+	if err := db.Create(&NotificationPreference{UserID: user.FirebaseUID, Type: "ride_cancelled", Enabled: true, Channels: "in_app,email,push"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	event := queueOutboxEvent(t, user, "ride_cancelled")
+
+	if err := processNotificationOutbox(context.Background()); err != nil {
+		t.Fatalf("process: %v", err)
+	}
+	got := reloadOutboxEvent(t, event.ID)
+	if got.Status != "dispatched" || got.Attempts != 1 || got.DeliveredChannels != "email,in_app,push" {
+		t.Fatalf("event = %+v, want dispatched on every channel", got)
+	}
+
+	// The worker died before recording the outcome: the event goes out again from scratch
+	db.Model(&NotificationOutbox{}).Where("id = ?", event.ID).Updates(map[string]interface{}{
+		"status": "pending", "delivered_channels": "", "next_attempt_at": time.Now().Add(-time.Second),
+	})
+	if err := processNotificationOutbox(context.Background()); err != nil {
+		t.Fatalf("process again: %v", err)
+	}
+
+	var feed, emails int64
+	db.Model(&Notification{}).Where("user_id = ?", user.FirebaseUID).Count(&feed)
+	db.Model(&EmailOutbox{}).Where("user_id = ?", user.FirebaseUID).Count(&emails)
+	if feed != 1 || emails != 1 {
+		t.Fatalf("feed has %d notifications and %d emails queued, want 1 of each", feed, emails)
+	}
+	// Push can't be taken back, but both sends collapse into one on the device
+	if sender.sent["phone"] != 2 || len(sender.keys) != 1 {
+		t.Fatalf("pushes = %v with collapse keys %v, want both under one key", sender.sent, sender.keys)
+	}
+}
+
+func TestOutboxRetriesFailedChannelsOnly(t *testing.T) {
+	db := newTestDB(t)
+	flaky := registerTestChannel(t, "test_flaky")
+	flaky.err = errors.New("unavailable")
+
+	user := seedUser(t, db)
+	if err := db.Create(&NotificationPreference{UserID: user.FirebaseUID, Type: "join_request", Enabled: true, Channels: "in_app,test_flaky"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	event := queueOutboxEvent(t, user, "join_request")
+
+	before := time.Now()
+	if err := processNotificationOutbox(context.Background()); err != nil {
+		t.Fatalf("process: %v", err)
+	}
+	got := reloadOutboxEvent(t, event.ID)
+	if got.Status != "pending" || got.Attempts != 1 || got.DeliveredChannels != "in_app" {
+		t.Fatalf("event = %+v, want pending with in_app delivered", got)
+	}
+	if wait := got.NextAttemptAt.Sub(before); wait < outboxRetryBackoff || wait > outboxRetryBackoff+5*time.Second {
+		t.Fatalf("retry in %s, want about %s", wait, outboxRetryBackoff)
+	}
+
+	flaky.err = nil
+	db.Model(&NotificationOutbox{}).Where("id = ?", event.ID).Update("next_attempt_at", time.Now().Add(-time.Second))
+	if err := processNotificationOutbox(context.Background()); err != nil {
+		t.Fatalf("process: %v", err)
+	}
+	if got := reloadOutboxEvent(t, event.ID); got.Status != "dispatched" || got.Attempts != 2 {
+		t.Fatalf("event = %+v, want dispatched on attempt 2", got)
+	}
+	if len(flaky.sent) != 1 {
+		t.Fatalf("flaky channel got %d sends, want 1", len(flaky.sent))
+	}
+}
+
+func TestOutboxDispatchesOutsideTransaction(t *testing.T) {
+	db := newTestDB(t)
+	probe := registerTestChannel(t, "test_probe")
+	user := seedUser(t, db)
+	if err := db.Create(&NotificationPreference{UserID: user.FirebaseUID, Type: "join_request", Enabled: true, Channels: "test_probe"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	event := queueOutboxEvent(t, user, "join_request")
+
+	// While a channel sends, the event is leased and the database is free for writes
+	probe.onSend = func() error {
+		if got := reloadOutboxEvent(t, event.ID); got.Status != "processing" {
+			return errors.New("event not leased while dispatching")
+		}
+		if batch, err := claimOutboxEvents(DB); err != nil || len(batch) != 0 {
+			return errors.New("a leased event was claimed twice")
+		}
+		return DB.Create(&Notification{UserID: "someone", Title: "t", Message: "m", Type: "join_request"}).Error
+	}
+	if err := processNotificationOutbox(context.Background()); err != nil {
+		t.Fatalf("process: %v", err)
+	}
+	if got := reloadOutboxEvent(t, event.ID); got.Status != "dispatched" {
+		t.Fatalf("event = %+v (last error %q), want dispatched", got, got.LastError)
+	}
+}
+
+func TestExpiredOutboxLeaseIsReclaimed(t *testing.T) {
+	db := newTestDB(t)
+	user := seedUser(t, db)
+	event := queueOutboxEvent(t, user, "join_request")
+
+	if batch, err := claimOutboxEvents(db); err != nil || len(batch) != 1 {
+		t.Fatalf("claim = %v, %v", batch, err)
+	}
+	if batch, err := claimOutboxEvents(db); err != nil || len(batch) != 0 {
+		t.Fatalf("claimed a leased event: %v, %v", batch, err)
+	}
+
+	db.Model(&NotificationOutbox{}).Where("id = ?", event.ID).Update("next_attempt_at", time.Now().Add(-time.Second))
+	if batch, err := claimOutboxEvents(db); err != nil || len(batch) != 1 {
+		t.Fatalf("expired lease not reclaimed: %v, %v", batch, err)
+	}
+}
+
+func TestPurgeDispatchedOutbox(t *testing.T) {
+	db := newTestDB(t)
+	old := time.Now().Add(-outboxRetention - time.Hour)
+	recent := time.Now().Add(-time.Hour)
+
+	events := []NotificationOutbox{
+		{Status: "dispatched", DispatchedAt: &old},    // Purged
+		{Status: "dispatched", DispatchedAt: &recent}, // Kept
+		{Status: "failed"},                            // Kept for inspection
+		{Status: "pending"},                           // Kept
+	}
+	for i := range events {
+		events[i].UserID, events[i].Title, events[i].Message, events[i].Type = "uid", "t", "m", "join_request"
+		events[i].NextAttemptAt = time.Now()
+	}
+	if err := db.Create(&events).Error; err != nil {
+		t.Fatal(err)
+	}
+	emails := []EmailOutbox{
+		{Status: "sent", SentAt: &old},    // Purged
+		{Status: "sent", SentAt: &recent}, // Kept
+		{Status: "failed"},                // Kept
+	}
+	for i := range emails {
+		emails[i].UserID, emails[i].ToAddress, emails[i].Subject = "uid", "a@example.com", "s"
+		emails[i].NextAttemptAt = time.Now()
+	}
+	if err := db.Create(&emails).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	purged, err := purgeDispatchedOutbox(context.Background(), time.Now().Add(-outboxRetention))
+	if err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if purged != 2 {
+		t.Fatalf("purged %d rows, want 2", purged)
+	}
+
+	var leftEvents, leftEmails int64
+	db.Model(&NotificationOutbox{}).Count(&leftEvents)
+	db.Model(&EmailOutbox{}).Count(&leftEmails)
+	if leftEvents != 3 || leftEmails != 2 {
+		t.Fatalf("%d events and %d emails left, want 3 and 2", leftEvents, leftEmails)
+	}
+}
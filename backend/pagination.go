@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// Pagination is the shared list contract: ?limit=N&cursor=<opaque>.
+// Requests that send neither parameter get the full, unwrapped array as before.
+type Pagination struct {
+	Enabled bool
+	Limit   int
+	After   *pageCursor // Position of the last row of the previous page
+	scope   string      // Stamped on the cursors this pagination hands out
+}
+
+// pageCursor is the keyset position encoded into the opaque cursor string
+type pageCursor struct {
+	Scope string   `json:"s"` // The list and sort order the cursor belongs to, e.g. "rides_posted"
+	ID    uint     `json:"id"`
+	Keys  []string `json:"k,omitempty"` // Extra sort keys, e.g. ride date and time
+}
+
+// Page is the response envelope for paginated list endpoints
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor"` // Empty when there are no more results
+}
+
+// parsePagination reads limit and cursor from the query string. scope names the list and its
+// sort order, and cursorKeys is the number of sort keys its cursors carry besides the ID. A cursor
+// handed out for another scope, such as another endpoint or sort, is rejected.
+func parsePagination(c *gin.Context, scope string, cursorKeys int) (Pagination, error) {
+	limitParam, hasLimit := c.GetQuery("limit")
+	cursorParam, hasCursor := c.GetQuery("cursor")
+	if !hasLimit && !hasCursor {
+		return Pagination{}, nil
+	}
+
+	p := Pagination{Enabled: true, Limit: defaultPageLimit, scope: scope}
+
+	if hasLimit {
+		limit, err := strconv.Atoi(limitParam)
+		if err != nil || limit < 1 {
+			return p, errors.New("limit must be a positive integer")
+		}
+		if limit > maxPageLimit {
+			limit = maxPageLimit
+		}
+		p.Limit = limit
+	}
+
+	if hasCursor && cursorParam != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(cursorParam)
+		if err != nil {
+			return p, errors.New("invalid cursor")
+		}
+		var cursor pageCursor
+		if err := json.Unmarshal(raw, &cursor); err != nil || cursor.Scope != scope || len(cursor.Keys) != cursorKeys {
+			return p, errors.New("invalid cursor")
+		}
+		p.After = &cursor
+	}
+
+	return p, nil
+}
+
+// limitQuery fetches one row more than the page size so we can tell whether another page exists
+func (p Pagination) limitQuery(q *gorm.DB) *gorm.DB {
+	if !p.Enabled {
+		return q
+	}
+	return q.Limit(p.Limit + 1)
+}
+
+// afterID continues a list ordered by "<column>" from the cursor
+func (p Pagination) afterID(q *gorm.DB, column string) *gorm.DB {
+	if p.After == nil {
+		return q
+	}
+	return q.Where(column+" > ?", p.After.ID)
+}
+
+// afterIDDesc continues a list ordered by "<column> DESC" from the cursor
+func (p Pagination) afterIDDesc(q *gorm.DB, column string) *gorm.DB {
+	if p.After == nil {
+		return q
+	}
+	return q.Where(column+" < ?", p.After.ID)
+}
+
+// pageRows trims rows to the page size and returns the cursor for the next page
+func pageRows[T any](p Pagination, rows []T, cursorOf func(T) pageCursor) ([]T, string) {
+	if !p.Enabled || len(rows) <= p.Limit {
+		return rows, ""
+	}
+
+	rows = rows[:p.Limit]
+	return rows, p.encodeCursor(cursorOf(rows[len(rows)-1]))
+}
+
+// pageRowsByOffset pages rows that were ordered in memory, where there are no sort keys to resume
+// from. The cursor holds the offset of the next page as its one key.
+func pageRowsByOffset[T any](p Pagination, rows []T, idOf func(T) uint) ([]T, string) {
+	if !p.Enabled {
+		return rows, ""
+	}
+
+	start := 0
+	if p.After != nil {
+		if offset, err := strconv.Atoi(p.After.Keys[0]); err == nil && offset > 0 {
+			start = min(offset, len(rows))
+		}
+	}
+	end := min(start+p.Limit, len(rows))
+	if end == len(rows) {
+		return rows[start:end], ""
+	}
+	return rows[start:end], p.encodeCursor(pageCursor{ID: idOf(rows[end-1]), Keys: []string{strconv.Itoa(end)}})
+}
+
+// encodeCursor stamps cursor with the pagination's scope and encodes it
+func (p Pagination) encodeCursor(cursor pageCursor) string {
+	cursor.Scope = p.scope
+	raw, err := json.Marshal(cursor)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// respondList writes items either as a bare array or, when paginated, wrapped in a Page
+func respondList[T any](c *gin.Context, p Pagination, items []T, nextCursor string) {
+	c.JSON(http.StatusOK, listBody(p, items, nextCursor))
+}
+
+// listBody is the response respondList writes
+func listBody[T any](p Pagination, items []T, nextCursor string) interface{} {
+	if !p.Enabled {
+		return items
+	}
+	if items == nil {
+		items = []T{}
+	}
+	return Page[T]{Items: items, NextCursor: nextCursor}
+}
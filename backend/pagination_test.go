@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// scopedCursor encodes a cursor as the list named scope would hand it out
+func scopedCursor(scope string, cursor pageCursor) string {
+	return url.QueryEscape(Pagination{scope: scope}.encodeCursor(cursor))
+}
+
+// pageThrough follows next_cursor from path until the last page and returns how many items it saw
+func pageThrough[T any](t *testing.T, handler gin.HandlerFunc, route, path, uid string) int {
+	t.Helper()
+
+	seen := 0
+	next := path
+	for pages := 0; ; pages++ {
+		if pages > 50 {
+			t.Fatal("pagination did not finish")
+		}
+		var page Page[T]
+		decodeJSON(t, serve(t, handler, "GET", route, next, uid, nil), http.StatusOK, &page)
+		seen += len(page.Items)
+		if page.NextCursor == "" {
+			return seen
+		}
+		next = path + "&cursor=" + url.QueryEscape(page.NextCursor)
+	}
+}
+
+func TestListShape(t *testing.T) {
+	db := newTestDB(t)
+	leader := seedUser(t, db)
+	for i := 0; i < defaultPageLimit+5; i++ {
+		seedRide(t, db, leader, "Campus", "Airport", daysFromNow(1), fmt.Sprintf("%02d:00", i%24), 4)
+	}
+
+	// Without limit or cursor a list is the bare array
+	var all []Ride
+	decodeJSON(t, serve(t, GetRidesPostedByUser, "GET", "/user/rides/posted", "/user/rides/posted", leader.FirebaseUID, nil), http.StatusOK, &all)
+	if len(all) != defaultPageLimit+5 {
+		t.Fatalf("listed %d rides, want %d", len(all), defaultPageLimit+5)
+	}
+
+	// With either it is a page, and following next_cursor visits every ride once
+	var first Page[Ride]
+	decodeJSON(t, serve(t, GetRidesPostedByUser, "GET", "/user/rides/posted", "/user/rides/posted?cursor=", leader.FirebaseUID, nil), http.StatusOK, &first)
+	if len(first.Items) != defaultPageLimit || first.NextCursor == "" {
+		t.Fatalf("first page has %d rides, want %d and a next cursor", len(first.Items), defaultPageLimit)
+	}
+	if got := pageThrough[Ride](t, GetRidesPostedByUser, "/user/rides/posted", "/user/rides/posted?limit=7", leader.FirebaseUID); got != len(all) {
+		t.Fatalf("pages listed %d rides, want %d", got, len(all))
+	}
+
+	// An empty page is still wrapped
+	var empty Page[Ride]
+	stranger := seedUser(t, db)
+	decodeJSON(t, serve(t, GetRidesJoinedByUser, "GET", "/user/rides/joined", "/user/rides/joined?limit=5", stranger.FirebaseUID, nil), http.StatusOK, &empty)
+	if empty.Items == nil || len(empty.Items) != 0 || empty.NextCursor != "" {
+		t.Fatalf("empty list = %+v, want an empty page", empty)
+	}
+}
+
+func TestPagingEveryList(t *testing.T) {
+	db := newTestDB(t)
+	leader, rider := seedUser(t, db), seedUser(t, db)
+	ride := seedRide(t, db, leader, "Campus", "Airport", daysFromNow(1), "09:00", 8)
+
+	// Three of everything
+	for i := 0; i < 3; i++ {
+		passenger := seedUser(t, db)
+		seedRequest(t, db, passenger, ride, "pending")
+		if err := db.Create(&Participant{RideID: ride.ID, UserID: seedUser(t, db).FirebaseUID}).Error; err != nil {
+			t.Fatal(err)
+		}
+		other := seedRide(t, db, leader, "Campus", "Station", daysFromNow(i+1), "10:00", 4)
+		seedRequest(t, db, rider, other, "approved")
+		date := daysFromNow(i + 1)
+		if err := db.Create(&SavedSearch{UserID: rider.FirebaseUID, Origin: "Campus", Destination: "Airport", Date: date}).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Create(&TripRequest{UserID: rider.FirebaseUID, Origin: "Campus", Destination: "Airport", Date: date, SeatsNeeded: 1, Status: "open"}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	participants := fmt.Sprintf("/ride/%d/participants?limit=2", ride.ID)
+	requests := fmt.Sprintf("/rides/%d/requests?limit=2", ride.ID)
+	tests := []struct {
+		name string
+		got  int
+	}{
+		{"participants", pageThrough[ParticipantResponse](t, GetRideParticipants, "/ride/:rideID/participants", participants, leader.FirebaseUID)},
+		{"join requests", pageThrough[JoinRequestResponse](t, GetJoinRequestsForRide, "/rides/:rideID/requests", requests, leader.FirebaseUID)},
+		{"privileges", pageThrough[PrivilegeResponse](t, GetUserPrivileges, "/user/privileges", "/user/privileges?limit=2", rider.FirebaseUID)},
+		{"saved searches", pageThrough[SavedSearch](t, GetSavedSearches, "/user/saved-searches", "/user/saved-searches?limit=2", rider.FirebaseUID)},
+		{"trip requests", pageThrough[TripRequest](t, GetUserTripRequests, "/user/trip-requests", "/user/trip-requests?limit=2", rider.FirebaseUID)},
+	}
+	for _, tt := range tests {
+		if tt.got != 3 {
+			t.Errorf("%s: paged through %d items, want 3", tt.name, tt.got)
+		}
+	}
+}
+
+func TestForeignCursorRejected(t *testing.T) {
+	db := newTestDB(t)
+	user := seedUser(t, db)
+
+	leader := seedUser(t, db)
+	seedRide(t, db, leader, "Campus", "Airport", daysFromNow(1), "09:00", 4)
+	seedRide(t, db, leader, "Campus", "Airport", daysFromNow(1), "10:00", 4)
+
+	// A real cursor from posted rides has the same keys as one from a time-ordered search
+	var posted Page[Ride]
+	decodeJSON(t, serve(t, GetRidesPostedByUser, "GET", "/user/rides/posted", "/user/rides/posted?limit=1", leader.FirebaseUID, nil), http.StatusOK, &posted)
+	postedCursor := url.QueryEscape(posted.NextCursor)
+
+	idCursor := scopedCursor("notifications", pageCursor{ID: 5})
+	rideCursor := scopedCursor("rides_posted", pageCursor{ID: 5, Keys: []string{daysFromNow(1), "09:00"}})
+	offsetCursor := scopedCursor("ride_filter:price", pageCursor{ID: 5, Keys: []string{"3"}})
+	unscoped := url.QueryEscape(Pagination{}.encodeCursor(pageCursor{ID: 5}))
+
+	tests := []struct {
+		name    string
+		handler gin.HandlerFunc
+		route   string
+		path    string
+	}{
+		{"time-ordered search with a posted rides cursor", FilterRides, "/ride/filter",
+			"/ride/filter?origin=Campus&destination=Airport&date=" + daysFromNow(1) + "&cursor=" + postedCursor},
+		{"sent requests with a notifications cursor", GetUserSentRequests, "/user/requests", "/user/requests?cursor=" + idCursor},
+		{"seat-ordered search with a price-ordered cursor", FilterRides, "/ride/filter",
+			"/ride/filter?origin=Campus&destination=Airport&date=" + daysFromNow(1) + "&sort=seats&cursor=" + offsetCursor},
+		{"notifications with an unscoped cursor", GetUserNotifications, "/user/notifications", "/user/notifications?cursor=" + unscoped},
+		{"posted rides with an ID cursor", GetRidesPostedByUser, "/user/rides/posted", "/user/rides/posted?cursor=" + idCursor},
+		{"joined rides with an offset cursor", GetRidesJoinedByUser, "/user/rides/joined", "/user/rides/joined?cursor=" + offsetCursor},
+		{"trip requests with an ID cursor", GetOpenTripRequests, "/trip-requests", "/trip-requests?cursor=" + idCursor},
+		{"notifications with a ride cursor", GetUserNotifications, "/user/notifications", "/user/notifications?cursor=" + rideCursor},
+		{"time-ordered search with an offset cursor", FilterRides, "/ride/filter",
+			"/ride/filter?origin=Campus&destination=Airport&date=" + daysFromNow(1) + "&cursor=" + offsetCursor},
+		{"ranked search with a ride cursor", FilterRides, "/ride/filter",
+			"/ride/filter?origin=Campus&destination=Airport&date=" + daysFromNow(1) + "&sort=price&cursor=" + rideCursor},
+		{"garbage cursor", GetUserNotifications, "/user/notifications", "/user/notifications?cursor=not-a-cursor"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body APIError
+			decodeJSON(t, serve(t, tt.handler, "GET", tt.route, tt.path, user.FirebaseUID, nil), http.StatusBadRequest, &body)
+			if body.Code != CodeInvalidRequest {
+				t.Fatalf("code = %s, want %s", body.Code, CodeInvalidRequest)
+			}
+		})
+	}
+}
+
+func TestJoinedRidesValidatesWhenWithoutRides(t *testing.T) {
+	db := newTestDB(t)
+	user := seedUser(t, db)
+
+	w := serve(t, GetRidesJoinedByUser, "GET", "/user/rides/joined", "/user/rides/joined?when=tomorrow", user.FirebaseUID, nil)
+	decodeJSON(t, w, http.StatusBadRequest, nil)
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // Participant represents users who have actually joined a ride (approved and confirmed)
@@ -19,12 +21,57 @@ type Participant struct {
 	UpdatedAt time.Time
 }
 
-// GET /ride/:rideID/participants - Get all participants in a ride with leader-specific details
+// ParticipantResponse is a ride participant; Phone is only filled in for the ride leader
+type ParticipantResponse struct {
+	ParticipantID uint      `json:"participant_id"`
+	Name          string    `json:"name"`
+	Gender        string    `json:"gender"`
+	JoinedAt      time.Time `json:"joined_at"`
+	Phone         string    `json:"phone,omitempty"`
+}
+
+// JoinRideResponse confirms the user joined a ride
+type JoinRideResponse struct {
+	Message string `json:"message"`
+	RideID  int    `json:"ride_id"`
+}
+
+// CancelParticipationResponse says what was cancelled: "request_cancelled" or "participation_cancelled"
+type CancelParticipationResponse struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+}
+
+// PrivilegeResponse is an approved join request together with the ride it grants access to
+type PrivilegeResponse struct {
+	RequestID      uint      `json:"request_id"`
+	RideID         uint      `json:"ride_id"`
+	Origin         string    `json:"origin"`
+	Destination    string    `json:"destination"`
+	Date           string    `json:"date"`
+	Time           string    `json:"time"`
+	Price          float64   `json:"price"`
+	SeatsAvailable int       `json:"seats_available"`
+	TotalSeats     int       `json:"total_seats"`
+	CanJoin        bool      `json:"can_join"`
+	ApprovedAt     time.Time `json:"approved_at"`
+}
+
+// GET /ride/:rideID/participants?limit=20&cursor=... - Get the participants in a ride, in joining order, with leader-specific details
 func GetRideParticipants(c *gin.Context) {
+	ctx := c.Request.Context()
+	db := DB.WithContext(ctx)
+
 	rideIDParam := c.Param("rideID")
 	rideID, err := strconv.Atoi(rideIDParam)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ride ID"})
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid ride ID")
+		return
+	}
+
+	page, err := parsePagination(c, "ride_participants", 0)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
 		return
 	}
 
@@ -32,101 +79,128 @@ func GetRideParticipants(c *gin.Context) {
 
 	// Check if the ride exists
 	var ride Ride
-	if err := DB.First(&ride, "id = ?", rideID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Ride not found"})
+	if err := db.First(&ride, "id = ?", rideID).Error; err != nil {
+		abortWithError(c, http.StatusNotFound, CodeRideNotFound, "Ride not found")
 		return
 	}
 
 	// Get current user to check if they are the leader
-	currentUser, err := getUser(userID)
+	currentUser, err := getUser(ctx, userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		abortWithError(c, http.StatusNotFound, CodeUserNotFound, "User not found")
 		return
 	}
 
 	isLeader := ride.LeaderID == currentUser.ID
 
-	// Fetch all participants for the ride
+	// Fetch the participants for the ride
 	var participants []Participant
-	if err := DB.Where("ride_id = ?", rideID).Find(&participants).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch participants"})
+	query := page.limitQuery(page.afterID(db.Where("ride_id = ?", rideID), "id")).Order("id")
+	if err := query.Find(&participants).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to fetch participants")
+		return
+	}
+	participants, nextCursor := pageRows(page, participants, func(p Participant) pageCursor {
+		return pageCursor{ID: p.ID}
+	})
+
+	uids := make([]string, 0, len(participants))
+	for _, p := range participants {
+		uids = append(uids, p.UserID)
+	}
+
+	users, err := getUsersByFirebaseUIDs(ctx, uids)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to fetch participant details")
 		return
 	}
 
 	// Build response with participant details (phone number only for leaders)
-	var response []map[string]interface{}
+	var response []ParticipantResponse
 	for _, p := range participants {
-		user, err := getUser(p.UserID)
-		if err != nil {
+		user, ok := users[p.UserID]
+		if !ok {
 			continue // skip if user doesn't exist
 		}
 
-		entry := map[string]interface{}{
-			"participant_id": p.ID,
-			"name":           user.Name,
-			"gender":         user.Gender,
-			"joined_at":      p.JoinedAt,
+		entry := ParticipantResponse{
+			ParticipantID: p.ID,
+			Name:          user.Name,
+			Gender:        user.Gender,
+			JoinedAt:      p.JoinedAt,
 		}
 
 		// Only include phone number if the current user is the ride leader
 		if isLeader {
-			entry["phone"] = user.Phone
+			entry.Phone = user.Phone
 		}
 
 		response = append(response, entry)
 	}
 
-	c.JSON(http.StatusOK, response)
+	respondList(c, page, response, nextCursor)
 }
 
 // DELETE /ride/:rideID/participant/:participantID
 func RemoveParticipant(c *gin.Context) {
+	ctx := c.Request.Context()
+	db := DB.WithContext(ctx)
+
 	rideIDParam := c.Param("rideID")
 	rideID, err := strconv.Atoi(rideIDParam)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ride ID"})
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid ride ID")
 		return
 	}
 
 	participantIDParam := c.Param("participantID")
 	participantID, err := strconv.Atoi(participantIDParam)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid participant ID"})
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid participant ID")
 		return
 	}
 
 	userID := c.MustGet("uid").(string)
 
 	var ride Ride
-	if err := DB.First(&ride, "id = ?", rideID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Ride not found"})
+	if err := db.First(&ride, "id = ?", rideID).Error; err != nil {
+		abortWithError(c, http.StatusNotFound, CodeRideNotFound, "Ride not found")
 		return
 	}
 
-	user, err := getUser(userID)
+	user, err := getUser(ctx, userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		abortWithError(c, http.StatusNotFound, CodeUserNotFound, "User not found")
 		return
 	}
 
 	if ride.LeaderID != user.ID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You are not the leader of this ride"})
+		abortWithError(c, http.StatusForbidden, CodeNotRideLeader, "You are not the leader of this ride")
 		return
 	}
 
 	var participant Participant
-	if err := DB.Where("id = ? AND ride_id = ?", participantID, rideID).First(&participant).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Participant not found in this ride"})
+	if err := db.Where("id = ? AND ride_id = ?", participantID, rideID).First(&participant).Error; err != nil {
+		abortWithError(c, http.StatusNotFound, CodeParticipantNotFound, "Participant not found in this ride")
+		return
+	}
+
+	// Remove the participant and notify them in one transaction
+	tx := db.Begin()
+	if tx.Error != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to start transaction")
 		return
 	}
 
-	if err := DB.Delete(&participant).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove participant"})
+	if err := tx.Delete(&participant).Error; err != nil {
+		tx.Rollback()
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to remove participant")
 		return
 	}
 
-	if err := DB.Model(&ride).Update("seats_filled", ride.SeatsFilled-1).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update ride seats"})
+	if err := tx.Model(&ride).Update("seats_filled", ride.SeatsFilled-1).Error; err != nil {
+		tx.Rollback()
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to update ride seats")
 		return
 	}
 
@@ -134,27 +208,39 @@ func RemoveParticipant(c *gin.Context) {
 	title := "Removed from Ride"
 	message := fmt.Sprintf("You have been removed from the ride from %s to %s on %s at %s",
 		ride.Origin, ride.Destination, ride.Date, ride.Time)
-	if err := createNotification(participant.UserID, title, message, "participant_removed", uint(rideID)); err != nil {
-		// Log error but don't fail the request
-		fmt.Printf("Failed to create notification: %v\n", err)
+	if err := createNotification(tx, participant.UserID, title, message, "participant_removed", ride); err != nil {
+		tx.Rollback()
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to create notification")
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to commit transaction")
+		return
 	}
+	wakeNotificationOutbox()
+	cancellationsTotal.WithLabelValues("participant_removed").Inc()
+	invalidateRideSearch(ride)
 
-	c.JSON(http.StatusOK, gin.H{"message": "Participant removed successfully"})
+	c.JSON(http.StatusOK, MessageResponse{Message: "Participant removed successfully"})
 }
 
 // POST /ride/:rideID/approve/:requestID - Approve a join request (gives user privilege to join)
 func ApproveJoinRequest(c *gin.Context) {
+	ctx := c.Request.Context()
+	db := DB.WithContext(ctx)
+
 	rideIDParam := c.Param("rideID")
 	rideID, err := strconv.Atoi(rideIDParam)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ride ID"})
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid ride ID")
 		return
 	}
 
 	requestIDParam := c.Param("requestID")
 	requestID, err := strconv.Atoi(requestIDParam)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request ID"})
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request ID")
 		return
 	}
 
@@ -162,33 +248,41 @@ func ApproveJoinRequest(c *gin.Context) {
 
 	// Check if the user is the leader of this ride
 	var ride Ride
-	if err := DB.First(&ride, "id = ?", rideID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Ride not found"})
+	if err := db.First(&ride, "id = ?", rideID).Error; err != nil {
+		abortWithError(c, http.StatusNotFound, CodeRideNotFound, "Ride not found")
 		return
 	}
 
 	// Get user to find their ID for comparison
-	user, err := getUser(userID)
+	user, err := getUser(ctx, userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		abortWithError(c, http.StatusNotFound, CodeUserNotFound, "User not found")
 		return
 	}
 
 	if ride.LeaderID != user.ID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You are not the leader of this ride"})
+		abortWithError(c, http.StatusForbidden, CodeNotRideLeader, "You are not the leader of this ride")
 		return
 	}
 
 	// Find the join request
 	var request Request
-	if err := DB.Where("id = ? AND ride_id = ? AND status = ?", requestID, rideID, "pending").First(&request).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Join request not found or already processed"})
+	if err := db.Where("id = ? AND ride_id = ? AND status = ?", requestID, rideID, "pending").First(&request).Error; err != nil {
+		abortWithError(c, http.StatusNotFound, CodeRequestNotFound, "Join request not found or already processed")
+		return
+	}
+
+	// Approve the request and notify the user in one transaction
+	tx := db.Begin()
+	if tx.Error != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to start transaction")
 		return
 	}
 
 	// Update request status to approved (gives privilege to join)
-	if err := DB.Model(&request).Update("status", "approved").Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve request"})
+	if err := tx.Model(&request).Update("status", "approved").Error; err != nil {
+		tx.Rollback()
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to approve request")
 		return
 	}
 
@@ -196,27 +290,38 @@ func ApproveJoinRequest(c *gin.Context) {
 	title := "Join Request Approved"
 	message := fmt.Sprintf("Your request to join the ride from %s to %s on %s at %s has been approved. You can now join the ride!",
 		ride.Origin, ride.Destination, ride.Date, ride.Time)
-	if err := createNotification(request.UserID, title, message, "request_approved", uint(rideID)); err != nil {
-		// Log error but don't fail the request
-		fmt.Printf("Failed to create notification: %v\n", err)
+	if err := createNotification(tx, request.UserID, title, message, "request_approved", ride); err != nil {
+		tx.Rollback()
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to create notification")
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Join request approved - user can now join the ride"})
+	if err := tx.Commit().Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to commit transaction")
+		return
+	}
+	wakeNotificationOutbox()
+	joinRequestsTotal.WithLabelValues("approved").Inc()
+
+	c.JSON(http.StatusOK, MessageResponse{Message: "Join request approved - user can now join the ride"})
 }
 
 // POST /ride/:rideID/reject/:requestID - Reject a join request
 func RejectJoinRequest(c *gin.Context) {
+	ctx := c.Request.Context()
+	db := DB.WithContext(ctx)
+
 	rideIDParam := c.Param("rideID")
 	rideID, err := strconv.Atoi(rideIDParam)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ride ID"})
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid ride ID")
 		return
 	}
 
 	requestIDParam := c.Param("requestID")
 	requestID, err := strconv.Atoi(requestIDParam)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request ID"})
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request ID")
 		return
 	}
 
@@ -224,86 +329,110 @@ func RejectJoinRequest(c *gin.Context) {
 
 	// Check if the user is the leader of this ride
 	var ride Ride
-	if err := DB.First(&ride, "id = ?", rideID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Ride not found"})
+	if err := db.First(&ride, "id = ?", rideID).Error; err != nil {
+		abortWithError(c, http.StatusNotFound, CodeRideNotFound, "Ride not found")
 		return
 	}
 
 	// Get user to find their ID for comparison
-	user, err := getUser(userID)
+	user, err := getUser(ctx, userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		abortWithError(c, http.StatusNotFound, CodeUserNotFound, "User not found")
 		return
 	}
 
 	if ride.LeaderID != user.ID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You are not the leader of this ride"})
+		abortWithError(c, http.StatusForbidden, CodeNotRideLeader, "You are not the leader of this ride")
 		return
 	}
 
 	// Find the join request
 	var request Request
-	if err := DB.Where("id = ? AND ride_id = ? AND status = ?", requestID, rideID, "pending").First(&request).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Join request not found or already processed"})
+	if err := db.Where("id = ? AND ride_id = ? AND status = ?", requestID, rideID, "pending").First(&request).Error; err != nil {
+		abortWithError(c, http.StatusNotFound, CodeRequestNotFound, "Join request not found or already processed")
 		return
 	}
 
 	// Update request status to revoked and set revoked timestamp
-	if err := DB.Model(&request).Updates(map[string]interface{}{
+	if err := db.Model(&request).Updates(map[string]interface{}{
 		"status":     "revoked",
 		"revoked_at": time.Now(),
 	}).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reject request"})
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to reject request")
 		return
 	}
+	joinRequestsTotal.WithLabelValues("rejected").Inc()
 
-	c.JSON(http.StatusOK, gin.H{"message": "Join request rejected"})
+	c.JSON(http.StatusOK, MessageResponse{Message: "Join request rejected"})
 }
 
-// GET /user/privileges - Get all approved ride privileges for the authenticated user
+// GET /user/privileges?limit=20&cursor=... - Get the approved ride privileges of the authenticated user, oldest first
 func GetUserPrivileges(c *gin.Context) {
+	ctx := c.Request.Context()
+	db := DB.WithContext(ctx)
+
 	userID := c.MustGet("uid").(string)
 
+	page, err := parsePagination(c, "privileges", 0)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
 	var requests []Request
-	if err := DB.Where("user_id = ? AND status = ?", userID, "approved").Find(&requests).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch privileges"})
+	query := page.limitQuery(page.afterID(db.Where("user_id = ? AND status = ?", userID, "approved"), "id")).Order("id")
+	if err := query.Find(&requests).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to fetch privileges")
 		return
 	}
+	requests, nextCursor := pageRows(page, requests, func(r Request) pageCursor {
+		return pageCursor{ID: r.ID}
+	})
 
-	var response []map[string]interface{}
+	rideIDs := make([]uint, 0, len(requests))
 	for _, req := range requests {
-		var ride Ride
-		if err := DB.First(&ride, "id = ?", req.RideID).Error; err != nil {
+		rideIDs = append(rideIDs, req.RideID)
+	}
+
+	rides, err := getRidesByIDs(ctx, rideIDs)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to fetch rides")
+		return
+	}
+
+	var response []PrivilegeResponse
+	for _, req := range requests {
+		ride, ok := rides[req.RideID]
+		if !ok {
 			continue
 		}
 
-		seatsAvailable := ride.SeatsFilled < ride.Seats
-
-		entry := map[string]interface{}{
-			"request_id":      req.ID,
-			"ride_id":         ride.ID,
-			"origin":          ride.Origin,
-			"destination":     ride.Destination,
-			"date":            ride.Date,
-			"time":            ride.Time,
-			"price":           ride.Price,
-			"seats_available": ride.Seats - ride.SeatsFilled,
-			"total_seats":     ride.Seats,
-			"can_join":        seatsAvailable,
-			"approved_at":     req.UpdatedAt,
-		}
-		response = append(response, entry)
+		response = append(response, PrivilegeResponse{
+			RequestID:      req.ID,
+			RideID:         ride.ID,
+			Origin:         ride.Origin,
+			Destination:    ride.Destination,
+			Date:           ride.Date,
+			Time:           ride.Time,
+			Price:          ride.Price,
+			SeatsAvailable: ride.Seats - ride.SeatsFilled,
+			TotalSeats:     ride.Seats,
+			CanJoin:        ride.SeatsFilled < ride.Seats,
+			ApprovedAt:     req.UpdatedAt,
+		})
 	}
 
-	c.JSON(http.StatusOK, response)
+	respondList(c, page, response, nextCursor)
 }
 
 // POST /ride/:rideID/join-ride - User joins a ride using their privilege
 func JoinRideWithPrivilege(c *gin.Context) {
+	db := DB.WithContext(c.Request.Context())
+
 	rideIDParam := c.Param("rideID")
 	rideID, err := strconv.Atoi(rideIDParam)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ride ID"})
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid ride ID")
 		return
 	}
 
@@ -311,63 +440,77 @@ func JoinRideWithPrivilege(c *gin.Context) {
 
 	// Check if user has approved privilege for this ride
 	var request Request
-	if err := DB.Where("ride_id = ? AND user_id = ? AND status = ?", rideID, userID, "approved").First(&request).Error; err != nil {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have privilege to join this ride"})
+	if err := db.Where("ride_id = ? AND user_id = ? AND status = ?", rideID, userID, "approved").First(&request).Error; err != nil {
+		abortWithError(c, http.StatusForbidden, CodeNoPrivilege, "You don't have privilege to join this ride")
 		return
 	}
 
 	var ride Ride
-	if err := DB.First(&ride, "id = ?", rideID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Ride not found"})
+	if err := db.First(&ride, "id = ?", rideID).Error; err != nil {
+		abortWithError(c, http.StatusNotFound, CodeRideNotFound, "Ride not found")
 		return
 	}
 
 	if ride.SeatsFilled >= ride.Seats {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Ride is full - no seats available"})
+		abortWithError(c, http.StatusBadRequest, CodeRideFull, "Ride is full - no seats available")
 		return
 	}
 
 	var existingParticipant Participant
-	if err := DB.Where("ride_id = ? AND user_id = ?", rideID, userID).First(&existingParticipant).Error; err == nil {
-		c.JSON(http.StatusConflict, gin.H{"error": "You are already a participant in this ride"})
-		return
-	}
-
-	if err := DB.Where("user_id = ? AND status = ?", userID, "approved").Delete(&Request{}).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear other privileges"})
+	if err := db.Where("ride_id = ? AND user_id = ?", rideID, userID).First(&existingParticipant).Error; err == nil {
+		abortWithError(c, http.StatusConflict, CodeAlreadyParticipant, "You are already a participant in this ride")
 		return
 	}
 
-	// Create participant record
-	participant := Participant{
-		RideID:   uint(rideID),
-		UserID:   userID,
-		JoinedAt: time.Now(),
-	}
+	// Use up the privileges, take the seat and add the participant together. The seat is only
+	// taken if one is still free, so two passengers joining at once can't overbook the last one.
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND status = ?", userID, "approved").Delete(&Request{}).Error; err != nil {
+			return err
+		}
 
-	if err := DB.Create(&participant).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to join ride"})
-		return
-	}
+		result := tx.Model(&Ride{}).Where("id = ? AND seats_filled < seats", rideID).
+			UpdateColumn("seats_filled", gorm.Expr("seats_filled + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return &APIError{Status: http.StatusBadRequest, Code: CodeRideFull, Message: "Ride is full - no seats available"}
+		}
 
-	// Increase seats filled count
-	if err := DB.Model(&ride).Update("seats_filled", ride.SeatsFilled+1).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update ride seats"})
+		participant := Participant{
+			RideID:   uint(rideID),
+			UserID:   userID,
+			JoinedAt: time.Now(),
+		}
+		return tx.Create(&participant).Error
+	})
+	if err != nil {
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) {
+			apiErr = &APIError{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "Failed to join ride"}
+		}
+		abortWithAPIError(c, apiErr)
 		return
 	}
+	rideJoinsTotal.Inc()
+	invalidateRideSearch(ride)
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Successfully joined the ride! All other privileges have been cleared.",
-		"ride_id": rideID,
+	c.JSON(http.StatusOK, JoinRideResponse{
+		Message: "Successfully joined the ride! All other privileges have been cleared.",
+		RideID:  rideID,
 	})
 }
 
 // DELETE /user/cancel-ride/:rideID - Unified function to cancel either pending request or participation
 func CancelRideParticipation(c *gin.Context) {
+	ctx := c.Request.Context()
+	db := DB.WithContext(ctx)
+
 	rideIDParam := c.Param("rideID")
 	rideID, err := strconv.Atoi(rideIDParam)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ride ID"})
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid ride ID")
 		return
 	}
 
@@ -375,56 +518,66 @@ func CancelRideParticipation(c *gin.Context) {
 
 	// First check if user has a pending request
 	var pendingRequest Request
-	if err := DB.Where("ride_id = ? AND user_id = ? AND status = ?", rideID, userID, "pending").First(&pendingRequest).Error; err == nil {
+	if err := db.Where("ride_id = ? AND user_id = ? AND status = ?", rideID, userID, "pending").First(&pendingRequest).Error; err == nil {
 		// User has a pending request - cancel it (no notification needed)
-		if err := DB.Delete(&pendingRequest).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel request"})
+		if err := db.Delete(&pendingRequest).Error; err != nil {
+			abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to cancel request")
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{
-			"message": "Join request cancelled successfully",
-			"type":    "request_cancelled",
+		joinRequestsTotal.WithLabelValues("cancelled").Inc()
+		c.JSON(http.StatusOK, CancelParticipationResponse{
+			Message: "Join request cancelled successfully",
+			Type:    "request_cancelled",
 		})
 		return
 	}
 
 	// Check if user is actually a participant
 	var participant Participant
-	if err := DB.Where("ride_id = ? AND user_id = ?", rideID, userID).First(&participant).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "You have no involvement with this ride"})
+	if err := db.Where("ride_id = ? AND user_id = ?", rideID, userID).First(&participant).Error; err != nil {
+		abortWithError(c, http.StatusNotFound, CodeNoInvolvement, "You have no involvement with this ride")
 		return
 	}
 
 	// User is a participant - proceed with cancellation and notify leader
 	var ride Ride
-	if err := DB.First(&ride, "id = ?", rideID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Ride not found"})
+	if err := db.First(&ride, "id = ?", rideID).Error; err != nil {
+		abortWithError(c, http.StatusNotFound, CodeRideNotFound, "Ride not found")
 		return
 	}
 
 	// Get the cancelling user's details
-	cancellingUser, err := getUser(userID)
+	cancellingUser, err := getUser(ctx, userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		abortWithError(c, http.StatusNotFound, CodeUserNotFound, "User not found")
 		return
 	}
 
 	// Get the ride leader's details
-	leader, err := getUser(ride.LeaderID)
+	leader, err := getUser(ctx, ride.LeaderID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Ride leader not found"})
+		abortWithError(c, http.StatusNotFound, CodeLeaderNotFound, "Ride leader not found")
+		return
+	}
+
+	// Leave the ride and notify the leader in one transaction
+	tx := db.Begin()
+	if tx.Error != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to start transaction")
 		return
 	}
 
 	// Remove participant from ride
-	if err := DB.Delete(&participant).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel ride participation"})
+	if err := tx.Delete(&participant).Error; err != nil {
+		tx.Rollback()
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to cancel ride participation")
 		return
 	}
 
 	// Update seats filled count
-	if err := DB.Model(&ride).Update("seats_filled", ride.SeatsFilled-1).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update ride seats"})
+	if err := tx.Model(&ride).Update("seats_filled", ride.SeatsFilled-1).Error; err != nil {
+		tx.Rollback()
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to update ride seats")
 		return
 	}
 
@@ -432,13 +585,22 @@ func CancelRideParticipation(c *gin.Context) {
 	title := "Participant Cancelled"
 	message := fmt.Sprintf("%s has cancelled their participation in your ride from %s to %s on %s at %s",
 		cancellingUser.Name, ride.Origin, ride.Destination, ride.Date, ride.Time)
-	if err := createNotification(leader.FirebaseUID, title, message, "participant_cancelled", uint(rideID)); err != nil {
-		// Log error but don't fail the request
-		fmt.Printf("Failed to create notification: %v\n", err)
+	if err := createNotification(tx, leader.FirebaseUID, title, message, "participant_cancelled", ride); err != nil {
+		tx.Rollback()
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to create notification")
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to commit transaction")
+		return
 	}
+	wakeNotificationOutbox()
+	cancellationsTotal.WithLabelValues("participation").Inc()
+	invalidateRideSearch(ride)
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Successfully cancelled your participation in the ride",
-		"type":    "participation_cancelled",
+	c.JSON(http.StatusOK, CancelParticipationResponse{
+		Message: "Successfully cancelled your participation in the ride",
+		Type:    "participation_cancelled",
 	})
 }
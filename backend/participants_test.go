@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func joinRide(t *testing.T, user *User, ride Ride, want int) APIError {
+	t.Helper()
+
+	var body APIError
+	path := fmt.Sprintf("/ride/%d/join-ride", ride.ID)
+	decodeJSON(t, serve(t, JoinRideWithPrivilege, "POST", "/ride/:rideID/join-ride", path, user.FirebaseUID, nil), want, &body)
+	return body
+}
+
+func TestJoinRideWithPrivilege(t *testing.T) {
+	db := newTestDB(t)
+	leader, rider := seedUser(t, db), seedUser(t, db)
+	ride := seedRide(t, db, leader, "Campus", "Airport", daysFromNow(1), "09:00", 2)
+	other := seedRide(t, db, leader, "Campus", "Station", daysFromNow(1), "10:00", 2)
+	seedRequest(t, db, rider, ride, "approved")
+	seedRequest(t, db, rider, other, "approved")
+
+	joinRide(t, rider, ride, http.StatusOK)
+
+	var got Ride
+	db.First(&got, ride.ID)
+	var participants, privileges int64
+	db.Model(&Participant{}).Where("ride_id = ? AND user_id = ?", ride.ID, rider.FirebaseUID).Count(&participants)
+	db.Model(&Request{}).Where("user_id = ? AND status = ?", rider.FirebaseUID, "approved").Count(&privileges)
+	if got.SeatsFilled != 1 || participants != 1 || privileges != 0 {
+		t.Fatalf("seats filled %d, %d participants and %d privileges left, want 1, 1 and 0", got.SeatsFilled, participants, privileges)
+	}
+}
+
+func TestJoinRideWithPrivilegeLastSeatRace(t *testing.T) {
+	db := newTestDB(t)
+	leader, rider := seedUser(t, db), seedUser(t, db)
+	ride := seedRide(t, db, leader, "Campus", "Airport", daysFromNow(1), "09:00", 1)
+	seedRequest(t, db, rider, ride, "approved")
+
+	// Another passenger takes the last seat after this one saw it free
+	raced := false
+	if err := db.Callback().Delete().Before("gorm:delete").Register("test_race", func(tx *gorm.DB) {
+		if tx.Statement.Table != "requests" || raced {
+			return
+		}
+		raced = true
+		tx.Session(&gorm.Session{NewDB: true}).Exec("UPDATE rides SET seats_filled = seats WHERE id = ?", ride.ID)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if body := joinRide(t, rider, ride, http.StatusBadRequest); body.Code != CodeRideFull {
+		t.Fatalf("code = %s, want %s", body.Code, CodeRideFull)
+	}
+	if !raced {
+		t.Fatal("the race never ran")
+	}
+
+	// Nothing was half done: the privilege is kept and no participant added
+	var participants, privileges int64
+	db.Model(&Participant{}).Where("ride_id = ?", ride.ID).Count(&participants)
+	db.Model(&Request{}).Where("user_id = ? AND status = ?", rider.FirebaseUID, "approved").Count(&privileges)
+	if participants != 0 || privileges != 1 {
+		t.Fatalf("%d participants and %d privileges, want 0 and 1", participants, privileges)
+	}
+}
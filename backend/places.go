@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Place is the canonical form of an origin or destination. Rides point at places, so the many
+// ways people type the same location ("College Campus", "college-campus ", "Colege Campus")
+// all find each other in search.
+type Place struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	Name       string    `gorm:"type:varchar(200);not null" json:"name"`          // As first entered, tidied up
+	Normalized string    `gorm:"type:varchar(200);uniqueIndex;not null" json:"-"` // See normalizePlaceName
+	RideCount  int       `gorm:"not null;default:0;index" json:"ride_count"`      // Rides posted from or to the place
+	CreatedAt  time.Time `json:"-"`
+	UpdatedAt  time.Time `json:"-"`
+}
+
+// PlaceAlias is another normalized spelling that resolves to a place. Aliases are confirmed by
+// hand (inserted by an operator), never learned from fuzzy matches, which can't tell "terminal 1"
+// from "terminal 2" reliably enough to remember.
+type PlaceAlias struct {
+	ID        uint   `gorm:"primaryKey"`
+	PlaceID   uint   `gorm:"not null;index"`
+	Alias     string `gorm:"type:varchar(200);uniqueIndex;not null"`
+	CreatedAt time.Time
+}
+
+// Spellings at least this similar (see trigramSimilarity) are taken to be the same place
+const placeSimilarityThreshold = 0.7
+
+const (
+	defaultAutocompleteLimit = 10
+	maxAutocompleteLimit     = 25
+)
+
+// normalizePlaceName lower-cases name and turns punctuation and runs of whitespace into single spaces
+func normalizePlaceName(name string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteRune(r)
+			space = false
+		} else {
+			space = true
+		}
+	}
+	return b.String()
+}
+
+// displayPlaceName tidies the whitespace of a name as entered, keeping its case and punctuation
+func displayPlaceName(name string) string {
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// trigrams splits a normalized name into the three-letter sequences of each word, padded the way
+// Postgres pg_trgm does, so "cab" gives "  c", " ca", "cab" and "ab ".
+func trigrams(s string) map[string]bool {
+	set := map[string]bool{}
+	for _, word := range strings.Fields(s) {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			set[string(padded[i:i+3])] = true
+		}
+	}
+	return set
+}
+
+// distinguishingTokens returns the words of a normalized name that trigrams barely weigh but that
+// tell places apart: numbers and codes like "1" or "t2", and single letters like the "a" in "block a".
+// They come back sorted and joined, for comparison.
+func distinguishingTokens(s string) string {
+	var tokens []string
+	for _, word := range strings.Fields(s) {
+		if len([]rune(word)) == 1 || strings.IndexFunc(word, unicode.IsDigit) >= 0 {
+			tokens = append(tokens, word)
+		}
+	}
+	sort.Strings(tokens)
+	return strings.Join(tokens, " ")
+}
+
+// trigramSimilarity is the share of trigrams two normalized names have in common, from 0 to 1
+func trigramSimilarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+	shared := 0
+	for t := range ta {
+		if tb[t] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(ta)+len(tb)-shared)
+}
+
+// placeNameSimilarity is trigramSimilarity for names with the same distinguishingTokens, and 0 for others
+func placeNameSimilarity(a, b string) float64 {
+	if distinguishingTokens(a) != distinguishingTokens(b) {
+		return 0
+	}
+	return trigramSimilarity(a, b)
+}
+
+// findPlace resolves a name to a place by exact spelling, a known alias, or failing those the most
+// similar place or alias above placeSimilarityThreshold whose numbers and single letters are the
+// same (see distinguishingTokens). It returns nil when nothing matches.
+func findPlace(db *gorm.DB, name string) (*Place, error) {
+	normalized := normalizePlaceName(name)
+	if normalized == "" {
+		return nil, nil
+	}
+
+	var place Place
+	err := db.Where("normalized = ?", normalized).First(&place).Error
+	if err == nil {
+		return &place, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	var alias PlaceAlias
+	err = db.Where("alias = ?", normalized).First(&alias).Error
+	if err == nil {
+		if err := db.First(&place, alias.PlaceID).Error; err != nil {
+			return nil, err
+		}
+		return &place, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	// Compared in Go rather than with pg_trgm so no database extension is needed.
+	// Places number in the hundreds, so reading all spellings is cheap.
+	var spellings []struct {
+		PlaceID  uint
+		Spelling string
+	}
+	if err := db.Raw(`SELECT id AS place_id, normalized AS spelling FROM places
+		UNION ALL SELECT place_id, alias AS spelling FROM place_aliases`).Scan(&spellings).Error; err != nil {
+		return nil, err
+	}
+
+	var bestID uint
+	best := placeSimilarityThreshold
+	for _, s := range spellings {
+		if sim := placeNameSimilarity(normalized, s.Spelling); sim >= best {
+			bestID, best = s.PlaceID, sim
+		}
+	}
+	if bestID == 0 {
+		return nil, nil
+	}
+
+	if err := db.First(&place, bestID).Error; err != nil {
+		return nil, err
+	}
+	return &place, nil
+}
+
+// Searches look places up by name before anything else, so lookups are cached, misses included:
+// a miss reads every place and alias to look for a fuzzy match. A new place can change what a name
+// resolves to, being the first match for a name with none or a closer one than its cached place,
+// so creating one starts a new generation of entries on this instance. Other instances, and
+// aliases added by hand, are seen once entries expire, which for misses is soon.
+var (
+	placeLookupCache = newMeteredCache[uint]("place", NewMemoryCache[uint](0))
+	placeLookupTTL   time.Duration
+	placeMissTTL     time.Duration
+	placeGeneration  atomic.Uint64
+)
+
+// findPlaceID is findPlace for searches, returning 0 when no place matches
+func findPlaceID(ctx context.Context, name string) (uint, error) {
+	// A lookup that overlaps a new place is stored under the old generation, where nothing reads it
+	key := strconv.FormatUint(placeGeneration.Load(), 10) + "|" + normalizePlaceName(name)
+	if id, ok := placeLookupCache.Get(key); ok {
+		return id, nil
+	}
+
+	place, err := findPlace(DB.WithContext(ctx), name)
+	if err != nil {
+		return 0, err
+	}
+	if place == nil {
+		placeLookupCache.Set(key, 0, placeMissTTL)
+		return 0, nil
+	}
+	placeLookupCache.Set(key, place.ID, placeLookupTTL)
+	return place.ID, nil
+}
+
+// resolvePlace finds the place for a name entered in a new ride, creating it if it is new.
+// Only rides create places, so autocomplete offers nowhere nobody has posted a ride from or to.
+// A fuzzy match files the ride under the matched place but isn't remembered as an alias, so each
+// spelling is judged on its own and a wrong match never sticks.
+func resolvePlace(db *gorm.DB, name string) (*Place, error) {
+	normalized := normalizePlaceName(name)
+	if normalized == "" {
+		return nil, errors.New("place name is empty")
+	}
+
+	place, err := findPlace(db, name)
+	if err != nil {
+		return nil, err
+	}
+	if place != nil {
+		return place, nil
+	}
+
+	// Another request may create the same place at the same time; the unique index settles it
+	newPlace := Place{Name: displayPlaceName(name), Normalized: normalized}
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&newPlace)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		if err := db.Where("normalized = ?", normalized).First(&newPlace).Error; err != nil {
+			return nil, err
+		}
+		return &newPlace, nil
+	}
+	placeGeneration.Add(1)
+
+	if err := attachWaitingSavedSearches(db, newPlace); err != nil {
+		return nil, err
+	}
+	if err := attachWaitingTripRequests(db, newPlace); err != nil {
+		return nil, err
+	}
+	return &newPlace, nil
+}
+
+// attachWaitingRows sets place as the origin or destination place of the rows in scope still
+// waiting for a place whose normalized names resolve to it. No earlier place matched those names,
+// so only the new one needs checking. scope must select the model with its own conditions.
+func attachWaitingRows(scope *gorm.DB, place Place) error {
+	for _, end := range []string{"origin", "destination"} {
+		var names []string
+		if err := scope.Session(&gorm.Session{}).Where(end+"_place_id IS NULL").
+			Distinct().Pluck(end+"_normalized", &names).Error; err != nil {
+			return err
+		}
+
+		var matching []string
+		for _, name := range names {
+			if placeNameMatches(place, name) {
+				matching = append(matching, name)
+			}
+		}
+		if len(matching) == 0 {
+			continue
+		}
+		if err := scope.Session(&gorm.Session{}).Where(end+"_place_id IS NULL AND "+end+"_normalized IN ?", matching).
+			Update(end+"_place_id", place.ID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// placeNameMatches reports whether a normalized name that matched no place before resolves to
+// place, which has just been created and so has no aliases
+func placeNameMatches(place Place, normalized string) bool {
+	return normalized == place.Normalized || placeNameSimilarity(normalized, place.Normalized) >= placeSimilarityThreshold
+}
+
+// assignRidePlaces resolves a new ride's origin and destination and counts the ride towards their popularity
+func assignRidePlaces(db *gorm.DB, ride *Ride) error {
+	origin, err := resolvePlace(db, ride.Origin)
+	if err != nil {
+		return err
+	}
+	destination, err := resolvePlace(db, ride.Destination)
+	if err != nil {
+		return err
+	}
+
+	ride.OriginPlaceID = &origin.ID
+	ride.DestinationPlaceID = &destination.ID
+	return db.Model(&Place{}).Where("id IN ?", []uint{origin.ID, destination.ID}).
+		UpdateColumn("ride_count", gorm.Expr("ride_count + 1")).Error
+}
+
+// backfillRidePlaces assigns places to rides posted before places existed
+func backfillRidePlaces(ctx context.Context) error {
+	db := DB.WithContext(ctx)
+
+	var rides []Ride
+	if err := db.Where("origin_place_id IS NULL OR destination_place_id IS NULL").Find(&rides).Error; err != nil {
+		return err
+	}
+
+	for _, ride := range rides {
+		if err := assignRidePlaces(db, &ride); err != nil {
+			slog.Warn("⚠️  Failed to assign places to ride", "ride_id", ride.ID, "error", err)
+			continue
+		}
+		if err := db.Model(&ride).UpdateColumns(map[string]interface{}{
+			"origin_place_id":      ride.OriginPlaceID,
+			"destination_place_id": ride.DestinationPlaceID,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	if len(rides) > 0 {
+		slog.Info("✅ Assigned places to existing rides", "rides", len(rides))
+	}
+	return nil
+}
+
+// GET /places/autocomplete?q=coll&limit=10 - Canonical places whose name or alias starts with q, most popular first
+func AutocompletePlaces(c *gin.Context) {
+	db := DB.WithContext(c.Request.Context())
+
+	q := normalizePlaceName(c.Query("q"))
+	if q == "" {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "q is required")
+		return
+	}
+
+	limit := defaultAutocompleteLimit
+	if limitParam := c.Query("limit"); limitParam != "" {
+		n, err := strconv.Atoi(limitParam)
+		if err != nil || n < 1 {
+			abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "limit must be a positive integer")
+			return
+		}
+		limit = min(n, maxAutocompleteLimit)
+	}
+
+	// Match the start of the name or of any word in it. q is normalized, so it holds no LIKE wildcards.
+	prefix, wordPrefix := q+"%", "% "+q+"%"
+	places := []Place{}
+	if err := db.Where("normalized LIKE ? OR normalized LIKE ?", prefix, wordPrefix).
+		Or("id IN (?)", db.Model(&PlaceAlias{}).Select("place_id").Where("alias LIKE ? OR alias LIKE ?", prefix, wordPrefix)).
+		Order("ride_count DESC, name").Limit(limit).
+		Find(&places).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to search places")
+		return
+	}
+
+	c.JSON(http.StatusOK, places)
+}
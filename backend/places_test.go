@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func TestNormalizePlaceName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"College Campus", "college campus"},
+		{"  college-campus ", "college campus"},
+		{"City Airport, Terminal 1", "city airport terminal 1"},
+		{"Hostel Block-A", "hostel block a"},
+		{"MG Road / Metro", "mg road metro"},
+		{"Café   Nero", "café nero"},
+		{"--", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizePlaceName(tt.name); got != tt.want {
+			t.Errorf("normalizePlaceName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTrigramSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"college campus", "college campus", 1},
+		{"college campus", "colege campus", 0.8},
+		// Different places that trigrams alone would merge; findPlace tells them apart by their numbers and letters
+		{"city airport terminal 1", "city airport terminal 2", 0.846},
+		{"hostel block a", "hostel block b", 0.812},
+		{"college campus", "railway station", 0},
+		{"", "campus", 0},
+	}
+	for _, tt := range tests {
+		if got := trigramSimilarity(tt.a, tt.b); math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("trigramSimilarity(%q, %q) = %.3f, want %.3f", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestFindPlace(t *testing.T) {
+	db := newTestDB(t)
+	places := map[string]uint{}
+	for _, name := range []string{"College Campus", "City Airport Terminal 1", "Hostel Block A", "Sector 21 Market"} {
+		place, err := resolvePlace(db, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		places[name] = place.ID
+	}
+	if err := db.Create(&PlaceAlias{PlaceID: places["College Campus"], Alias: "iit campus"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want string // Empty for no match
+	}{
+		{"college campus", "College Campus"},
+		{"College-Campus ", "College Campus"},
+		{"IIT Campus", "College Campus"}, // Alias
+		{"Colege Campus", "College Campus"},
+		{"city airport terminal 1", "City Airport Terminal 1"},
+		{"City Airport Terminl 1", "City Airport Terminal 1"},
+		{"City Airport Terminal 2", ""},
+		{"City Airport Terminal", ""},
+		{"Hostel Block B", ""},
+		{"Hostel Blok A", "Hostel Block A"},
+		{"Sector 12 Market", ""},
+		{"Railway Station", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		place, err := findPlace(db, tt.name)
+		if err != nil {
+			t.Fatalf("findPlace(%q): %v", tt.name, err)
+		}
+		switch {
+		case tt.want == "" && place != nil:
+			t.Errorf("findPlace(%q) = %q, want no match", tt.name, place.Name)
+		case tt.want != "" && (place == nil || place.ID != places[tt.want]):
+			t.Errorf("findPlace(%q) = %v, want %q", tt.name, place, tt.want)
+		}
+	}
+}
+
+func TestResolvePlaceNeverLearnsFuzzyAliases(t *testing.T) {
+	db := newTestDB(t)
+	campus, err := resolvePlace(db, "College Campus")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A misspelling is filed under the place it resembles, without becoming an alias of it
+	misspelt, err := resolvePlace(db, "Colege Campus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if misspelt.ID != campus.ID {
+		t.Fatalf("Colege Campus resolved to %q, want College Campus", misspelt.Name)
+	}
+
+	// A near miss that is really another place gets its own
+	t1, err := resolvePlace(db, "City Airport Terminal 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t2, err := resolvePlace(db, "City Airport Terminal 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if t1.ID == t2.ID {
+		t.Fatal("terminal 2 was filed under terminal 1")
+	}
+
+	var aliases int64
+	db.Model(&PlaceAlias{}).Count(&aliases)
+	if aliases != 0 {
+		t.Fatalf("resolving learned %d aliases, want none", aliases)
+	}
+}
+
+func TestFindPlaceIDCachesMissesUntilPlaceCreated(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	campus, err := resolvePlace(db, "College Campus")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Found names and misses are both answered from the cache the second time
+	for _, name := range []string{"College Campus", "Colege Campus", "Airport Terminal"} {
+		findPlaceID(ctx, name)
+	}
+	counter := countStatements(t, db)
+	if id, _ := findPlaceID(ctx, "Colege Campus"); id != campus.ID {
+		t.Fatalf("fuzzy lookup = %d, want %d", id, campus.ID)
+	}
+	if id, _ := findPlaceID(ctx, "Airport Terminal"); id != 0 {
+		t.Fatalf("lookup of an unknown place = %d, want 0", id)
+	}
+	if n := counter.Count(); n != 0 {
+		t.Fatalf("cached lookups ran %d statements, want 0", n)
+	}
+
+	// A ride to the new place creates it, and searches find it straight away
+	airport, err := resolvePlace(db, "Airport Terminal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := findPlaceID(ctx, "airport terminal"); err != nil || id != airport.ID {
+		t.Fatalf("lookup after the place was created = %d (%v), want %d", id, err, airport.ID)
+	}
+	if id, _ := findPlaceID(ctx, "College Campus"); id != campus.ID {
+		t.Fatalf("lookup after another place was created = %d, want %d", id, campus.ID)
+	}
+}
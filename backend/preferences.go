@@ -0,0 +1,495 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Notification types users can configure
+var notificationTypes = []string{
+	"join_request",
+	"request_approved",
+	"ride_cancelled",
+	"participant_removed",
+	"participant_cancelled",
+	"ride_reminder",
+	"saved_search_match",
+	"trip_offer",
+}
+
+// Urgent notifications bypass quiet hours
+var urgentNotificationTypes = map[string]bool{
+	"ride_cancelled":      true,
+	"participant_removed": true,
+	"ride_reminder":       true, // Held until morning it would arrive after the ride left
+}
+
+// NotificationPreference controls whether and where a user receives one notification type
+type NotificationPreference struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	UserID    string    `gorm:"uniqueIndex:idx_notification_pref_user_type;not null" json:"-"` // Firebase UID
+	Type      string    `gorm:"uniqueIndex:idx_notification_pref_user_type;type:varchar(50);not null" json:"type"`
+	Enabled   bool      `gorm:"not null" json:"enabled"`
+	Channels  string    `gorm:"type:varchar(200);not null" json:"-"` // Comma-separated channel names, e.g. "in_app,email"
+	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"-"`
+}
+
+// NotificationSettings holds per-user settings that apply across notification types
+type NotificationSettings struct {
+	ID              uint      `gorm:"primaryKey" json:"-"`
+	UserID          string    `gorm:"uniqueIndex;not null" json:"-"` // Firebase UID
+	QuietHoursStart string    `gorm:"type:varchar(5)" json:"start"`  // "22:00", empty disables quiet hours
+	QuietHoursEnd   string    `gorm:"type:varchar(5)" json:"end"`    // "07:00"
+	Timezone        string    `gorm:"type:varchar(64)" json:"timezone"`
+	CreatedAt       time.Time `json:"-"`
+	UpdatedAt       time.Time `json:"-"`
+}
+
+// DigestEntry is a non-urgent notification held back during quiet hours
+type DigestEntry struct {
+	ID       uint   `gorm:"primaryKey"`
+	UserID   string `gorm:"index;not null"` // Firebase UID
+	Channel  string `gorm:"type:varchar(50);not null;uniqueIndex:idx_digest_entries_outbox_channel"`
+	Type     string `gorm:"type:varchar(50);not null"`
+	Title    string `gorm:"type:varchar(200);not null"`
+	Message  string `gorm:"type:text;not null"`
+	RideID   uint   `gorm:"not null"`
+	OutboxID *uint  `gorm:"uniqueIndex:idx_digest_entries_outbox_channel"` // Outbox event of the held notification
+
+	// Snapshot of the ride, as on Notification
+	Origin      string
+	Destination string
+	Date        string
+	Time        string
+
+	CreatedAt time.Time
+}
+
+// notification is the held notification, as it would have been sent
+func (e DigestEntry) notification() Notification {
+	return Notification{
+		UserID:      e.UserID,
+		Title:       e.Title,
+		Message:     e.Message,
+		Type:        e.Type,
+		RideID:      e.RideID,
+		OutboxID:    e.OutboxID,
+		Origin:      e.Origin,
+		Destination: e.Destination,
+		Date:        e.Date,
+		Time:        e.Time,
+		CreatedAt:   e.CreatedAt,
+		UpdatedAt:   e.CreatedAt,
+	}
+}
+
+// NotificationPreferenceEntry is one notification type's preference in API requests and responses
+type NotificationPreferenceEntry struct {
+	Type     string   `json:"type"`
+	Enabled  bool     `json:"enabled"`
+	Channels []string `json:"channels"`
+}
+
+// NotificationPreferencesResponse is the full preference set for the user
+type NotificationPreferencesResponse struct {
+	Preferences       []NotificationPreferenceEntry `json:"preferences"`
+	QuietHours        NotificationSettings          `json:"quiet_hours"`
+	AvailableChannels []string                      `json:"available_channels"`
+}
+
+// Request body struct for updating notification preferences
+type UpdateNotificationPreferencesRequest struct {
+	Preferences []NotificationPreferenceEntry `json:"preferences"`
+	QuietHours  *NotificationSettings         `json:"quiet_hours"`
+}
+
+func isUrgentNotification(notificationType string) bool {
+	return urgentNotificationTypes[notificationType]
+}
+
+func isKnownNotificationType(notificationType string) bool {
+	for _, t := range notificationTypes {
+		if t == notificationType {
+			return true
+		}
+	}
+	return false
+}
+
+// channelList splits the stored channel names
+func (p NotificationPreference) channelList() []string {
+	var channels []string
+	for _, name := range strings.Split(p.Channels, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			channels = append(channels, name)
+		}
+	}
+	return channels
+}
+
+// defaultNotificationPreference is used for types the user never configured: everything goes
+// to the in-app feed and to push when available, and urgent types also go out by email
+func defaultNotificationPreference(userID, notificationType string) NotificationPreference {
+	channels := "in_app"
+	if _, ok := notificationChannels["push"]; ok {
+		channels += ",push"
+	}
+	if _, ok := notificationChannels["email"]; ok && isUrgentNotification(notificationType) {
+		channels += ",email"
+	}
+
+	return NotificationPreference{
+		UserID:   userID,
+		Type:     notificationType,
+		Enabled:  true,
+		Channels: channels,
+	}
+}
+
+// loadNotificationPreference returns the user's preference for a type and their quiet hours, falling back to defaults
+func loadNotificationPreference(ctx context.Context, userID, notificationType string) (NotificationPreference, NotificationSettings, error) {
+	pref := defaultNotificationPreference(userID, notificationType)
+	settings := NotificationSettings{UserID: userID}
+
+	if err := DB.WithContext(ctx).Where("user_id = ? AND type = ?", userID, notificationType).First(&pref).Error; err != nil &&
+		!errors.Is(err, gorm.ErrRecordNotFound) {
+		return pref, settings, err
+	}
+	if err := DB.WithContext(ctx).Where("user_id = ?", userID).First(&settings).Error; err != nil &&
+		!errors.Is(err, gorm.ErrRecordNotFound) {
+		return pref, settings, err
+	}
+
+	return pref, settings, nil
+}
+
+// validate checks the quiet hours window and timezone
+func (s NotificationSettings) validate() error {
+	if s.QuietHoursStart == "" && s.QuietHoursEnd == "" {
+		return nil
+	}
+	if _, err := time.Parse("15:04", s.QuietHoursStart); err != nil {
+		return fmt.Errorf("invalid quiet hours start, expected HH:mm")
+	}
+	if _, err := time.Parse("15:04", s.QuietHoursEnd); err != nil {
+		return fmt.Errorf("invalid quiet hours end, expected HH:mm")
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return fmt.Errorf("invalid timezone %q", s.Timezone)
+	}
+	return nil
+}
+
+// inQuietHours reports whether now falls inside the user's quiet hours window (which may wrap midnight)
+func (s NotificationSettings) inQuietHours(now time.Time) bool {
+	if s.QuietHoursStart == "" || s.QuietHoursStart == s.QuietHoursEnd {
+		return false
+	}
+
+	start, err := time.Parse("15:04", s.QuietHoursStart)
+	if err != nil {
+		return false
+	}
+	end, err := time.Parse("15:04", s.QuietHoursEnd)
+	if err != nil {
+		return false
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	startMinute := start.Hour()*60 + start.Minute()
+	endMinute := end.Hour()*60 + end.Minute()
+
+	if startMinute < endMinute {
+		return minute >= startMinute && minute < endMinute
+	}
+	return minute >= startMinute || minute < endMinute
+}
+
+// queueDigestEntries holds a notification for each channel until the user's quiet hours end.
+// An outbox event is held at most once per channel, however often it is dispatched.
+func queueDigestEntries(ctx context.Context, n Notification, channels []string) error {
+	if len(channels) == 0 {
+		return nil
+	}
+
+	entries := make([]DigestEntry, 0, len(channels))
+	for _, channel := range channels {
+		entries = append(entries, DigestEntry{
+			UserID:      n.UserID,
+			Channel:     channel,
+			Type:        n.Type,
+			Title:       n.Title,
+			Message:     n.Message,
+			RideID:      n.RideID,
+			OutboxID:    n.OutboxID,
+			Origin:      n.Origin,
+			Destination: n.Destination,
+			Date:        n.Date,
+			Time:        n.Time,
+		})
+	}
+	return DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&entries).Error
+}
+
+// flushDigests sends one digest per user and channel for users whose quiet hours are over
+func flushDigests(ctx context.Context, now time.Time) error {
+	db := DB.WithContext(ctx)
+
+	var userIDs []string
+	if err := db.Model(&DigestEntry{}).Distinct().Pluck("user_id", &userIDs).Error; err != nil {
+		return err
+	}
+
+	for _, userID := range userIDs {
+		var settings NotificationSettings
+		if err := db.Where("user_id = ?", userID).First(&settings).Error; err != nil &&
+			!errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if settings.inQuietHours(now) {
+			continue
+		}
+
+		var entries []DigestEntry
+		if err := db.Where("user_id = ?", userID).Order("id").Find(&entries).Error; err != nil {
+			return err
+		}
+
+		byChannel := make(map[string][]DigestEntry)
+		for _, e := range entries {
+			byChannel[e.Channel] = append(byChannel[e.Channel], e)
+		}
+
+		for channelName, channelEntries := range byChannel {
+			ids := make([]uint, 0, len(channelEntries))
+			for _, e := range channelEntries {
+				ids = append(ids, e.ID)
+			}
+
+			if ch, ok := notificationChannels[channelName]; ok {
+				if err := sendDigest(ctx, ch, userID, channelEntries); err != nil {
+					slog.Error("❌ Failed to send digest", "channel", channelName, "uid", userID, "error", err)
+					continue // keep the entries for the next run
+				}
+			}
+
+			if err := db.Where("id IN ?", ids).Delete(&DigestEntry{}).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// sendDigest delivers held entries on ch. The in-app feed gets each notification as it would have
+// been sent, ride and all; being unique per outbox event, they are never added twice. Other
+// channels get a single summary.
+func sendDigest(ctx context.Context, ch NotificationChannel, userID string, entries []DigestEntry) error {
+	if ch.Name() != "in_app" {
+		digest := buildDigestNotification(userID, entries)
+		return sendOnChannel(ctx, ch, &digest)
+	}
+
+	for _, e := range entries {
+		n := e.notification()
+		if err := sendOnChannel(ctx, ch, &n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildDigestNotification summarizes held entries into a single notification. A digest about a
+// single ride links to it like the notifications it replaces.
+func buildDigestNotification(userID string, entries []DigestEntry) Notification {
+	var lines []string
+	sameRide := true
+	for _, e := range entries {
+		line := fmt.Sprintf("• %s: %s", e.Title, e.Message)
+		if e.Origin != "" {
+			line += fmt.Sprintf(" (%s to %s, %s %s)", e.Origin, e.Destination, e.Date, e.Time)
+		}
+		lines = append(lines, line)
+		sameRide = sameRide && e.RideID == entries[0].RideID
+	}
+
+	digest := Notification{
+		UserID:    userID,
+		Title:     fmt.Sprintf("While you were away: %d updates", len(entries)),
+		Message:   strings.Join(lines, "\n"),
+		Type:      "digest",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if sameRide {
+		first := entries[0]
+		digest.RideID, digest.Origin, digest.Destination, digest.Date, digest.Time =
+			first.RideID, first.Origin, first.Destination, first.Date, first.Time
+	}
+	return digest
+}
+
+// StartDigestWorker periodically delivers digests for users whose quiet hours have ended, until shutdown
+func StartDigestWorker(interval time.Duration) {
+	runWorker(func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			// Let a run in progress finish even if shutdown starts
+			if err := flushDigests(context.WithoutCancel(ctx), time.Now()); err != nil {
+				slog.Error("❌ Failed to flush notification digests", "error", err)
+			}
+		}
+	})
+}
+
+// GET /user/notification-preferences - Get the user's notification preferences and quiet hours
+func GetNotificationPreferences(c *gin.Context) {
+	db := DB.WithContext(c.Request.Context())
+
+	userID := c.MustGet("uid").(string)
+
+	var stored []NotificationPreference
+	if err := db.Where("user_id = ?", userID).Find(&stored).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to fetch notification preferences")
+		return
+	}
+
+	byType := make(map[string]NotificationPreference, len(stored))
+	for _, p := range stored {
+		byType[p.Type] = p
+	}
+
+	response := NotificationPreferencesResponse{AvailableChannels: availableChannelNames()}
+	for _, t := range notificationTypes {
+		pref, ok := byType[t]
+		if !ok {
+			pref = defaultNotificationPreference(userID, t)
+		}
+		response.Preferences = append(response.Preferences, NotificationPreferenceEntry{
+			Type:     t,
+			Enabled:  pref.Enabled,
+			Channels: pref.channelList(),
+		})
+	}
+
+	if err := db.Where("user_id = ?", userID).First(&response.QuietHours).Error; err != nil &&
+		!errors.Is(err, gorm.ErrRecordNotFound) {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to fetch quiet hours")
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// PUT /user/notification-preferences - Update notification preferences and/or quiet hours
+func UpdateNotificationPreferences(c *gin.Context) {
+	db := DB.WithContext(c.Request.Context())
+
+	userID := c.MustGet("uid").(string)
+
+	var req UpdateNotificationPreferencesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request data")
+		return
+	}
+
+	prefs := make([]NotificationPreference, 0, len(req.Preferences))
+	seenTypes := make(map[string]bool, len(req.Preferences))
+	for _, entry := range req.Preferences {
+		if !isKnownNotificationType(entry.Type) {
+			abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Unknown notification type: "+entry.Type)
+			return
+		}
+		if seenTypes[entry.Type] {
+			abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Notification type listed twice: "+entry.Type)
+			return
+		}
+		seenTypes[entry.Type] = true
+
+		seenChannels := make(map[string]bool, len(entry.Channels))
+		for _, name := range entry.Channels {
+			if _, ok := notificationChannels[name]; !ok {
+				abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Unknown notification channel: "+name)
+				return
+			}
+			if seenChannels[name] {
+				abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Notification channel listed twice for "+entry.Type+": "+name)
+				return
+			}
+			seenChannels[name] = true
+		}
+		prefs = append(prefs, NotificationPreference{
+			UserID:   userID,
+			Type:     entry.Type,
+			Enabled:  entry.Enabled,
+			Channels: strings.Join(entry.Channels, ","),
+		})
+	}
+
+	if req.QuietHours != nil {
+		if req.QuietHours.Timezone == "" {
+			req.QuietHours.Timezone = "UTC"
+		}
+		if err := req.QuietHours.validate(); err != nil {
+			abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if len(prefs) > 0 {
+			if err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "user_id"}, {Name: "type"}},
+				DoUpdates: clause.AssignmentColumns([]string{"enabled", "channels", "updated_at"}),
+			}).Create(&prefs).Error; err != nil {
+				return err
+			}
+		}
+
+		if req.QuietHours != nil {
+			settings := NotificationSettings{
+				UserID:          userID,
+				QuietHoursStart: req.QuietHours.QuietHoursStart,
+				QuietHoursEnd:   req.QuietHours.QuietHoursEnd,
+				Timezone:        req.QuietHours.Timezone,
+			}
+			if err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "user_id"}},
+				DoUpdates: clause.AssignmentColumns([]string{"quiet_hours_start", "quiet_hours_end", "timezone", "updated_at"}),
+			}).Create(&settings).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to update notification preferences")
+		return
+	}
+
+	GetNotificationPreferences(c)
+}
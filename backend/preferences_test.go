@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingChannel is a notification channel that keeps what it is sent. Sends fail with err, or
+// with what onSend returns when set.
+type recordingChannel struct {
+	name   string
+	mu     sync.Mutex
+	sent   []Notification
+	err    error
+	onSend func() error
+}
+
+func (r *recordingChannel) Name() string { return r.name }
+
+func (r *recordingChannel) Send(ctx context.Context, n *Notification) error {
+	if r.onSend != nil {
+		if err := r.onSend(); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.sent = append(r.sent, *n)
+	return nil
+}
+
+// registerTestChannel registers a recording channel until the test ends
+func registerTestChannel(t *testing.T, name string) *recordingChannel {
+	t.Helper()
+
+	ch := &recordingChannel{name: name}
+	prev, had := notificationChannels[name]
+	notificationChannels[name] = ch
+	t.Cleanup(func() {
+		if had {
+			notificationChannels[name] = prev
+		} else {
+			delete(notificationChannels, name)
+		}
+	})
+	return ch
+}
+
+func TestUpdateNotificationPreferencesValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		prefs []NotificationPreferenceEntry
+		want  int
+	}{
+		{"valid", []NotificationPreferenceEntry{{Type: "join_request", Enabled: true, Channels: []string{"in_app"}}}, http.StatusOK},
+		{"duplicate channel", []NotificationPreferenceEntry{{Type: "join_request", Enabled: true, Channels: []string{"in_app", "in_app"}}}, http.StatusBadRequest},
+		{"duplicate type", []NotificationPreferenceEntry{
+			{Type: "join_request", Enabled: true, Channels: []string{"in_app"}},
+			{Type: "join_request", Enabled: false, Channels: []string{}},
+		}, http.StatusBadRequest},
+		{"unknown channel", []NotificationPreferenceEntry{{Type: "join_request", Enabled: true, Channels: []string{"sms"}}}, http.StatusBadRequest},
+		{"unsupported type", []NotificationPreferenceEntry{{Type: "ride_started", Enabled: true, Channels: []string{"in_app"}}}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			user := seedUser(t, db)
+
+			body := UpdateNotificationPreferencesRequest{Preferences: tt.prefs}
+			w := serve(t, UpdateNotificationPreferences, "PUT", "/user/notification-preferences", "/user/notification-preferences", user.FirebaseUID, body)
+			decodeJSON(t, w, tt.want, nil)
+		})
+	}
+}
+
+func TestDigestKeepsRideDetails(t *testing.T) {
+	db := newTestDB(t)
+	summary := registerTestChannel(t, "test_summary")
+	user := seedUser(t, db)
+
+	// Quiet hours around now, so everything non-urgent is held
+	now := time.Now().UTC()
+	quiet := NotificationSettings{
+		UserID: user.FirebaseUID, Timezone: "UTC",
+		QuietHoursStart: now.Add(-time.Hour).Format("15:04"), QuietHoursEnd: now.Add(time.Hour).Format("15:04"),
+	}
+	if err := db.Create(&quiet).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&NotificationPreference{UserID: user.FirebaseUID, Type: "join_request", Enabled: true, Channels: "in_app,test_summary"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	outboxIDs := []uint{101, 102}
+	for i, ride := range []Ride{
+		{ID: 7, Origin: "Campus", Destination: "Airport", Date: "2026-10-20", Time: "09:00"},
+		{ID: 8, Origin: "Campus", Destination: "Station", Date: "2026-10-21", Time: "18:30"},
+	} {
+		n := Notification{
+			UserID: user.FirebaseUID, Title: "New join request", Message: "Someone wants to join", Type: "join_request",
+			RideID: ride.ID, OutboxID: &outboxIDs[i], Origin: ride.Origin, Destination: ride.Destination, Date: ride.Date, Time: ride.Time,
+		}
+		if err := dispatchNotification(context.Background(), n, map[string]bool{}); err != nil {
+			t.Fatalf("dispatch: %v", err)
+		}
+	}
+
+	var held int64
+	db.Model(&DigestEntry{}).Count(&held)
+	if held != 4 {
+		t.Fatalf("held %d digest entries, want 4", held)
+	}
+
+	// Flush after quiet hours. The in-app entries are then put back, as if deleting them had
+	// failed, and flushed again.
+	if err := db.Model(&NotificationSettings{}).Where("user_id = ?", user.FirebaseUID).Update("quiet_hours_start", "").Error; err != nil {
+		t.Fatal(err)
+	}
+	var inApp []DigestEntry
+	db.Where("channel = ?", "in_app").Find(&inApp)
+	if err := flushDigests(context.Background(), time.Now()); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	for i := range inApp {
+		inApp[i].ID = 0
+	}
+	if err := db.Create(&inApp).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := flushDigests(context.Background(), time.Now()); err != nil {
+		t.Fatalf("flush retry: %v", err)
+	}
+
+	// The feed gets each notification back with its ride, once
+	var feed []Notification
+	db.Where("user_id = ?", user.FirebaseUID).Order("ride_id").Find(&feed)
+	if len(feed) != 2 {
+		t.Fatalf("feed has %d notifications, want 2", len(feed))
+	}
+	if feed[0].RideID != 7 || feed[0].Destination != "Airport" || feed[1].RideID != 8 || feed[1].Time != "18:30" {
+		t.Fatalf("feed lost ride details: %+v", feed)
+	}
+
+	// Other channels get one summary mentioning both rides
+	if len(summary.sent) != 1 {
+		t.Fatalf("summary channel got %d messages, want 1", len(summary.sent))
+	}
+	if msg := summary.sent[0].Message; !strings.Contains(msg, "Airport") || !strings.Contains(msg, "Station") {
+		t.Fatalf("summary lost ride details: %q", msg)
+	}
+	if summary.sent[0].RideID != 0 {
+		t.Fatalf("summary of two rides links ride %d", summary.sent[0].RideID)
+	}
+}
+
+func TestDigestSummaryOfOneRideLinksIt(t *testing.T) {
+	entries := []DigestEntry{
+		{Title: "Join request", Message: "A", RideID: 7, Origin: "Campus", Destination: "Airport", Date: "2026-10-20", Time: "09:00"},
+		{Title: "Join request", Message: "B", RideID: 7, Origin: "Campus", Destination: "Airport", Date: "2026-10-20", Time: "09:00"},
+	}
+	digest := buildDigestNotification("uid", entries)
+	if digest.RideID != 7 || digest.Origin != "Campus" || digest.Time != "09:00" {
+		t.Fatalf("digest = %+v, want it linked to ride 7", digest)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/clause"
+)
+
+const pushSendTimeout = 10 * time.Second
+
+// DeviceToken is a push registration for one of the user's devices
+type DeviceToken struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	UserID     string    `gorm:"index;not null" json:"-"` // Firebase UID
+	Token      string    `gorm:"type:varchar(512);uniqueIndex;not null" json:"-"`
+	Platform   string    `gorm:"type:varchar(20);not null" json:"platform"` // "web", "android" or "ios"
+	Enabled    bool      `gorm:"not null" json:"enabled"`                   // Per-device opt-out
+	LastSeenAt time.Time `json:"last_seen_at"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Request body struct for registering a device
+type RegisterDeviceRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Platform string `json:"platform" binding:"required,oneof=web android ios"`
+}
+
+// Request body struct for updating a device
+type UpdateDeviceRequest struct {
+	Enabled *bool `json:"enabled" binding:"required"`
+}
+
+// UpdateDeviceResponse confirms a device's new push setting
+type UpdateDeviceResponse struct {
+	Message string `json:"message"`
+	Enabled bool   `json:"enabled"`
+}
+
+// PushMessage is the payload sent to each of a user's devices
+type PushMessage struct {
+	Title string
+	Body  string
+	Data  map[string]string
+
+	// Devices show only the latest push with the same collapse key, so sending one twice
+	// doesn't notify twice. Empty for none.
+	CollapseKey string
+}
+
+// PushResult is the outcome for one token; Invalid means the token should be forgotten
+type PushResult struct {
+	Token   string
+	Invalid bool
+	Err     error
+}
+
+// PushSender delivers a message to a set of device tokens
+type PushSender interface {
+	Send(ctx context.Context, tokens []string, msg PushMessage) ([]PushResult, error)
+}
+
+// FCMSender sends push notifications through Firebase Cloud Messaging
+type FCMSender struct {
+	client *messaging.Client
+}
+
+func (s FCMSender) Send(ctx context.Context, tokens []string, msg PushMessage) ([]PushResult, error) {
+	multicast := &messaging.MulticastMessage{
+		Tokens:       tokens,
+		Data:         msg.Data,
+		Notification: &messaging.Notification{Title: msg.Title, Body: msg.Body},
+	}
+	if msg.CollapseKey != "" {
+		multicast.Android = &messaging.AndroidConfig{CollapseKey: msg.CollapseKey}
+		multicast.APNS = &messaging.APNSConfig{Headers: map[string]string{"apns-collapse-id": msg.CollapseKey}}
+		multicast.Webpush = &messaging.WebpushConfig{Headers: map[string]string{"Topic": msg.CollapseKey}}
+	}
+
+	resp, err := s.client.SendEachForMulticast(ctx, multicast)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]PushResult, len(tokens))
+	for i, r := range resp.Responses {
+		results[i] = PushResult{Token: tokens[i], Err: r.Error}
+		if r.Error != nil {
+			results[i].Invalid = messaging.IsRegistrationTokenNotRegistered(r.Error) || messaging.IsInvalidArgument(r.Error)
+		}
+	}
+	return results, nil
+}
+
+// PushChannel sends notifications to every enabled device of the recipient
+type PushChannel struct {
+	sender PushSender
+}
+
+func (PushChannel) Name() string { return "push" }
+
+func (ch PushChannel) Send(ctx context.Context, n *Notification) error {
+	db := DB.WithContext(ctx)
+	var devices []DeviceToken
+	if err := db.Where("user_id = ? AND enabled = ?", n.UserID, true).Find(&devices).Error; err != nil {
+		return err
+	}
+	if len(devices) == 0 {
+		return nil
+	}
+
+	tokens := make([]string, 0, len(devices))
+	for _, d := range devices {
+		tokens = append(tokens, d.Token)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pushSendTimeout)
+	defer cancel()
+
+	msg := PushMessage{
+		Title: n.Title,
+		Body:  n.Message,
+		Data: map[string]string{
+			"type":    n.Type,
+			"ride_id": strconv.FormatUint(uint64(n.RideID), 10),
+		},
+	}
+	if n.OutboxID != nil {
+		msg.CollapseKey = "outbox-" + strconv.FormatUint(uint64(*n.OutboxID), 10)
+	}
+
+	results, err := ch.sender.Send(ctx, tokens, msg)
+	if err != nil {
+		return err
+	}
+
+	var invalid []string
+	var errs []error
+	for _, r := range results {
+		if r.Invalid {
+			invalid = append(invalid, r.Token)
+		} else if r.Err != nil {
+			errs = append(errs, r.Err)
+		}
+	}
+
+	// Forget tokens FCM says are no longer valid
+	if len(invalid) > 0 {
+		if err := db.Where("token IN ?", invalid).Delete(&DeviceToken{}).Error; err != nil {
+			errs = append(errs, fmt.Errorf("failed to prune invalid tokens: %w", err))
+		}
+	}
+
+	// Only fail if no device received the message
+	if len(errs) > 0 && len(errs)+len(invalid) == len(results) {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// InitPush registers the push channel, sending through FCM
+func InitPush() error {
+	client, err := firebaseApp.Messaging(context.Background())
+	if err != nil {
+		return fmt.Errorf("error getting Messaging client: %v", err)
+	}
+
+	RegisterNotificationChannel(PushChannel{sender: FCMSender{client: client}})
+	slog.Info("✅ Push notifications enabled")
+	return nil
+}
+
+// POST /user/devices - Register (or refresh) a device token for push notifications
+func RegisterDevice(c *gin.Context) {
+	db := DB.WithContext(c.Request.Context())
+
+	userID := c.MustGet("uid").(string)
+
+	var req RegisterDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request data")
+		return
+	}
+
+	// A token moves with the device, so re-registering reassigns it to the current user and
+	// turns push back on, rather than keeping a previous owner's opt-out
+	device := DeviceToken{
+		UserID:     userID,
+		Token:      req.Token,
+		Platform:   req.Platform,
+		Enabled:    true,
+		LastSeenAt: time.Now(),
+	}
+	if err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "token"}},
+		DoUpdates: clause.AssignmentColumns([]string{"user_id", "platform", "enabled", "last_seen_at", "updated_at"}),
+	}).Create(&device).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to register device")
+		return
+	}
+
+	if err := db.Where("token = ?", req.Token).First(&device).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to load device")
+		return
+	}
+
+	c.JSON(http.StatusOK, device)
+}
+
+// GET /user/devices - List the user's registered devices
+func GetUserDevices(c *gin.Context) {
+	db := DB.WithContext(c.Request.Context())
+
+	userID := c.MustGet("uid").(string)
+
+	var devices []DeviceToken
+	if err := db.Where("user_id = ?", userID).Order("id").Find(&devices).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to fetch devices")
+		return
+	}
+
+	c.JSON(http.StatusOK, devices)
+}
+
+// PUT /user/devices/:deviceID - Opt a device in or out of push notifications
+func UpdateDevice(c *gin.Context) {
+	db := DB.WithContext(c.Request.Context())
+
+	deviceID := c.Param("deviceID")
+	userID := c.MustGet("uid").(string)
+
+	var req UpdateDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request data")
+		return
+	}
+
+	result := db.Model(&DeviceToken{}).
+		Where("id = ? AND user_id = ?", deviceID, userID).
+		Updates(map[string]interface{}{"enabled": *req.Enabled, "updated_at": time.Now()})
+	if result.Error != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to update device")
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		abortWithError(c, http.StatusNotFound, CodeDeviceNotFound, "Device not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, UpdateDeviceResponse{Message: "Device updated", Enabled: *req.Enabled})
+}
+
+// DELETE /user/devices/:deviceID - Unregister a device
+func DeleteDevice(c *gin.Context) {
+	db := DB.WithContext(c.Request.Context())
+
+	deviceID := c.Param("deviceID")
+	userID := c.MustGet("uid").(string)
+
+	result := db.Where("id = ? AND user_id = ?", deviceID, userID).Delete(&DeviceToken{})
+	if result.Error != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to delete device")
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		abortWithError(c, http.StatusNotFound, CodeDeviceNotFound, "Device not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, MessageResponse{Message: "Device removed"})
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// fakePushSender records pushes instead of sending them. Tokens in invalid are reported back as
+// unregistered, as FCM does for uninstalled apps.
+type fakePushSender struct {
+	mu      sync.Mutex
+	invalid map[string]bool
+	sent    map[string]int  // Pushes per token
+	keys    map[string]bool // Collapse keys seen
+}
+
+func (s *fakePushSender) Send(_ context.Context, tokens []string, msg PushMessage) ([]PushResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sent == nil {
+		s.sent = map[string]int{}
+		s.keys = map[string]bool{}
+	}
+	if msg.CollapseKey != "" {
+		s.keys[msg.CollapseKey] = true
+	}
+	results := make([]PushResult, 0, len(tokens))
+	for _, token := range tokens {
+		if s.invalid[token] {
+			results = append(results, PushResult{Token: token, Invalid: true, Err: errors.New("token not registered")})
+			continue
+		}
+		s.sent[token]++
+		results = append(results, PushResult{Token: token})
+	}
+	return results, nil
+}
+
+func seedDevice(t *testing.T, user *User, token string, enabled bool) DeviceToken {
+	t.Helper()
+
+	device := DeviceToken{UserID: user.FirebaseUID, Token: token, Platform: "android", Enabled: enabled}
+	if err := DB.Create(&device).Error; err != nil {
+		t.Fatalf("seed device: %v", err)
+	}
+	return device
+}
+
+func TestPushSkipsOptedOutDevicesAndPrunesInvalidTokens(t *testing.T) {
+	db := newTestDB(t)
+	user := seedUser(t, db)
+	seedDevice(t, user, "phone", true)
+	seedDevice(t, user, "tablet", false)
+	seedDevice(t, user, "uninstalled", true)
+
+	sender := &fakePushSender{invalid: map[string]bool{"uninstalled": true}}
+	n := Notification{UserID: user.FirebaseUID, Title: "Ride cancelled", Message: "Sorry", Type: "ride_cancelled", RideID: 3}
+	if err := (PushChannel{sender: sender}).Send(context.Background(), &n); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	if sender.sent["phone"] != 1 || sender.sent["tablet"] != 0 {
+		t.Fatalf("pushes = %v, want only the enabled phone", sender.sent)
+	}
+
+	var tokens []string
+	db.Model(&DeviceToken{}).Order("token").Pluck("token", &tokens)
+	if fmt.Sprint(tokens) != "[phone tablet]" {
+		t.Fatalf("devices left = %v, want the uninstalled one pruned", tokens)
+	}
+}
+
+func TestPushFailsOnlyWhenNoDeviceReceivesIt(t *testing.T) {
+	db := newTestDB(t)
+	user := seedUser(t, db)
+	seedDevice(t, user, "gone", true)
+
+	// Every token invalid: nothing to retry, so not an error
+	sender := &fakePushSender{invalid: map[string]bool{"gone": true}}
+	n := Notification{UserID: user.FirebaseUID, Title: "Title", Message: "Message", Type: "join_request"}
+	if err := (PushChannel{sender: sender}).Send(context.Background(), &n); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	// No devices left: nothing to do
+	if err := (PushChannel{sender: sender}).Send(context.Background(), &n); err != nil {
+		t.Fatalf("send without devices: %v", err)
+	}
+	if len(sender.sent) != 0 {
+		t.Fatalf("pushes = %v, want none", sender.sent)
+	}
+}
+
+func TestDeviceOptOutAndReRegistration(t *testing.T) {
+	db := newTestDB(t)
+	user := seedUser(t, db)
+	sender := &fakePushSender{}
+	push := PushChannel{sender: sender}
+	n := Notification{UserID: user.FirebaseUID, Title: "Title", Message: "Message", Type: "join_request"}
+
+	var device DeviceToken
+	w := serve(t, RegisterDevice, "POST", "/user/devices", "/user/devices", user.FirebaseUID, RegisterDeviceRequest{Token: "phone", Platform: "android"})
+	decodeJSON(t, w, http.StatusOK, &device)
+
+	// Opting out stops pushes to the device
+	optOut := false
+	w = serve(t, UpdateDevice, "PUT", "/user/devices/:deviceID", fmt.Sprintf("/user/devices/%d", device.ID), user.FirebaseUID, UpdateDeviceRequest{Enabled: &optOut})
+	decodeJSON(t, w, http.StatusOK, nil)
+	if err := push.Send(context.Background(), &n); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if sender.sent["phone"] != 0 {
+		t.Fatal("pushed to an opted-out device")
+	}
+
+	// Another user can't opt the device back in
+	other := seedUser(t, db)
+	optIn := true
+	w = serve(t, UpdateDevice, "PUT", "/user/devices/:deviceID", fmt.Sprintf("/user/devices/%d", device.ID), other.FirebaseUID, UpdateDeviceRequest{Enabled: &optIn})
+	decodeJSON(t, w, http.StatusNotFound, nil)
+
+	// Registering the token again, here after the phone changed hands, enables it for the new owner
+	w = serve(t, RegisterDevice, "POST", "/user/devices", "/user/devices", other.FirebaseUID, RegisterDeviceRequest{Token: "phone", Platform: "android"})
+	decodeJSON(t, w, http.StatusOK, &device)
+	if !device.Enabled {
+		t.Fatal("re-registered device is still opted out")
+	}
+	n.UserID = other.FirebaseUID
+	if err := push.Send(context.Background(), &n); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if sender.sent["phone"] != 1 {
+		t.Fatalf("pushes = %v, want one to the re-registered phone", sender.sent)
+	}
+
+	var count int64
+	db.Model(&DeviceToken{}).Where("user_id = ?", user.FirebaseUID).Count(&count)
+	if count != 0 {
+		t.Fatal("the previous owner still has the device")
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// listEndpoint is a list handler whose statement count must not grow with the rows it returns
+type listEndpoint struct {
+	name    string
+	handler gin.HandlerFunc
+	route   string
+	queries int64 // Statements per request, whatever the number of rows
+
+	// seed creates n rows for the endpoint to list and returns the request path and caller UID
+	seed func(tb testing.TB, db *gorm.DB, n int) (path, uid string)
+}
+
+var listEndpoints = []listEndpoint{
+	{
+		name: "GetUserNotifications", handler: GetUserNotifications, route: "/user/notifications",
+		queries: 1, // notifications
+		seed: func(tb testing.TB, db *gorm.DB, n int) (string, string) {
+			user := seedUser(tb, db)
+			for i := 0; i < n; i++ {
+				notification := Notification{UserID: user.FirebaseUID, Title: "Title", Message: "Message", Type: "join_request", RideID: 1}
+				if err := db.Create(&notification).Error; err != nil {
+					tb.Fatalf("seed notification: %v", err)
+				}
+			}
+			return "/user/notifications?limit=100", user.FirebaseUID
+		},
+	},
+	{
+		name: "GetUserSentRequests", handler: GetUserSentRequests, route: "/user/requests",
+		queries: 3, // requests, rides, leaders
+		seed: func(tb testing.TB, db *gorm.DB, n int) (string, string) {
+			user := seedUser(tb, db)
+			for i := 0; i < n; i++ {
+				ride := seedRide(tb, db, seedUser(tb, db), "Campus", "Airport", daysFromNow(1), "09:00", 4)
+				seedRequest(tb, db, user, ride, "pending")
+			}
+			return "/user/requests?limit=100", user.FirebaseUID
+		},
+	},
+	{
+		name: "GetUserPrivileges", handler: GetUserPrivileges, route: "/user/privileges",
+		queries: 2, // approved requests, rides
+		seed: func(tb testing.TB, db *gorm.DB, n int) (string, string) {
+			user := seedUser(tb, db)
+			for i := 0; i < n; i++ {
+				ride := seedRide(tb, db, seedUser(tb, db), "Campus", "Airport", daysFromNow(1), "09:00", 4)
+				seedRequest(tb, db, user, ride, "approved")
+			}
+			return "/user/privileges", user.FirebaseUID
+		},
+	},
+	{
+		name: "GetRideParticipants", handler: GetRideParticipants, route: "/ride/:rideID/participants",
+		queries: 4, // ride, caller, participants, participant users
+		seed: func(tb testing.TB, db *gorm.DB, n int) (string, string) {
+			leader := seedUser(tb, db)
+			ride := seedRide(tb, db, leader, "Campus", "Airport", daysFromNow(1), "09:00", n)
+			for i := 0; i < n; i++ {
+				if err := db.Create(&Participant{RideID: ride.ID, UserID: seedUser(tb, db).FirebaseUID}).Error; err != nil {
+					tb.Fatalf("seed participant: %v", err)
+				}
+			}
+			return fmt.Sprintf("/ride/%d/participants", ride.ID), leader.FirebaseUID
+		},
+	},
+	{
+		name: "GetJoinRequestsForRide", handler: GetJoinRequestsForRide, route: "/ride/:rideID/requests",
+		queries: 4, // ride, caller, pending requests, requesting users
+		seed: func(tb testing.TB, db *gorm.DB, n int) (string, string) {
+			leader := seedUser(tb, db)
+			ride := seedRide(tb, db, leader, "Campus", "Airport", daysFromNow(1), "09:00", 4)
+			for i := 0; i < n; i++ {
+				seedRequest(tb, db, seedUser(tb, db), ride, "pending")
+			}
+			return fmt.Sprintf("/ride/%d/requests", ride.ID), leader.FirebaseUID
+		},
+	},
+	{
+		name: "FilterRides/price", handler: FilterRides, route: "/ride/filter",
+		queries: 3, // origin, destination, rides: price order needs no leader history
+		seed:    seedRouteSearch("price"),
+	},
+	{
+		name: "FilterRides/relevance", handler: FilterRides, route: "/ride/filter",
+		queries: 4, // origin, destination, rides, leader history
+		seed:    seedRouteSearch("relevance"),
+	},
+}
+
+// seedRouteSearch seeds n rides by different leaders on one route, searched with ?sort=sortBy
+func seedRouteSearch(sortBy string) func(tb testing.TB, db *gorm.DB, n int) (string, string) {
+	return func(tb testing.TB, db *gorm.DB, n int) (string, string) {
+		for i := 0; i < n; i++ {
+			seedRide(tb, db, seedUser(tb, db), "Campus", "Airport", daysFromNow(1), "09:00", 4)
+		}
+		return "/ride/filter?origin=Campus&destination=Airport&limit=100&time=09:00&sort=" + sortBy + "&date=" + daysFromNow(1), ""
+	}
+}
+
+// itemCount counts the rows in a list response, bare or wrapped in a Page
+func itemCount(tb testing.TB, w *httptest.ResponseRecorder) int {
+	tb.Helper()
+
+	var page Page[map[string]interface{}]
+	if w.Body.Len() > 0 && w.Body.Bytes()[0] == '{' {
+		decodeJSON(tb, w, http.StatusOK, &page)
+		return len(page.Items)
+	}
+	decodeJSON(tb, w, http.StatusOK, &page.Items)
+	return len(page.Items)
+}
+
+func TestListEndpointQueryCounts(t *testing.T) {
+	for _, e := range listEndpoints {
+		for _, n := range []int{1, 10, 50} {
+			t.Run(fmt.Sprintf("%s/N=%d", e.name, n), func(t *testing.T) {
+				db := newTestDB(t)
+				path, uid := e.seed(t, db, n)
+				counter := countStatements(t, db)
+
+				w := serve(t, e.handler, http.MethodGet, e.route, path, uid, nil)
+				if got := itemCount(t, w); got != n {
+					t.Fatalf("listed %d rows, want %d", got, n)
+				}
+				if got := counter.Count(); got != e.queries {
+					t.Errorf("ran %d statements for %d rows, want %d", got, n, e.queries)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkListEndpoints(b *testing.B) {
+	for _, e := range listEndpoints {
+		for _, n := range []int{1, 10, 50} {
+			b.Run(fmt.Sprintf("%s/N=%d", e.name, n), func(b *testing.B) {
+				db := newTestDB(b)
+				path, uid := e.seed(b, db, n)
+				counter := countStatements(b, db)
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					serve(b, e.handler, http.MethodGet, e.route, path, uid, nil)
+				}
+				b.ReportMetric(float64(counter.Count())/float64(b.N), "queries/op")
+			})
+		}
+	}
+}
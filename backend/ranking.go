@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ride search orders, chosen with ?sort=
+const (
+	sortByTime      = "time"      // Earliest departure first (the default)
+	sortByPrice     = "price"     // Cheapest first
+	sortBySeats     = "seats"     // Most seats available first
+	sortByRelevance = "relevance" // Highest relevance score first
+)
+
+func validRideSort(s string) bool {
+	return s == sortByTime || s == sortByPrice || s == sortBySeats || s == sortByRelevance
+}
+
+// RankingWeights weigh the signals of a ride's relevance score. Each signal is between 0 and 1,
+// so a weight is how much that signal matters compared to the others.
+type RankingWeights struct {
+	Time   float64 // Departure close to the requested ?time
+	Seats  float64 // Seats still available
+	Price  float64 // Cheap compared to the other results
+	Leader float64 // Leader's track record: rides led in the past
+	Gender float64 // Share of the ride's group (leader and participants) with the searcher's gender
+}
+
+// Default weights, overridable with RANKING_WEIGHTS
+var rankingWeights = RankingWeights{Time: 3, Seats: 1, Price: 2, Leader: 1, Gender: 1}
+
+const (
+	timeProximityWindow     = 3 * time.Hour // Departures further than this from ?time score 0
+	seatsForFullScore       = 4
+	leaderRidesForFullScore = 10 // Past rides led for a full leader score
+)
+
+// parseRankingWeights parses RANKING_WEIGHTS, e.g. "time=3,price=2". Signals left out keep their default.
+func parseRankingWeights(spec string) (RankingWeights, error) {
+	weights := rankingWeights
+	fields := map[string]*float64{
+		"time": &weights.Time, "seats": &weights.Seats, "price": &weights.Price,
+		"leader": &weights.Leader, "gender": &weights.Gender,
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return weights, fmt.Errorf("invalid ranking weight %q, expected signal=weight", entry)
+		}
+		field, ok := fields[strings.TrimSpace(name)]
+		if !ok {
+			return weights, fmt.Errorf("unknown ranking signal %q, expected time, seats, price, leader or gender", name)
+		}
+		w, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || w < 0 {
+			return weights, fmt.Errorf("invalid weight in %q, expected a non-negative number", entry)
+		}
+		*field = w
+	}
+	return weights, nil
+}
+
+// InitRanking applies overrides from RANKING_WEIGHTS to the default weights
+func InitRanking(spec string) error {
+	if spec == "" {
+		return nil
+	}
+
+	weights, err := parseRankingWeights(spec)
+	if err != nil {
+		return err
+	}
+	rankingWeights = weights
+	slog.Info("ℹ️  Ranking weights overridden", "weights", fmt.Sprintf("%+v", weights))
+	return nil
+}
+
+// rankingInput is what relevance scoring needs beyond the rides themselves
+type rankingInput struct {
+	requestedTime string            // HH:mm from ?time, empty when not given
+	gender        string            // Searcher's gender, empty when anonymous or unset
+	leaderRides   map[uint]int64    // Past rides led, by leader ID
+	groupGenders  map[uint][]string // Genders of the leader and participants, by ride ID
+	priceRange    [2]float64        // Lowest and highest price among the results
+}
+
+// loadRankingInput gathers the leader history and ride groups of the rides being ranked
+func loadRankingInput(ctx context.Context, rides []Ride, requestedTime, gender string) (rankingInput, error) {
+	in := rankingInput{
+		requestedTime: requestedTime,
+		gender:        strings.ToLower(strings.TrimSpace(gender)),
+		leaderRides:   map[uint]int64{},
+		groupGenders:  map[uint][]string{},
+	}
+	if len(rides) == 0 {
+		return in, nil
+	}
+
+	in.priceRange = [2]float64{math.Inf(1), math.Inf(-1)}
+	rideIDs := make([]uint, 0, len(rides))
+	leaderIDs := make([]uint, 0, len(rides))
+	for _, r := range rides {
+		rideIDs = append(rideIDs, r.ID)
+		leaderIDs = append(leaderIDs, r.LeaderID)
+		in.priceRange[0] = math.Min(in.priceRange[0], r.Price)
+		in.priceRange[1] = math.Max(in.priceRange[1], r.Price)
+	}
+
+	db := DB.WithContext(ctx)
+
+	var counts []struct {
+		LeaderID uint
+		Count    int64
+	}
+	today := time.Now().Format("2006-01-02")
+	if err := db.Model(&Ride{}).Select("leader_id, COUNT(*) AS count").
+		Where("leader_id IN ? AND date < ?", leaderIDs, today).
+		Group("leader_id").Scan(&counts).Error; err != nil {
+		return in, err
+	}
+	for _, c := range counts {
+		in.leaderRides[c.LeaderID] = c.Count
+	}
+
+	// Group genders only matter when the searcher's gender is known
+	if in.gender == "" || rankingWeights.Gender == 0 {
+		return in, nil
+	}
+
+	leaders, err := getUsersByIDs(ctx, leaderIDs)
+	if err != nil {
+		return in, err
+	}
+	var participants []Participant
+	if err := db.Where("ride_id IN ?", rideIDs).Find(&participants).Error; err != nil {
+		return in, err
+	}
+	uids := make([]string, 0, len(participants))
+	for _, p := range participants {
+		uids = append(uids, p.UserID)
+	}
+	users, err := getUsersByFirebaseUIDs(ctx, uids)
+	if err != nil {
+		return in, err
+	}
+
+	for _, r := range rides {
+		if leader, ok := leaders[r.LeaderID]; ok {
+			in.groupGenders[r.ID] = append(in.groupGenders[r.ID], leader.Gender)
+		}
+	}
+	for _, p := range participants {
+		if u, ok := users[p.UserID]; ok {
+			in.groupGenders[p.RideID] = append(in.groupGenders[p.RideID], u.Gender)
+		}
+	}
+	return in, nil
+}
+
+// score is the weighted sum of a ride's relevance signals
+func (in rankingInput) score(r Ride, w RankingWeights) float64 {
+	var total float64
+
+	if in.requestedTime != "" {
+		if diff, ok := minutesApart(r.Time, in.requestedTime); ok {
+			total += w.Time * math.Max(0, 1-diff/timeProximityWindow.Minutes())
+		}
+	}
+
+	available := r.Seats - r.SeatsFilled
+	total += w.Seats * math.Min(float64(max(available, 0)), seatsForFullScore) / seatsForFullScore
+
+	if low, high := in.priceRange[0], in.priceRange[1]; high > low {
+		total += w.Price * (high - r.Price) / (high - low)
+	} else {
+		total += w.Price
+	}
+
+	total += w.Leader * math.Min(float64(in.leaderRides[r.LeaderID]), leaderRidesForFullScore) / leaderRidesForFullScore
+
+	if group := in.groupGenders[r.ID]; in.gender != "" && len(group) > 0 {
+		same := 0
+		for _, g := range group {
+			if strings.ToLower(strings.TrimSpace(g)) == in.gender {
+				same++
+			}
+		}
+		total += w.Gender * float64(same) / float64(len(group))
+	}
+
+	return total
+}
+
+// minutesApart is the distance between two HH:mm times in minutes
+func minutesApart(a, b string) (float64, bool) {
+	ta, errA := time.Parse("15:04", a)
+	tb, errB := time.Parse("15:04", b)
+	if errA != nil || errB != nil {
+		return 0, false
+	}
+	return math.Abs(ta.Sub(tb).Minutes()), true
+}
+
+// rankRides reorders rides, which arrive in time order, by sortBy. Ties keep time order.
+func rankRides(rides []Ride, sortBy string, in rankingInput) {
+	switch sortBy {
+	case sortByPrice:
+		sort.SliceStable(rides, func(i, j int) bool { return rides[i].Price < rides[j].Price })
+	case sortBySeats:
+		sort.SliceStable(rides, func(i, j int) bool {
+			return rides[i].Seats-rides[i].SeatsFilled > rides[j].Seats-rides[j].SeatsFilled
+		})
+	case sortByRelevance:
+		scores := make(map[uint]float64, len(rides))
+		for _, r := range rides {
+			scores[r.ID] = in.score(r, rankingWeights)
+		}
+		sort.SliceStable(rides, func(i, j int) bool { return scores[rides[i].ID] > scores[rides[j].ID] })
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"testing"
+)
+
+func TestRankingScoreWeights(t *testing.T) {
+	// One ride leaves at the requested time but costs more; the other is cheap, three hours off
+	// and led by someone with a track record
+	onTime := Ride{ID: 1, LeaderID: 1, Time: "09:00", Price: 200, Seats: 4}
+	cheap := Ride{ID: 2, LeaderID: 2, Time: "12:00", Price: 100, Seats: 4}
+	in := rankingInput{
+		requestedTime: "09:00",
+		leaderRides:   map[uint]int64{2: 20},
+		priceRange:    [2]float64{100, 200},
+	}
+
+	tests := []struct {
+		name          string
+		weights       RankingWeights
+		onTime, cheap float64
+	}{
+		{"time outweighs price", RankingWeights{Time: 3, Price: 1}, 3, 1},
+		{"price outweighs time", RankingWeights{Time: 1, Price: 3}, 1, 3},
+		{"leader history alone", RankingWeights{Leader: 1}, 0, 1},
+		{"seats alone, a tie", RankingWeights{Seats: 1}, 1, 1},
+	}
+	for _, tt := range tests {
+		a, b := in.score(onTime, tt.weights), in.score(cheap, tt.weights)
+		if math.Abs(a-tt.onTime) > 0.001 || math.Abs(b-tt.cheap) > 0.001 {
+			t.Errorf("%s: scores %.3f and %.3f, want %.3f and %.3f", tt.name, a, b, tt.onTime, tt.cheap)
+		}
+	}
+}
+
+func TestRankingScoreGender(t *testing.T) {
+	ride := Ride{ID: 1, Time: "09:00", Price: 100}
+	weights := RankingWeights{Gender: 1}
+
+	tests := []struct {
+		name     string
+		searcher string
+		group    []string
+		want     float64
+	}{
+		{"the whole group shares the searcher's gender", "female", []string{"female", " Female"}, 1},
+		{"half the group", "female", []string{"female", "male"}, 0.5},
+		{"none of the group", "female", []string{"male", "male"}, 0},
+		{"a group whose genders are unknown", "female", nil, 0},
+		{"a searcher whose gender is unknown", "", []string{"female"}, 0},
+	}
+	for _, tt := range tests {
+		in := rankingInput{gender: tt.searcher, groupGenders: map[uint][]string{ride.ID: tt.group}}
+		if got := in.score(ride, weights); math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("%s: score = %.3f, want %.3f", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFilterRidesCachesTimeOnlyForRelevance(t *testing.T) {
+	db := newTestDB(t)
+	useRideSearchCache(t)
+	leader := seedUser(t, db)
+	date := daysFromNow(1)
+	seedRide(t, db, leader, "Campus", "Airport", date, "09:00", 4)
+	seedRide(t, db, leader, "Campus", "Airport", date, "18:00", 4)
+	search := func(sortBy, at string) string {
+		t.Helper()
+		w := serve(t, FilterRides, "GET", "/ride/filter",
+			"/ride/filter?origin=Campus&destination=Airport&date="+date+"&sort="+sortBy+"&time="+at, "", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("search = %d: %s", w.Code, w.Body.String())
+		}
+		return w.Body.String()
+	}
+
+	// Price order ignores ?time, so another time is the same cached search
+	search("price", "09:00")
+	counter := countStatements(t, db)
+	search("price", "18:00")
+	if n := counter.Count(); n != 0 {
+		t.Fatalf("price search at another time ran %d statements, want it served from the cache", n)
+	}
+
+	// Relevance order depends on it
+	if search("relevance", "09:00") == search("relevance", "18:00") {
+		t.Fatal("relevance searches at different times got the same results")
+	}
+}
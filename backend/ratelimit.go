@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimit is a token bucket budget: Requests per Period on average, with bursts of up to Burst
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+func (l RateLimit) ratePerSecond() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// RateLimitStore holds token buckets. The in-memory store only limits a single instance;
+// a shared store (e.g. Redis) can implement this interface when the backend is scaled out.
+type RateLimitStore interface {
+	// Allow takes a token from the bucket for key, returning how long to wait when it is empty
+	Allow(key string, limit RateLimit) (allowed bool, retryAfter time.Duration, err error)
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time // When the bucket will have refilled, after which it can be forgotten
+}
+
+// MemoryRateLimitStore keeps buckets in process memory
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time // Replaced in tests to refill buckets without waiting
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: map[string]*tokenBucket{}, lastSweep: time.Now(), now: time.Now}
+}
+
+func (s *MemoryRateLimitStore) Allow(key string, limit RateLimit) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	rate := limit.ratePerSecond()
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(limit.Burst), last: now}
+		s.buckets[key] = b
+	} else {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+		b.last = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		b.full = now.Add(time.Duration((float64(limit.Burst) - b.tokens) / rate * float64(time.Second)))
+		return true, 0, nil
+	}
+
+	wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	return false, wait, nil
+}
+
+// sweep drops buckets that have refilled, as a new bucket would be identical.
+// This keeps one-off IPs from accumulating forever.
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+
+	for key, b := range s.buckets {
+		if now.After(b.full) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// Default budgets, overridable with RATE_LIMITS. "user" applies to every authenticated route
+// on top of any route-specific budget, and "ip" to every attempt at one, before the token is
+// verified.
+var rateLimits = map[string]RateLimit{
+	"ip":           {Requests: 600, Period: time.Minute, Burst: 120},
+	"user":         {Requests: 300, Period: time.Minute, Burst: 60},
+	"filter_rides": {Requests: 60, Period: time.Minute, Burst: 20},
+	"places":       {Requests: 120, Period: time.Minute, Burst: 30},
+	"add_ride":     {Requests: 10, Period: time.Hour, Burst: 3},
+	"join_request": {Requests: 30, Period: time.Hour, Burst: 5},
+	"trip_request": {Requests: 10, Period: time.Hour, Burst: 3},
+	"match_rides":  {Requests: 30, Period: time.Hour, Burst: 5},
+}
+
+var rateLimitStore RateLimitStore = NewMemoryRateLimitStore()
+
+// parseRateLimits parses RATE_LIMITS, e.g. "add_ride=10/h:3,filter_rides=120/m".
+// Each entry is name=requests/unit with unit s, m or h, and an optional :burst (defaults to requests).
+// The name must be one of the budgets in rateLimits.
+func parseRateLimits(spec string) (map[string]RateLimit, error) {
+	limits := map[string]RateLimit{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, budget, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q, expected name=requests/unit[:burst]", entry)
+		}
+		name = strings.TrimSpace(name)
+		if _, ok := rateLimits[name]; !ok {
+			return nil, fmt.Errorf("unknown rate limit %q in %q, expected one of %s", name, entry, strings.Join(rateLimitNames(), ", "))
+		}
+
+		budget, burstStr, hasBurst := strings.Cut(budget, ":")
+		countStr, unit, ok := strings.Cut(budget, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q, expected name=requests/unit[:burst]", entry)
+		}
+
+		requests, err := strconv.Atoi(countStr)
+		if err != nil || requests <= 0 {
+			return nil, fmt.Errorf("invalid request count in rate limit %q", entry)
+		}
+
+		var period time.Duration
+		switch unit {
+		case "s":
+			period = time.Second
+		case "m":
+			period = time.Minute
+		case "h":
+			period = time.Hour
+		default:
+			return nil, fmt.Errorf("invalid unit in rate limit %q, expected s, m or h", entry)
+		}
+
+		burst := requests
+		if hasBurst {
+			burst, err = strconv.Atoi(burstStr)
+			if err != nil || burst <= 0 {
+				return nil, fmt.Errorf("invalid burst in rate limit %q", entry)
+			}
+		}
+
+		limits[name] = RateLimit{Requests: requests, Period: period, Burst: burst}
+	}
+	return limits, nil
+}
+
+// rateLimitNames lists the budgets RATE_LIMITS can override, sorted
+func rateLimitNames() []string {
+	names := make([]string, 0, len(rateLimits))
+	for name := range rateLimits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// InitRateLimits applies overrides from RATE_LIMITS to the default budgets
+func InitRateLimits(spec string) error {
+	if spec == "" {
+		return nil
+	}
+
+	limits, err := parseRateLimits(spec)
+	if err != nil {
+		return err
+	}
+	for name, limit := range limits {
+		rateLimits[name] = limit
+	}
+	slog.Info("ℹ️  Rate limits overridden", "budgets", len(limits))
+	return nil
+}
+
+// takeRateLimitToken takes a token from key's bucket of a budget, returning how long to wait when
+// it is empty
+func takeRateLimitToken(ctx context.Context, name string, limit RateLimit, key string) (bool, time.Duration) {
+	allowed, retryAfter, err := rateLimitStore.Allow(name+"|"+key, limit)
+	if err != nil {
+		// Fail open: an unavailable store shouldn't take the API down with it
+		slog.WarnContext(ctx, "⚠️  Rate limit store error", "error", err)
+		return true, 0
+	}
+	return allowed, retryAfter
+}
+
+// chargeRateLimit takes a token from a user's bucket of the named budget for work done on their
+// behalf by another endpoint, so it can't be used to get round the budget's own limit
+func chargeRateLimit(ctx context.Context, name, uid string) bool {
+	limit, ok := rateLimits[name]
+	if !ok {
+		return true
+	}
+	allowed, _ := takeRateLimitToken(ctx, name, limit, "uid:"+uid)
+	return allowed
+}
+
+// RateLimited enforces the named budget. Authenticated requests are keyed by Firebase UID,
+// so it must run after FirebaseAuthMiddleware on protected routes; anonymous ones by client IP,
+// as are requests checked before FirebaseAuthMiddleware.
+func RateLimited(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, ok := rateLimits[name]
+		if !ok {
+			c.Next()
+			return
+		}
+
+		key := "ip:" + c.ClientIP()
+		if uid, ok := c.Get("uid"); ok {
+			key = "uid:" + uid.(string)
+		}
+
+		if allowed, retryAfter := takeRateLimitToken(c.Request.Context(), name, limit, key); !allowed {
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(seconds))
+			abortWithAPIError(c, &APIError{
+				Status:  http.StatusTooManyRequests,
+				Code:    CodeRateLimited,
+				Message: "Too many requests. Please try again later.",
+				Details: map[string]interface{}{"retry_after_seconds": seconds},
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
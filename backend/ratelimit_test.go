@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// useRateLimit swaps in a fresh store on a fake clock and overrides the named budget for the
+// test, returning a function that moves the clock on
+func useRateLimit(t *testing.T, name string, limit RateLimit) (advance func(time.Duration)) {
+	t.Helper()
+
+	store := NewMemoryRateLimitStore()
+	clock := time.Date(2030, time.March, 10, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return clock }
+
+	prevStore := rateLimitStore
+	prevLimit, hadLimit := rateLimits[name]
+	rateLimitStore = store
+	rateLimits[name] = limit
+	t.Cleanup(func() {
+		rateLimitStore = prevStore
+		if hadLimit {
+			rateLimits[name] = prevLimit
+		} else {
+			delete(rateLimits, name)
+		}
+	})
+
+	return func(d time.Duration) { clock = clock.Add(d) }
+}
+
+func TestRateLimitBurst(t *testing.T) {
+	useRateLimit(t, "test", RateLimit{Requests: 60, Period: time.Minute, Burst: 3})
+
+	for i := 0; i < 3; i++ {
+		if w := serve(t, RateLimited("test"), "GET", "/limited", "/limited", "uid-burst", nil); w.Code != http.StatusOK {
+			t.Fatalf("request %d = %d, want 200 within the burst", i+1, w.Code)
+		}
+	}
+
+	var body APIError
+	decodeJSON(t, serve(t, RateLimited("test"), "GET", "/limited", "/limited", "uid-burst", nil), http.StatusTooManyRequests, &body)
+	if body.Code != CodeRateLimited {
+		t.Fatalf("code = %s, want %s", body.Code, CodeRateLimited)
+	}
+
+	// Budgets are per user
+	if w := serve(t, RateLimited("test"), "GET", "/limited", "/limited", "uid-other", nil); w.Code != http.StatusOK {
+		t.Fatalf("another user got %d, want 200", w.Code)
+	}
+}
+
+func TestRateLimitRefill(t *testing.T) {
+	advance := useRateLimit(t, "test", RateLimit{Requests: 60, Period: time.Minute, Burst: 3})
+	allow := func() bool {
+		allowed, _ := takeRateLimitToken(context.Background(), "test", rateLimits["test"], "uid:uid-refill")
+		return allowed
+	}
+
+	for i := 0; i < 3; i++ {
+		allow()
+	}
+	if allow() {
+		t.Fatal("allowed a request with the bucket empty")
+	}
+
+	// A token a second comes back, up to the burst
+	advance(2 * time.Second)
+	if !allow() || !allow() || allow() {
+		t.Fatal("want exactly two tokens back after two seconds")
+	}
+	advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		if !allow() {
+			t.Fatalf("request %d refused after the bucket refilled", i+1)
+		}
+	}
+	if allow() {
+		t.Fatal("the bucket refilled past its burst")
+	}
+}
+
+func TestRateLimitRetryAfterRoundsUp(t *testing.T) {
+	advance := useRateLimit(t, "test", RateLimit{Requests: 1, Period: time.Hour, Burst: 1})
+
+	serve(t, RateLimited("test"), "GET", "/limited", "/limited", "uid-retry", nil)
+	advance(1500 * time.Millisecond)
+
+	// 3598.5s to wait is reported as 3599, never less than the real wait
+	w := serve(t, RateLimited("test"), "GET", "/limited", "/limited", "uid-retry", nil)
+	var body APIError
+	decodeJSON(t, w, http.StatusTooManyRequests, &body)
+	if got := w.Header().Get("Retry-After"); got != "3599" {
+		t.Fatalf("Retry-After = %q, want 3599", got)
+	}
+	if got := body.Details["retry_after_seconds"]; got != float64(3599) {
+		t.Fatalf("retry_after_seconds = %v, want 3599", got)
+	}
+}
+
+func TestRateLimitByIPBeforeAuth(t *testing.T) {
+	useRateLimit(t, "ip", RateLimit{Requests: 2, Period: time.Minute, Burst: 2})
+
+	r := gin.New()
+	r.Use(ErrorHandler())
+	apiVersions[0].mount(r)
+
+	// Requests with no valid token still use up the client's budget
+	for i, want := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/v1/user", nil))
+		if w.Code != want {
+			t.Fatalf("request %d = %d, want %d", i+1, w.Code, want)
+		}
+	}
+}
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := parseRateLimits(" add_ride=5/h:2, filter_rides=120/m ,")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if limits["add_ride"] != (RateLimit{Requests: 5, Period: time.Hour, Burst: 2}) ||
+		limits["filter_rides"] != (RateLimit{Requests: 120, Period: time.Minute, Burst: 120}) {
+		t.Fatalf("limits = %+v", limits)
+	}
+
+	tests := []struct {
+		spec, want string
+	}{
+		{"add_ride", "expected name=requests/unit[:burst]"},
+		{"add_ride=10", "expected name=requests/unit[:burst]"},
+		{"add_ride=ten/h", "invalid request count"},
+		{"add_ride=0/h", "invalid request count"},
+		{"add_ride=10/d", "invalid unit"},
+		{"add_ride=10/h:0", "invalid burst"},
+		{"add_rides=10/h", "unknown rate limit \"add_rides\""},
+		{"filter_rides=120/m,unknown=1/s", "expected one of add_ride, filter_rides, ip,"},
+	}
+	for _, tt := range tests {
+		if _, err := parseRateLimits(tt.spec); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseRateLimits(%q) = %v, want an error containing %q", tt.spec, err, tt.want)
+		}
+	}
+}
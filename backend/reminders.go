@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RideReminder records that a ride's departure reminder went out, so each ride is reminded once
+// even with several instances running the worker
+type RideReminder struct {
+	ID        uint `gorm:"primaryKey"`
+	RideID    uint `gorm:"uniqueIndex;not null"`
+	CreatedAt time.Time
+}
+
+// sendRideReminders sends a "ride_reminder" notification to the leader and participants of every
+// ride departing within lead of now that hasn't been reminded yet. It returns how many rides were
+// reminded.
+func sendRideReminders(ctx context.Context, now time.Time, lead time.Duration) (int, error) {
+	db := DB.WithContext(ctx)
+	until := now.Add(lead)
+
+	// Ride dates and times are local; a ride is due once its departure is within lead of now
+	dates := []string{now.Format("2006-01-02")}
+	if until.Format("2006-01-02") != dates[0] {
+		dates = append(dates, until.Format("2006-01-02"))
+	}
+	var rides []Ride
+	if err := db.Where("date IN ?", dates).
+		Where("id NOT IN (?)", db.Model(&RideReminder{}).Select("ride_id")).
+		Find(&rides).Error; err != nil {
+		return 0, err
+	}
+
+	reminded := 0
+	for _, ride := range rides {
+		departure, err := time.ParseInLocation("2006-01-02 15:04", ride.Date+" "+ride.Time, now.Location())
+		if err != nil || !departure.After(now) || departure.After(until) {
+			continue
+		}
+
+		sent, err := sendRideReminder(db, ride)
+		if err != nil {
+			return reminded, err
+		}
+		if sent {
+			reminded++
+		}
+	}
+	if reminded > 0 {
+		wakeNotificationOutbox()
+	}
+
+	// Rides before today are never reminded again, nor are deleted ones
+	if err := db.Where("ride_id NOT IN (?)", db.Model(&Ride{}).Select("id").Where("date >= ?", dates[0])).
+		Delete(&RideReminder{}).Error; err != nil {
+		return reminded, err
+	}
+	return reminded, nil
+}
+
+// sendRideReminder reminds everyone on ride, unless another run already has
+func sendRideReminder(db *gorm.DB, ride Ride) (bool, error) {
+	sent := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&RideReminder{RideID: ride.ID})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		var uids []string
+		if err := tx.Model(&User{}).Where("id = ?", ride.LeaderID).Pluck("firebase_uid", &uids).Error; err != nil {
+			return err
+		}
+		var participants []string
+		if err := tx.Model(&Participant{}).Where("ride_id = ?", ride.ID).Pluck("user_id", &participants).Error; err != nil {
+			return err
+		}
+		uids = append(uids, participants...)
+
+		title := "Ride Reminder"
+		message := fmt.Sprintf("Your ride from %s to %s leaves at %s on %s", ride.Origin, ride.Destination, ride.Time, ride.Date)
+		for _, uid := range uids {
+			if err := createNotification(tx, uid, title, message, "ride_reminder", ride); err != nil {
+				return err
+			}
+		}
+		sent = true
+		return nil
+	})
+	return sent, err
+}
+
+// StartRideReminders reminds riders of rides departing within lead, checking every interval.
+// A zero lead disables reminders.
+func StartRideReminders(lead, interval time.Duration) {
+	if lead <= 0 {
+		return
+	}
+
+	runWorker(func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			// Let a run in progress finish even if shutdown starts
+			reminded, err := sendRideReminders(context.WithoutCancel(ctx), time.Now(), lead)
+			if err != nil {
+				slog.Error("❌ Failed to send ride reminders", "error", err)
+			} else if reminded > 0 {
+				slog.Info("⏰ Sent ride reminders", "rides", reminded)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
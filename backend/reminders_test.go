@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSendRideReminders(t *testing.T) {
+	db := newTestDB(t)
+	leader, rider := seedUser(t, db), seedUser(t, db)
+	now := time.Date(2030, time.March, 10, 23, 30, 0, 0, time.Local)
+
+	soon := seedRide(t, db, leader, "Campus", "Airport", "2030-03-10", "23:50", 4)
+	afterMidnight := seedRide(t, db, leader, "Campus", "Station", "2030-03-11", "00:15", 4)
+	seedRide(t, db, leader, "Campus", "Airport", "2030-03-11", "09:00", 4) // Too far off
+	seedRide(t, db, leader, "Campus", "Airport", "2030-03-10", "23:00", 4) // Already left
+	if err := db.Create(&Participant{RideID: soon.ID, UserID: rider.FirebaseUID, JoinedAt: now}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	reminded, err := sendRideReminders(context.Background(), now, time.Hour)
+	if err != nil {
+		t.Fatalf("send reminders: %v", err)
+	}
+	if reminded != 2 {
+		t.Fatalf("reminded %d rides, want 2", reminded)
+	}
+
+	// The next run finds nothing new to remind
+	if reminded, err := sendRideReminders(context.Background(), now.Add(time.Minute), time.Hour); err != nil || reminded != 0 {
+		t.Fatalf("second run reminded %d rides (%v), want 0", reminded, err)
+	}
+
+	var events []NotificationOutbox
+	db.Where("type = ?", "ride_reminder").Order("ride_id, user_id").Find(&events)
+	got := map[uint][]string{}
+	for _, e := range events {
+		got[e.RideID] = append(got[e.RideID], e.UserID)
+	}
+	if len(events) != 3 || len(got[soon.ID]) != 2 || len(got[afterMidnight.ID]) != 1 || got[afterMidnight.ID][0] != leader.FirebaseUID {
+		t.Fatalf("reminders = %v, want the leader and rider of the first ride and the leader of the second", got)
+	}
+}
+
+func TestRideRemindersBypassQuietHours(t *testing.T) {
+	if !urgentNotificationTypes["ride_reminder"] {
+		t.Fatal("ride reminders would wait out quiet hours and arrive after the ride left")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type Request struct {
@@ -20,12 +21,50 @@ type Request struct {
 	UpdatedAt time.Time
 }
 
+// SentRequestResponse is a join request sent by the user, with ride and leader details
+type SentRequestResponse struct {
+	RequestID      uint          `json:"request_id"`
+	RideID         uint          `json:"ride_id"`
+	Origin         string        `json:"origin"`
+	Destination    string        `json:"destination"`
+	Date           string        `json:"date"`
+	Time           string        `json:"time"`
+	Price          float64       `json:"price"`
+	SeatsAvailable int           `json:"seats_available"`
+	TotalSeats     int           `json:"total_seats"`
+	Status         string        `json:"status"`
+	LeaderName     string        `json:"leader_name"`
+	RequestedAt    time.Time     `json:"requested_at"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+	CanCancel      bool          `json:"can_cancel"`
+	CanJoin        bool          `json:"can_join"`
+	Cooldown       *CooldownInfo `json:"cooldown,omitempty"` // Only set for revoked requests
+}
+
+// ClearInvolvementResponse reports the requests and privileges cancelled for a date
+type ClearInvolvementResponse struct {
+	Message             string `json:"message"`
+	CancelledRequests   int    `json:"cancelled_requests"`
+	CancelledPrivileges int    `json:"cancelled_privileges"`
+	TotalCancelled      int    `json:"total_cancelled"`
+	Date                string `json:"date"`
+}
+
+// CooldownInfo tells the user when a revoked request may be resent
+type CooldownInfo struct {
+	CanResend        bool `json:"can_resend"`
+	RemainingMinutes int  `json:"remaining_minutes"`
+}
+
 // POST /ride/:rideID/join
 func SendJoinRequest(c *gin.Context) {
+	ctx := c.Request.Context()
+	db := DB.WithContext(ctx)
+
 	rideIDStr := c.Param("rideID")
 	rideID, err := strconv.Atoi(rideIDStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ride ID"})
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid ride ID")
 		return
 	}
 
@@ -33,103 +72,41 @@ func SendJoinRequest(c *gin.Context) {
 
 	// Get the ride details to check the date
 	var targetRide Ride
-	if err := DB.First(&targetRide, "id = ?", rideID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Ride not found"})
+	if err := db.First(&targetRide, "id = ?", rideID).Error; err != nil {
+		abortWithError(c, http.StatusNotFound, CodeRideNotFound, "Ride not found")
 		return
 	}
 
 	// Get user to find their ID for comparison
-	user, err := getUser(userID)
+	user, err := getUser(ctx, userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		abortWithError(c, http.StatusNotFound, CodeUserNotFound, "User not found")
 		return
 	}
 
 	// Get ride leader information for notification
-	rideLeader, err := getUserByID(targetRide.LeaderID)
+	rideLeader, err := getUserByID(ctx, targetRide.LeaderID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get ride leader information"})
-		return
-	}
-
-	// Check if user has already created a ride on the same date
-	var existingRideCount int64
-	if err := DB.Model(&Ride{}).Where("leader_id = ? AND date = ?", user.ID, targetRide.Date).Count(&existingRideCount).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check existing rides"})
-		return
-	}
-
-	if existingRideCount > 0 {
-		c.JSON(http.StatusConflict, gin.H{
-			"error": "You cannot send a join request and create a ride on the same day. You have already created a ride for " + targetRide.Date,
-		})
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to get ride leader information")
 		return
 	}
 
-	// Check if a request already exists
-	var existing Request
-	if err := DB.Where("ride_id = ? AND user_id = ?", rideID, userID).First(&existing).Error; err == nil {
-		if strings.Contains(strings.ToLower(existing.Status), "pending") {
-			c.JSON(http.StatusConflict, gin.H{"error": "Request already pending"})
-			return
-		}
-		if strings.Contains(strings.ToLower(existing.Status), "approved") {
-			c.JSON(http.StatusConflict, gin.H{"error": "Already approved for this ride"})
-			return
-		}
-		if strings.Contains(strings.ToLower(existing.Status), "revoked") {
-			// Check if 30 minutes have passed since revocation
-			timeSinceRevoked := time.Since(existing.RevokedAt)
-			cooldownPeriod := 30 * time.Minute
-
-			if timeSinceRevoked < cooldownPeriod {
-				remainingTime := cooldownPeriod - timeSinceRevoked
-				remainingMinutes := int(remainingTime.Minutes())
-				c.JSON(http.StatusConflict, gin.H{
-					"error":                      fmt.Sprintf("Request was revoked. Please wait %d more minutes before resending.", remainingMinutes+1),
-					"remaining_cooldown_minutes": remainingMinutes + 1,
-				})
-				return
-			}
-			// Cooldown period has passed, allow new request by deleting the old revoked record
-			if err := DB.Delete(&existing).Error; err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear old request"})
-				return
-			}
-		}
-	}
-
-	// Create new join request
-	request := Request{
-		RideID: uint(rideID),
-		UserID: userID,
-		Status: "pending",
-	}
-
-	if err := DB.Create(&request).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create join request"})
+	if apiErr := sendJoinRequest(db, user, targetRide, rideLeader); apiErr != nil {
+		abortWithAPIError(c, apiErr)
 		return
 	}
 
-	// Send notification to ride leader
-	notificationTitle := "New Join Request"
-	notificationMessage := fmt.Sprintf("%s has requested to join your ride from %s to %s on %s at %s",
-		user.Name, targetRide.Origin, targetRide.Destination, targetRide.Date, targetRide.Time)
-	
-	if err := createNotification(rideLeader.FirebaseUID, notificationTitle, notificationMessage, "join_request", uint(rideID)); err != nil {
-		// Log error but don't fail the request since the join request was created successfully
-		fmt.Printf("Failed to create notification for ride leader %s: %v\n", rideLeader.FirebaseUID, err)
-	}
-
-	c.JSON(http.StatusOK, gin.H{"message": "Join request sent"})
+	c.JSON(http.StatusOK, MessageResponse{Message: "Join request sent"})
 }
 
 // DELETE /ride/:rideID/cancel-request - User cancels their pending join request
 func CancelJoinRequest(c *gin.Context) {
+	db := DB.WithContext(c.Request.Context())
+
 	rideIDStr := c.Param("rideID")
 	rideID, err := strconv.Atoi(rideIDStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ride ID"})
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid ride ID")
 		return
 	}
 
@@ -137,42 +114,88 @@ func CancelJoinRequest(c *gin.Context) {
 
 	// Find the pending request - using ILIKE for case insensitive matching
 	var request Request
-	if err := DB.Where("ride_id = ? AND user_id = ? AND status ILIKE ?", rideID, userID, "%pending%").First(&request).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "No pending request found for this ride"})
+	if err := db.Where("ride_id = ? AND user_id = ? AND status ILIKE ?", rideID, userID, "%pending%").First(&request).Error; err != nil {
+		abortWithError(c, http.StatusNotFound, CodeRequestNotFound, "No pending request found for this ride")
 		return
 	}
 
 	// Delete the pending request
-	if err := DB.Delete(&request).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel request"})
+	if err := db.Delete(&request).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to cancel request")
 		return
 	}
+	joinRequestsTotal.WithLabelValues("cancelled").Inc()
 
-	c.JSON(http.StatusOK, gin.H{"message": "Join request cancelled successfully"})
+	c.JSON(http.StatusOK, MessageResponse{Message: "Join request cancelled successfully"})
 }
 
-// GET /user/requests - Get all join requests sent by the authenticated user
+// GET /user/requests?status=pending&limit=20&cursor=... - Get join requests sent by the authenticated user
 func GetUserSentRequests(c *gin.Context) {
+	ctx := c.Request.Context()
+	db := DB.WithContext(ctx)
+
 	userID := c.MustGet("uid").(string)
 
+	page, err := parsePagination(c, "sent_requests", 0)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	query := db.Where("user_id = ?", userID)
+	if status := strings.ToLower(c.Query("status")); status != "" {
+		if status != "pending" && status != "approved" && status != "revoked" {
+			abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "status must be pending, approved or revoked")
+			return
+		}
+		query = query.Where("status ILIKE ?", "%"+status+"%")
+	}
+
 	// Find all requests sent by the user
 	var requests []Request
-	if err := DB.Where("user_id = ?", userID).Order("created_at DESC").Find(&requests).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch your requests"})
+	query = page.limitQuery(page.afterIDDesc(query, "id")).Order("id DESC")
+	if err := query.Find(&requests).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to fetch your requests")
+		return
+	}
+
+	requests, nextCursor := pageRows(page, requests, func(r Request) pageCursor {
+		return pageCursor{ID: r.ID}
+	})
+
+	rideIDs := make([]uint, 0, len(requests))
+	for _, req := range requests {
+		rideIDs = append(rideIDs, req.RideID)
+	}
+
+	rides, err := getRidesByIDs(ctx, rideIDs)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to fetch rides")
+		return
+	}
+
+	leaderIDs := make([]uint, 0, len(rides))
+	for _, ride := range rides {
+		leaderIDs = append(leaderIDs, ride.LeaderID)
+	}
+
+	leaders, err := getUsersByIDs(ctx, leaderIDs)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to fetch ride leaders")
 		return
 	}
 
 	// Build response with request and ride details
-	var response []map[string]interface{}
+	var response []SentRequestResponse
 	for _, req := range requests {
-		var ride Ride
-		if err := DB.First(&ride, "id = ?", req.RideID).Error; err != nil {
+		ride, ok := rides[req.RideID]
+		if !ok {
 			continue // Skip if ride doesn't exist
 		}
 
 		// Get ride leader info
-		leader, err := getUser(ride.LeaderID)
-		if err != nil {
+		leader, ok := leaders[ride.LeaderID]
+		if !ok {
 			continue // Skip if leader doesn't exist
 		}
 
@@ -180,85 +203,81 @@ func GetUserSentRequests(c *gin.Context) {
 		canCancel := strings.Contains(strings.ToLower(req.Status), "pending")
 		canJoin := strings.Contains(strings.ToLower(req.Status), "approved") && ride.SeatsFilled < ride.Seats
 
+		entry := SentRequestResponse{
+			RequestID:      req.ID,
+			RideID:         ride.ID,
+			Origin:         ride.Origin,
+			Destination:    ride.Destination,
+			Date:           ride.Date,
+			Time:           ride.Time,
+			Price:          ride.Price,
+			SeatsAvailable: ride.Seats - ride.SeatsFilled,
+			TotalSeats:     ride.Seats,
+			Status:         req.Status,
+			LeaderName:     leader.Name,
+			RequestedAt:    req.CreatedAt,
+			UpdatedAt:      req.UpdatedAt,
+			CanCancel:      canCancel,
+			CanJoin:        canJoin,
+		}
+
 		// Calculate cooldown for revoked requests
-		var cooldownInfo map[string]interface{}
 		if strings.Contains(strings.ToLower(req.Status), "revoked") {
 			timeSinceRevoked := time.Since(req.RevokedAt)
 			cooldownPeriod := 30 * time.Minute
 			if timeSinceRevoked < cooldownPeriod {
 				remainingTime := cooldownPeriod - timeSinceRevoked
 				remainingMinutes := int(remainingTime.Minutes())
-				cooldownInfo = map[string]interface{}{
-					"can_resend":        false,
-					"remaining_minutes": remainingMinutes + 1,
+				entry.Cooldown = &CooldownInfo{
+					CanResend:        false,
+					RemainingMinutes: remainingMinutes + 1,
 				}
 			} else {
-				cooldownInfo = map[string]interface{}{
-					"can_resend":        true,
-					"remaining_minutes": 0,
+				entry.Cooldown = &CooldownInfo{
+					CanResend:        true,
+					RemainingMinutes: 0,
 				}
 			}
 		}
 
-		entry := map[string]interface{}{
-			"request_id":      req.ID,
-			"ride_id":         ride.ID,
-			"origin":          ride.Origin,
-			"destination":     ride.Destination,
-			"date":            ride.Date,
-			"time":            ride.Time,
-			"price":           ride.Price,
-			"seats_available": ride.Seats - ride.SeatsFilled,
-			"total_seats":     ride.Seats,
-			"status":          req.Status,
-			"leader_name":     leader.Name,
-			"requested_at":    req.CreatedAt,
-			"updated_at":      req.UpdatedAt,
-			"can_cancel":      canCancel,
-			"can_join":        canJoin,
-		}
-
-		// Add cooldown info for revoked requests
-		if cooldownInfo != nil {
-			entry["cooldown"] = cooldownInfo
-		}
-
 		response = append(response, entry)
 	}
 
-	c.JSON(http.StatusOK, response)
+	respondList(c, page, response, nextCursor)
 }
 
 // DELETE /user/clear-involvement/:date - Cancel all pending requests and privileges for a specific date
 func ClearInvolvementForDate(c *gin.Context) {
+	db := DB.WithContext(c.Request.Context())
+
 	dateParam := c.Param("date")
 	userID := c.MustGet("uid").(string)
 
 	// Validate date format
 	if _, err := time.Parse("2006-01-02", dateParam); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date format, expected YYYY-MM-DD"})
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid date format, expected YYYY-MM-DD")
 		return
 	}
 
 	// Find all pending requests for rides on this date - using ILIKE for case insensitive matching
 	var pendingRequestsForDate []Request
-	if err := DB.Table("requests").
+	if err := db.Table("requests").
 		Joins("JOIN rides ON requests.ride_id = rides.id").
 		Where("requests.user_id = ? AND requests.status ILIKE ? AND rides.date = ?",
 			userID, "%pending%", dateParam).
 		Find(&pendingRequestsForDate).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch pending requests for date"})
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to fetch pending requests for date")
 		return
 	}
 
 	// Find all approved privileges for rides on this date - using ILIKE for case insensitive matching
 	var approvedRequestsForDate []Request
-	if err := DB.Table("requests").
+	if err := db.Table("requests").
 		Joins("JOIN rides ON requests.ride_id = rides.id").
 		Where("requests.user_id = ? AND requests.status ILIKE ? AND rides.date = ?",
 			userID, "%approved%", dateParam).
 		Find(&approvedRequestsForDate).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch privileges for date"})
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to fetch privileges for date")
 		return
 	}
 
@@ -267,12 +286,9 @@ func ClearInvolvementForDate(c *gin.Context) {
 	totalCount := pendingCount + approvedCount
 
 	if totalCount == 0 {
-		c.JSON(http.StatusOK, gin.H{
-			"message":              fmt.Sprintf("No requests or privileges to cancel for %s", dateParam),
-			"cancelled_requests":   0,
-			"cancelled_privileges": 0,
-			"total_cancelled":      0,
-			"date":                 dateParam,
+		c.JSON(http.StatusOK, ClearInvolvementResponse{
+			Message: fmt.Sprintf("No requests or privileges to cancel for %s", dateParam),
+			Date:    dateParam,
 		})
 		return
 	}
@@ -283,10 +299,11 @@ func ClearInvolvementForDate(c *gin.Context) {
 		for i, req := range pendingRequestsForDate {
 			requestIDs[i] = req.ID
 		}
-		if err := DB.Where("id IN ?", requestIDs).Delete(&Request{}).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel pending requests"})
+		if err := db.Where("id IN ?", requestIDs).Delete(&Request{}).Error; err != nil {
+			abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to cancel pending requests")
 			return
 		}
+		joinRequestsTotal.WithLabelValues("cancelled").Add(float64(pendingCount))
 	}
 
 	// Cancel approved privileges for this date
@@ -295,17 +312,104 @@ func ClearInvolvementForDate(c *gin.Context) {
 		for i, req := range approvedRequestsForDate {
 			requestIDs[i] = req.ID
 		}
-		if err := DB.Where("id IN ?", requestIDs).Delete(&Request{}).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel privileges"})
+		if err := db.Where("id IN ?", requestIDs).Delete(&Request{}).Error; err != nil {
+			abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to cancel privileges")
 			return
 		}
+		cancellationsTotal.WithLabelValues("privilege").Add(float64(approvedCount))
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message":              fmt.Sprintf("Successfully cleared all ride involvement for %s", dateParam),
-		"cancelled_requests":   pendingCount,
-		"cancelled_privileges": approvedCount,
-		"total_cancelled":      totalCount,
-		"date":                 dateParam,
+	c.JSON(http.StatusOK, ClearInvolvementResponse{
+		Message:             fmt.Sprintf("Successfully cleared all ride involvement for %s", dateParam),
+		CancelledRequests:   pendingCount,
+		CancelledPrivileges: approvedCount,
+		TotalCancelled:      totalCount,
+		Date:                dateParam,
 	})
 }
+
+// sendJoinRequest asks the leader to let user join ride, enforcing the same-day rule and the
+// cooldown after a revoked request
+func sendJoinRequest(db *gorm.DB, user *User, ride Ride, leader *User) *APIError {
+	// Check if user has already created a ride on the same date
+	existingRideCount, err := countSameDayRides(db, user.ID, ride.Date)
+	if err != nil {
+		return &APIError{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "Failed to check existing rides"}
+	}
+
+	if existingRideCount > 0 {
+		return &APIError{
+			Status:  http.StatusConflict,
+			Code:    CodeSameDayConflict,
+			Message: "You cannot send a join request and create a ride on the same day. You have already created a ride for " + ride.Date,
+			Details: map[string]interface{}{"date": ride.Date},
+		}
+	}
+
+	// Check if a request already exists
+	var existing Request
+	if err := db.Where("ride_id = ? AND user_id = ?", ride.ID, user.FirebaseUID).First(&existing).Error; err == nil {
+		if strings.Contains(strings.ToLower(existing.Status), "pending") {
+			return &APIError{Status: http.StatusConflict, Code: CodeRequestPending, Message: "Request already pending"}
+		}
+		if strings.Contains(strings.ToLower(existing.Status), "approved") {
+			return &APIError{Status: http.StatusConflict, Code: CodeRequestApproved, Message: "Already approved for this ride"}
+		}
+		if strings.Contains(strings.ToLower(existing.Status), "revoked") {
+			// Check if 30 minutes have passed since revocation
+			timeSinceRevoked := time.Since(existing.RevokedAt)
+			cooldownPeriod := 30 * time.Minute
+
+			if timeSinceRevoked < cooldownPeriod {
+				remainingTime := cooldownPeriod - timeSinceRevoked
+				remainingMinutes := int(remainingTime.Minutes())
+				return &APIError{
+					Status:  http.StatusConflict,
+					Code:    CodeRequestCooldown,
+					Message: fmt.Sprintf("Request was revoked. Please wait %d more minutes before resending.", remainingMinutes+1),
+					Details: map[string]interface{}{"remaining_cooldown_minutes": remainingMinutes + 1},
+				}
+			}
+			// Cooldown period has passed, allow new request by deleting the old revoked record
+			if err := db.Delete(&existing).Error; err != nil {
+				return &APIError{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "Failed to clear old request"}
+			}
+		}
+	}
+
+	// Create new join request
+	request := Request{
+		RideID: ride.ID,
+		UserID: user.FirebaseUID,
+		Status: "pending",
+	}
+
+	// Create the request and notify the leader in one transaction
+	tx := db.Begin()
+	if tx.Error != nil {
+		return &APIError{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "Failed to start transaction"}
+	}
+
+	if err := tx.Create(&request).Error; err != nil {
+		tx.Rollback()
+		return &APIError{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "Failed to create join request"}
+	}
+
+	// Send notification to ride leader
+	notificationTitle := "New Join Request"
+	notificationMessage := fmt.Sprintf("%s has requested to join your ride from %s to %s on %s at %s",
+		user.Name, ride.Origin, ride.Destination, ride.Date, ride.Time)
+
+	if err := createNotification(tx, leader.FirebaseUID, notificationTitle, notificationMessage, "join_request", ride); err != nil {
+		tx.Rollback()
+		return &APIError{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "Failed to create notification"}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return &APIError{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "Failed to commit transaction"}
+	}
+	wakeNotificationOutbox()
+	joinRequestsTotal.WithLabelValues("sent").Inc()
+
+	return nil
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"time"
 
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type Ride struct {
@@ -15,104 +19,256 @@ type Ride struct {
 	LeaderID    uint      `json:"leader_id"`
 	Origin      string    `json:"origin"`
 	Destination string    `json:"destination"`
-	Date        string    `json:"date"` // e.g. "2025-05-20"
-	Time        string    `json:"time"` // e.g. "15:30"
+	Date        string    `gorm:"index:idx_rides_route,priority:3" json:"date"` // e.g. "2025-05-20"
+	Time        string    `json:"time"`                                         // e.g. "15:30"
 	Seats       int       `json:"seats"`
 	SeatsFilled int       `json:"seats_filled"`
 	Price       float64   `json:"price"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+
+	// Canonical places of Origin and Destination, assigned when the ride is posted
+	OriginPlaceID      *uint `gorm:"index:idx_rides_route,priority:1" json:"origin_place_id"`
+	DestinationPlaceID *uint `gorm:"index:idx_rides_route,priority:2" json:"destination_place_id"`
+}
+
+// AddRideResponse is the created ride and how many saved searches it alerted
+type AddRideResponse struct {
+	Message           string `json:"message"`
+	Ride              Ride   `json:"ride"`
+	SavedSearchAlerts int    `json:"saved_search_alerts"`
+}
+
+// DeleteRideResponse reports a deleted ride and how many participants were notified
+type DeleteRideResponse struct {
+	Message              string `json:"message"`
+	ParticipantsNotified int    `json:"participants_notified"`
+	RideID               int    `json:"ride_id"`
+}
+
+// JoinRequestResponse is a pending join request as shown to the ride leader
+type JoinRequestResponse struct {
+	RequestID uint   `json:"request_id"`
+	Name      string `json:"name"`
+	Gender    string `json:"gender"`
+	Status    string `json:"status"`
+}
+
+// getRidesByIDs loads all rides for the given IDs in one query, keyed by ride ID
+func getRidesByIDs(ctx context.Context, ids []uint) (map[uint]Ride, error) {
+	rides := make(map[uint]Ride, len(ids))
+	if len(ids) == 0 {
+		return rides, nil
+	}
+
+	var rows []Ride
+	if err := DB.WithContext(ctx).Where("id IN ?", ids).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		rides[r.ID] = r
+	}
+	return rides, nil
+}
+
+// rideCursor is the keyset position of a ride in chronological order
+func rideCursor(r Ride) pageCursor {
+	return pageCursor{ID: r.ID, Keys: []string{r.Date, r.Time}}
+}
+
+// pageRidesQuery orders rides chronologically and applies the page cursor and limit
+func pageRidesQuery(q *gorm.DB, p Pagination) *gorm.DB {
+	q = q.Order("date, time, id")
+	if p.After != nil {
+		q = q.Where("(date, time, id) > (?, ?, ?)", p.After.Keys[0], p.After.Keys[1], p.After.ID)
+	}
+	return p.limitQuery(q)
+}
+
+// filterRidesByWhen narrows rides to ?when=upcoming (today onwards) or ?when=past
+func filterRidesByWhen(q *gorm.DB, when string) (*gorm.DB, error) {
+	today := time.Now().Format("2006-01-02")
+	switch when {
+	case "":
+		return q, nil
+	case "upcoming":
+		return q.Where("date >= ?", today), nil
+	case "past":
+		return q.Where("date < ?", today), nil
+	default:
+		return q, fmt.Errorf("when must be 'upcoming' or 'past'")
+	}
+}
+
+// countSameDayRequests counts the user's pending and approved join requests for rides on date.
+// A user with any may not lead a ride that day.
+func countSameDayRequests(db *gorm.DB, userID, date string) (int64, error) {
+	var count int64
+	err := db.Table("requests").
+		Joins("JOIN rides ON requests.ride_id = rides.id").
+		Where("requests.user_id = ? AND rides.date = ? AND requests.status IN ?",
+			userID, date, []string{"pending", "approved"}).
+		Count(&count).Error
+	return count, err
+}
+
+// countSameDayRides counts the rides the user leads on date. A user leading one may not ask to join another that day.
+func countSameDayRides(db *gorm.DB, leaderID uint, date string) (int64, error) {
+	var count int64
+	err := db.Model(&Ride{}).Where("leader_id = ? AND date = ?", leaderID, date).Count(&count).Error
+	return count, err
+}
+
+// insertRide resolves a new ride's places, saves it and alerts matching saved searches, using tx.
+// skip lists users not to alert: the leader, and anyone told about the ride another way.
+// It returns how many users were alerted.
+func insertRide(tx *gorm.DB, ride *Ride, skip ...string) (int, error) {
+	if err := assignRidePlaces(tx, ride); err != nil {
+		return 0, fmt.Errorf("resolving origin and destination: %w", err)
+	}
+	if err := tx.Create(ride).Error; err != nil {
+		return 0, err
+	}
+	return notifySavedSearches(tx, *ride, skip...)
 }
 
 // POST /ride
 func AddRide(c *gin.Context) {
+	ctx := c.Request.Context()
+	db := DB.WithContext(ctx)
+
 	var ride Ride
 
 	if err := c.ShouldBindJSON(&ride); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid input: "+err.Error())
 		return
 	}
 
 	userID, exists := c.Get("uid")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		abortWithError(c, http.StatusUnauthorized, CodeUnauthenticated, "User not authenticated")
 		return
 	}
 
 	// Convert Firebase UID (string) to find the user's ID
-	user, err := getUser(userID.(string))
+	user, err := getUser(ctx, userID.(string))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		abortWithError(c, http.StatusNotFound, CodeUserNotFound, "User not found")
 		return
 	}
 
 	ride.LeaderID = user.ID
 
 	if _, err := time.Parse("15:04", ride.Time); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid time format, expected HH:mm"})
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid time format, expected HH:mm")
 		return
 	}
 
 	if _, err := time.Parse("2006-01-02", ride.Date); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date format, expected YYYY-MM-DD"})
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid date format, expected YYYY-MM-DD")
+		return
+	}
+
+	if normalizePlaceName(ride.Origin) == "" || normalizePlaceName(ride.Destination) == "" {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Origin and destination are required")
 		return
 	}
 
 	// Check if user has sent any join requests on the same date
-	var existingRequestCount int64
-	if err := DB.Table("requests").
-		Joins("JOIN rides ON requests.ride_id = rides.id").
-		Where("requests.user_id = ? AND rides.date = ? AND requests.status IN ?",
-			userID.(string), ride.Date, []string{"pending", "approved"}).
-		Count(&existingRequestCount).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check existing requests"})
+	existingRequestCount, err := countSameDayRequests(db, userID.(string), ride.Date)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to check existing requests")
 		return
 	}
 
 	if existingRequestCount > 0 {
-		c.JSON(http.StatusConflict, gin.H{
-			"error": "You cannot create a ride and send join requests on the same day. You have already sent requests for rides on " + ride.Date,
+		abortWithAPIError(c, &APIError{
+			Status:  http.StatusConflict,
+			Code:    CodeSameDayConflict,
+			Message: "You cannot create a ride and send join requests on the same day. You have already sent requests for rides on " + ride.Date,
+			Details: map[string]interface{}{"date": ride.Date},
 		})
 		return
 	}
 
 	ride.SeatsFilled = 0
 
-	if err := DB.Create(&ride).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save ride: " + err.Error()})
+	// Save the ride and alert matching saved searches together, so an alert never points at a ride that failed to save
+	tx := db.Begin()
+	if tx.Error != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to start transaction")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Ride added successfully", "ride": ride})
+	alerted, err := insertRide(tx, &ride, user.FirebaseUID)
+	if err != nil {
+		tx.Rollback()
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Could not save ride: "+err.Error())
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to commit transaction")
+		return
+	}
+	wakeNotificationOutbox()
+	ridesCreatedTotal.Inc()
+	invalidateRideSearch(ride)
+
+	c.JSON(http.StatusOK, AddRideResponse{Message: "Ride added successfully", Ride: ride, SavedSearchAlerts: alerted})
 }
 
-// GET /user/rides/posted
+// GET /user/rides/posted?when=upcoming|past&limit=20&cursor=...
 func GetRidesPostedByUser(c *gin.Context) {
+	ctx := c.Request.Context()
+	db := DB.WithContext(ctx)
+
 	userID := c.MustGet("uid").(string)
 
-	user, err := getUser(userID)
+	page, err := parsePagination(c, "rides_posted", 2)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	user, err := getUser(ctx, userID)
+	if err != nil {
+		abortWithError(c, http.StatusNotFound, CodeUserNotFound, "User not found")
+		return
+	}
+
+	query, err := filterRidesByWhen(db.Where("leader_id = ?", user.ID), c.Query("when"))
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
 		return
 	}
 
 	var rides []Ride
-	if err := DB.Where("leader_id = ?", user.ID).Find(&rides).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch rides"})
+	if err := pageRidesQuery(query, page).Find(&rides).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to fetch rides")
 		return
 	}
 
-	c.JSON(http.StatusOK, rides)
+	rides, nextCursor := pageRows(page, rides, rideCursor)
+	respondList(c, page, rides, nextCursor)
 }
 
-// GET /user/rides/joined
+// GET /user/rides/joined?when=upcoming|past&limit=20&cursor=...
 func GetRidesJoinedByUser(c *gin.Context) {
+	db := DB.WithContext(c.Request.Context())
+
 	userID := c.MustGet("uid").(string)
 
+	page, err := parsePagination(c, "rides_joined", 2)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
 	// Find all rides where user is actually a participant (not just approved)
 	var participants []Participant
-	if err := DB.Where("user_id = ?", userID).Find(&participants).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch participant data"})
+	if err := db.Where("user_id = ?", userID).Find(&participants).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to fetch participant data")
 		return
 	}
 
@@ -121,98 +277,228 @@ func GetRidesJoinedByUser(c *gin.Context) {
 		rideIDs = append(rideIDs, p.RideID)
 	}
 
+	// Validate ?when even for a user who joined no rides
+	query, err := filterRidesByWhen(db.Where("id IN ?", rideIDs), c.Query("when"))
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
 	var rides []Ride
 	if len(rideIDs) > 0 {
-		if err := DB.Where("id IN ?", rideIDs).Find(&rides).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch rides"})
+		if err := pageRidesQuery(query, page).Find(&rides).Error; err != nil {
+			abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to fetch rides")
 			return
 		}
 	}
 
-	c.JSON(http.StatusOK, rides)
+	rides, nextCursor := pageRows(page, rides, rideCursor)
+	respondList(c, page, rides, nextCursor)
 }
 
-// GET /rides/filter?origin=College Campus&destination=City Airport&date=2025-06-10
+// GET /ride/filter?origin=College Campus&destination=City Airport&date=2025-06-10&sort=relevance&time=09:00&limit=20&cursor=...
 func FilterRides(c *gin.Context) {
+	ctx := c.Request.Context()
+	db := DB.WithContext(ctx)
+
 	origin := c.Query("origin")
 	destination := c.Query("destination")
 	date := c.Query("date")
 
+	sortBy := c.DefaultQuery("sort", sortByTime)
+	if !validRideSort(sortBy) {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "sort must be time, price, seats or relevance")
+		return
+	}
+
+	// Time order resumes from a ride's date and time; the other orders from an offset
+	cursorKeys := 2
+	if sortBy != sortByTime {
+		cursorKeys = 1
+	}
+	page, err := parsePagination(c, "ride_filter:"+sortBy, cursorKeys)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	requestedTime := c.Query("time")
+	if requestedTime != "" {
+		if _, err := time.Parse("15:04", requestedTime); err != nil {
+			abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid time format, expected HH:mm")
+			return
+		}
+	}
+
+	// Relevance is personal when the searcher is signed in
+	var gender string
+	if uid, ok := c.Get("uid"); ok && sortBy == sortByRelevance {
+		if user, err := getUser(ctx, uid.(string)); err == nil {
+			gender = user.Gender
+		}
+	}
+
+	// Rides are matched on the places the names resolve to. A name that matches no place can't
+	// match any ride either.
+	originID, err := findPlaceID(ctx, origin)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to look up origin")
+		return
+	}
+	destinationID, err := findPlaceID(ctx, destination)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to look up destination")
+		return
+	}
+	if originID == 0 || destinationID == 0 {
+		respondList(c, page, []Ride{}, "")
+		return
+	}
+
+	// ?time, like the searcher's gender, only changes the relevance order, so other orders share
+	// one cache entry whatever time was asked for
+	if sortBy != sortByRelevance {
+		requestedTime = ""
+	}
+	key := rideSearchKey{OriginPlaceID: originID, DestinationPlaceID: destinationID, Date: strings.TrimSpace(date)}
+	pageKey := strings.Join([]string{sortBy, requestedTime, strings.ToLower(gender), pageCacheKey(page, c.Query("cursor"))}, "|")
+	if resp, ok := cachedRideSearch(key, pageKey); ok {
+		respondWithETag(c, resp)
+		return
+	}
+	generation := rideSearchGeneration.Load()
+
 	var rides []Ride
 
+	// Time order pages in the database. The other orders rank the whole day's rides for the route,
+	// which is a short list, and page through them by offset.
+	ranked := sortBy != sortByTime
+
 	// Use SafeQuery to handle potential prepared statement conflicts9AM
-	err := SafeQuery(func() error {
-		return DB.Where("origin = ? AND destination = ? AND date = ?", origin, destination, date).Find(&rides).Error
+	err = SafeQuery(func() error {
+		query := db.Where("origin_place_id = ? AND destination_place_id = ? AND date = ?", key.OriginPlaceID, key.DestinationPlaceID, key.Date)
+		if ranked {
+			return query.Order("date, time, id").Find(&rides).Error
+		}
+		return pageRidesQuery(query, page).Find(&rides).Error
 	})
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch rides"})
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to fetch rides")
+		return
+	}
+
+	var nextCursor string
+	if ranked {
+		// Price and seat order need nothing beyond the rides; only relevance scores leaders and groups
+		var input rankingInput
+		if sortBy == sortByRelevance {
+			if input, err = loadRankingInput(ctx, rides, requestedTime, gender); err != nil {
+				abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to rank rides")
+				return
+			}
+		}
+		rankRides(rides, sortBy, input)
+		rides, nextCursor = pageRowsByOffset(page, rides, func(r Ride) uint { return r.ID })
+	} else {
+		rides, nextCursor = pageRows(page, rides, rideCursor)
+	}
+	body, err := json.Marshal(listBody(page, rides, nextCursor))
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to encode rides")
 		return
 	}
 
-	c.JSON(http.StatusOK, rides)
+	resp := newCachedResponse(body)
+	cacheRideSearch(key, pageKey, resp, generation)
+	respondWithETag(c, resp)
 }
 
-// GET /rides/:rideID/requests
+// GET /rides/:rideID/requests?limit=20&cursor=... - Pending join requests for the leader's ride, oldest first
 func GetJoinRequestsForRide(c *gin.Context) {
+	ctx := c.Request.Context()
+	db := DB.WithContext(ctx)
+
 	rideIDParam := c.Param("rideID")
 	rideID, err := strconv.Atoi(rideIDParam)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ride ID"})
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid ride ID")
+		return
+	}
+
+	page, err := parsePagination(c, "join_requests", 0)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
 		return
 	}
 
 	userID := c.MustGet("uid").(string)
 
 	var ride Ride
-	if err := DB.First(&ride, "id = ?", rideID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Ride not found"})
+	if err := db.First(&ride, "id = ?", rideID).Error; err != nil {
+		abortWithError(c, http.StatusNotFound, CodeRideNotFound, "Ride not found")
 		return
 	}
 
-	user, err := getUser(userID)
+	user, err := getUser(ctx, userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		abortWithError(c, http.StatusNotFound, CodeUserNotFound, "User not found")
 		return
 	}
 
 	if ride.LeaderID != user.ID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You are not the leader of this ride"})
+		abortWithError(c, http.StatusForbidden, CodeNotRideLeader, "You are not the leader of this ride")
 		return
 	}
 
 	var requests []Request
-	if err := DB.Where("ride_id = ? AND status = ?", rideID, "pending").Find(&requests).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch join requests"})
+	query := page.limitQuery(page.afterID(db.Where("ride_id = ? AND status = ?", rideID, "pending"), "id")).Order("id")
+	if err := query.Find(&requests).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to fetch join requests")
+		return
+	}
+	requests, nextCursor := pageRows(page, requests, func(r Request) pageCursor {
+		return pageCursor{ID: r.ID}
+	})
+
+	uids := make([]string, 0, len(requests))
+	for _, r := range requests {
+		uids = append(uids, r.UserID)
+	}
+
+	users, err := getUsersByFirebaseUIDs(ctx, uids)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to fetch requesting users")
 		return
 	}
 
 	// Build response with request details
-	var response []map[string]interface{}
+	var response []JoinRequestResponse
 	for _, r := range requests {
-		user, err := getUser(r.UserID)
-		if err != nil {
+		user, ok := users[r.UserID]
+		if !ok {
 			continue // skip if user doesn't exist
 		}
 
-		entry := map[string]interface{}{
-			"request_id": r.ID,
-			"name":       user.Name,
-			"gender":     user.Gender,
-			"status":     r.Status,
-		}
-		response = append(response, entry)
+		response = append(response, JoinRequestResponse{
+			RequestID: r.ID,
+			Name:      user.Name,
+			Gender:    user.Gender,
+			Status:    r.Status,
+		})
 	}
 
-	c.JSON(http.StatusOK, response)
+	respondList(c, page, response, nextCursor)
 }
 
 // DELETE /ride/:rideID - Leader deletes their own ride
 func DeleteRide(c *gin.Context) {
+	ctx := c.Request.Context()
+	db := DB.WithContext(ctx)
+
 	rideIDParam := c.Param("rideID")
 	rideID, err := strconv.Atoi(rideIDParam)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ride ID"})
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid ride ID")
 		return
 	}
 
@@ -220,92 +506,88 @@ func DeleteRide(c *gin.Context) {
 
 	// Get the ride to be deleted
 	var ride Ride
-	if err := DB.First(&ride, "id = ?", rideID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Ride not found"})
+	if err := db.First(&ride, "id = ?", rideID).Error; err != nil {
+		abortWithError(c, http.StatusNotFound, CodeRideNotFound, "Ride not found")
 		return
 	}
 
 	// Get current user to verify they are the leader
-	user, err := getUser(userID)
+	user, err := getUser(ctx, userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		abortWithError(c, http.StatusNotFound, CodeUserNotFound, "User not found")
 		return
 	}
 
 	// Check if the current user is the leader of this ride
 	if ride.LeaderID != user.ID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You are not the leader of this ride"})
+		abortWithError(c, http.StatusForbidden, CodeNotRideLeader, "You are not the leader of this ride")
 		return
 	}
 
 	// Get all participants to notify them
 	var participants []Participant
-	if err := DB.Where("ride_id = ?", rideID).Find(&participants).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch participants"})
+	if err := db.Where("ride_id = ?", rideID).Find(&participants).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to fetch participants")
 		return
 	}
 
 	// Start a transaction to ensure data consistency
-	tx := DB.Begin()
+	tx := db.Begin()
 	if tx.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to start transaction")
 		return
 	}
 
-	// Delete all related data in the correct order to avoid foreign key constraints
-
-	// 1. Delete all notifications related to this ride
-	if err := tx.Where("ride_id = ?", rideID).Delete(&Notification{}).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete ride notifications"})
-		return
-	}
+	// Delete all related data in the correct order to avoid foreign key constraints.
+	// Notifications are kept: they carry a snapshot of the ride details.
 
-	// 2. Delete all participants
+	// 1. Delete all participants
 	if err := tx.Where("ride_id = ?", rideID).Delete(&Participant{}).Error; err != nil {
 		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete participants"})
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to delete participants")
 		return
 	}
 
-	// 3. Delete all join requests
+	// 2. Delete all join requests
 	if err := tx.Where("ride_id = ?", rideID).Delete(&Request{}).Error; err != nil {
 		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete join requests"})
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to delete join requests")
 		return
 	}
 
-	// 4. Finally delete the ride itself
+	// 3. Delete the ride itself
 	if err := tx.Delete(&ride).Error; err != nil {
 		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete ride"})
-		return
-	}
-
-	// Commit the transaction
-	if err := tx.Commit().Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to delete ride")
 		return
 	}
 
-	// Send notifications to all participants about the ride cancellation
+	// 4. Notify all participants about the ride cancellation
 	title := "Ride Cancelled by Leader"
 	message := fmt.Sprintf("The ride from %s to %s on %s at %s has been cancelled by the leader %s",
 		ride.Origin, ride.Destination, ride.Date, ride.Time, user.Name)
 
-	notificationCount := 0
 	for _, participant := range participants {
-		if err := createNotification(participant.UserID, title, message, "ride_cancelled", uint(rideID)); err != nil {
-			// Log error but don't fail the request
-			fmt.Printf("Failed to create notification for participant %s: %v\n", participant.UserID, err)
-		} else {
-			notificationCount++
+		if err := createNotification(tx, participant.UserID, title, message, "ride_cancelled", ride); err != nil {
+			tx.Rollback()
+			abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to create notifications")
+			return
 		}
 	}
+	notificationCount := len(participants)
+
+	// Commit the transaction
+	if err := tx.Commit().Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to commit transaction")
+		return
+	}
+	wakeNotificationOutbox()
+	cancellationsTotal.WithLabelValues("ride").Inc()
+	invalidateRideSearch(ride)
 
-	c.JSON(http.StatusOK, gin.H{
-		"message":               fmt.Sprintf("Ride deleted successfully. %d participants have been notified.", notificationCount),
-		"participants_notified": notificationCount,
-		"ride_id":               rideID,
+	c.JSON(http.StatusOK, DeleteRideResponse{
+		Message:              fmt.Sprintf("Ride deleted successfully. %d participants have been notified.", notificationCount),
+		ParticipantsNotified: notificationCount,
+		RideID:               rideID,
 	})
 }
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiVersion is a route table mounted under a URL prefix. Once a version ships its routes and
+// response shapes are frozen; breaking changes go in a new version registered alongside it,
+// reusing the previous version's handlers for everything that did not change.
+type apiVersion struct {
+	Prefix   string
+	Register func(api *gin.RouterGroup)
+}
+
+// MessageResponse is the body of endpoints that only report what they did
+type MessageResponse struct {
+	Message string `json:"message"`
+}
+
+var apiVersions = []apiVersion{
+	{Prefix: "/v1", Register: registerV1Routes},
+}
+
+// mount registers the version's routes on r under its prefix
+func (v apiVersion) mount(r gin.IRouter) {
+	group := r.Group(v.Prefix)
+
+	// OpenAPI document describing every route of the version
+	group.GET("/openapi.json", OpenAPIHandler(v))
+	v.Register(group)
+}
+
+// The unversioned routes are retired in favour of /v1
+var (
+	legacyRoutesDeprecatedAt = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+	legacyRoutesSunset       = time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC)
+)
+
+// mountLegacyRoutes registers the v1 routes unversioned, marked as deprecated in favour of /v1
+func mountLegacyRoutes(r gin.IRouter) {
+	legacy := r.Group("/")
+	legacy.Use(Deprecated(legacyRoutesDeprecatedAt, legacyRoutesSunset, "/v1"))
+	legacy.GET("/openapi.json", OpenAPIHandler(apiVersions[0]))
+	registerV1Routes(legacy)
+}
+
+// Deprecated marks routes as being retired: Deprecation (RFC 9745) says since when, Sunset (RFC 8594)
+// says when they stop working, and Link points at the same path under successorPrefix.
+// Use it on a group, or per route when a single v1 endpoint is superseded: api.GET("/x", Deprecated(...), X)
+func Deprecated(since, sunset time.Time, successorPrefix string) gin.HandlerFunc {
+	deprecation := fmt.Sprintf("@%d", since.Unix())
+	sunsetDate := sunset.UTC().Format(http.TimeFormat)
+
+	return func(c *gin.Context) {
+		c.Header("Deprecation", deprecation)
+		c.Header("Sunset", sunsetDate)
+		if successorPrefix != "" {
+			c.Header("Link", fmt.Sprintf("<%s%s>; rel=\"successor-version\"", successorPrefix, c.Request.URL.Path))
+		}
+		c.Next()
+	}
+}
+
+// registerV1Routes registers the v1 API
+func registerV1Routes(api *gin.RouterGroup) {
+	// Public route example
+	api.GET("/public", func(c *gin.Context) {
+		c.JSON(http.StatusOK, MessageResponse{Message: "This is a public endpoint"})
+	})
+
+	// Ping endpoint for testing connectivity
+	api.GET("/ping", func(c *gin.Context) {
+		c.JSON(http.StatusOK, MessageResponse{Message: "pong"})
+	})
+
+	// Protected routes (require authentication)
+	protected := api.Group("/")
+	// The "ip" budget runs first, so a flood of bad tokens is turned away before it costs a
+	// verification each
+	protected.Use(RateLimited("ip"), FirebaseAuthMiddleware(), RateLimited("user"))
+
+	// User APIs
+	protected.GET("/user", GetCurrentUser)                                        // GET /user - Get current user profile
+	protected.PUT("/user", UpdateCurrentUser)                                     // PUT /user - Update current user profile
+	protected.POST("/user", CreateUser)                                           // POST /user
+	protected.GET("/user/:userID", GetUserBasic)                                  // GET /user/:userID
+	protected.GET("/user/rides/posted", GetRidesPostedByUser)                     // GET /user/rides/posted
+	protected.GET("/user/rides/joined", GetRidesJoinedByUser)                     // GET /user/rides/joined
+	protected.GET("/user/privileges", GetUserPrivileges)                          // GET /user/privileges
+	protected.GET("/user/requests", GetUserSentRequests)                          // GET /user/requests
+	protected.DELETE("/user/clear-involvement/:date", ClearInvolvementForDate)    // DELETE /user/clear-involvement/:date
+	protected.GET("/user/notifications", GetUserNotifications)                    // GET /user/notifications
+	protected.GET("/user/notifications/unread-count", GetUnreadNotificationCount) // GET /user/notifications/unread-count
+	protected.DELETE("/user/cancel-ride/:rideID", CancelRideParticipation)        // DELETE /user/cancel-ride/:rideID (unified)
+
+	// Ride APIs
+	protected.POST("/ride", RateLimited("add_ride"), AddRide)                                 // POST /ride
+	protected.DELETE("/ride/:rideID", DeleteRide)                                             // DELETE /ride/:rideID - Leader deletes their ride
+	protected.GET("/ride/:rideID/leader", GetRideLeader)                                      // GET /ride/:rideID/leader
+	api.GET("/ride/filter", OptionalFirebaseAuth(), RateLimited("filter_rides"), FilterRides) // GET /ride/filter?origin=College Campus&destination=City Airport&date=2025-06-10
+	protected.POST("/ride/match", RateLimited("match_rides"), MatchRides)                     // POST /ride/match
+	protected.GET("/ride/:rideID/requests", GetJoinRequestsForRide)                           // GET /ride/:rideID/requests
+	protected.POST("/ride/:rideID/join", RateLimited("join_request"), SendJoinRequest)        // POST /ride/:rideID/join
+	protected.DELETE("/ride/:rideID/cancel-request", CancelJoinRequest)                       // DELETE /ride/:rideID/cancel-request
+	protected.POST("/ride/:rideID/join-ride", JoinRideWithPrivilege)                          // POST /ride/:rideID/join-ride
+
+	// Place APIs
+	api.GET("/places/autocomplete", RateLimited("places"), AutocompletePlaces) // GET /places/autocomplete?q=coll&limit=10
+
+	// Trip request APIs (passengers looking for a ride)
+	protected.POST("/trip-request", RateLimited("trip_request"), CreateTripRequest)                        // POST /trip-request
+	protected.GET("/trip-requests", GetOpenTripRequests)                                                   // GET /trip-requests?origin=College Campus&destination=City Airport&date=2025-06-10
+	protected.GET("/user/trip-requests", GetUserTripRequests)                                              // GET /user/trip-requests
+	protected.DELETE("/trip-request/:tripRequestID", CancelTripRequest)                                    // DELETE /trip-request/:tripRequestID
+	protected.POST("/trip-request/:tripRequestID/offer", RateLimited("add_ride"), OfferRideForTripRequest) // POST /trip-request/:tripRequestID/offer
+
+	// Participant Management APIs (Leaders only)
+	protected.GET("/ride/:rideID/participants", GetRideParticipants)                // GET /ride/:rideID/participants
+	protected.DELETE("/ride/:rideID/participant/:participantID", RemoveParticipant) // DELETE /ride/:rideID/participant/:participantID
+	protected.POST("/ride/:rideID/approve/:requestID", ApproveJoinRequest)          // POST /ride/:rideID/approve/:requestID
+	protected.POST("/ride/:rideID/reject/:requestID", RejectJoinRequest)            // POST /ride/:rideID/reject/:requestID
+
+	// Notification APIs
+	protected.POST("/notification/:notificationID/read", MarkNotificationAsRead)   // POST /notification/:notificationID/read
+	protected.DELETE("/notification/:notificationID", DeleteNotification)          // DELETE /notification/:notificationID
+	protected.POST("/user/notifications/read-all", MarkAllNotificationsAsRead)     // POST /user/notifications/read-all?type=&ride_id=
+	protected.DELETE("/user/notifications", DeleteAllNotifications)                // DELETE /user/notifications?read_only=true
+	protected.GET("/user/notification-preferences", GetNotificationPreferences)    // GET /user/notification-preferences
+	protected.PUT("/user/notification-preferences", UpdateNotificationPreferences) // PUT /user/notification-preferences
+
+	// Saved search APIs
+	protected.POST("/user/saved-searches", SaveSearch)                    // POST /user/saved-searches
+	protected.GET("/user/saved-searches", GetSavedSearches)               // GET /user/saved-searches
+	protected.DELETE("/user/saved-searches/:searchID", DeleteSavedSearch) // DELETE /user/saved-searches/:searchID
+
+	// Push device APIs
+	protected.POST("/user/devices", RegisterDevice)           // POST /user/devices
+	protected.GET("/user/devices", GetUserDevices)            // GET /user/devices
+	protected.PUT("/user/devices/:deviceID", UpdateDevice)    // PUT /user/devices/:deviceID
+	protected.DELETE("/user/devices/:deviceID", DeleteDevice) // DELETE /user/devices/:deviceID
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLegacyRoutesAreDeprecated(t *testing.T) {
+	r := gin.New()
+	r.Use(ErrorHandler())
+	for _, v := range apiVersions {
+		v.mount(r)
+	}
+	mountLegacyRoutes(r)
+
+	deprecation := "@" + strconv.FormatInt(legacyRoutesDeprecatedAt.Unix(), 10)
+	tests := []struct {
+		path       string
+		wantStatus int
+		deprecated bool
+	}{
+		{"/ping", http.StatusOK, true},
+		{"/openapi.json", http.StatusOK, true},
+		{"/user", http.StatusUnauthorized, true}, // Errors are marked too
+		{"/v1/ping", http.StatusOK, false},
+		{"/v1/user", http.StatusUnauthorized, false},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.wantStatus {
+			t.Fatalf("GET %s = %d, want %d", tt.path, w.Code, tt.wantStatus)
+		}
+
+		h := w.Header()
+		if !tt.deprecated {
+			if h.Get("Deprecation") != "" || h.Get("Sunset") != "" || h.Get("Link") != "" {
+				t.Errorf("GET %s carries deprecation headers: %v", tt.path, h)
+			}
+			continue
+		}
+		if h.Get("Deprecation") != deprecation || h.Get("Sunset") != "Fri, 30 Apr 2027 00:00:00 GMT" {
+			t.Errorf("GET %s: Deprecation %q and Sunset %q, want %q and the sunset date", tt.path, h.Get("Deprecation"), h.Get("Sunset"), deprecation)
+		}
+		if want := `</v1` + tt.path + `>; rel="successor-version"`; h.Get("Link") != want {
+			t.Errorf("GET %s: Link = %q, want %q", tt.path, h.Get("Link"), want)
+		}
+	}
+}
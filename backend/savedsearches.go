@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SavedSearch is a ride search a user wants to hear about. When a ride matching it is posted,
+// the user gets a "saved_search_match" notification. Searches expire once their date has passed.
+//
+// Searches don't create places. A search naming a place nobody has posted a ride from or to
+// waits with no place ID until a ride creates one its name resolves to (see resolvePlace).
+type SavedSearch struct {
+	ID                    uint      `gorm:"primaryKey" json:"id"`
+	UserID                string    `gorm:"index;not null" json:"-"` // Firebase UID
+	Origin                string    `gorm:"not null" json:"origin"`  // As entered
+	Destination           string    `gorm:"not null" json:"destination"`
+	OriginNormalized      string    `gorm:"type:varchar(200);not null;default:''" json:"-"` // See normalizePlaceName
+	DestinationNormalized string    `gorm:"type:varchar(200);not null;default:''" json:"-"`
+	OriginPlaceID         *uint     `gorm:"index:idx_saved_searches_route,priority:1" json:"origin_place_id"` // nil until the place exists
+	DestinationPlaceID    *uint     `gorm:"index:idx_saved_searches_route,priority:2" json:"destination_place_id"`
+	Date                  string    `gorm:"index:idx_saved_searches_route,priority:3;not null" json:"date"` // e.g. "2025-06-10"
+	TimeFrom              string    `gorm:"type:varchar(5);not null;default:''" json:"time_from"`           // HH:mm, empty for any time
+	TimeTo                string    `gorm:"type:varchar(5);not null;default:''" json:"time_to"`             // HH:mm, empty for any time
+	MaxPrice              *float64  `json:"max_price"`                                                      // nil for any price
+	CreatedAt             time.Time `json:"created_at"`
+}
+
+// SavedSearchAlert records that a user was told about a ride, so a ride matching several of
+// their saved searches is only announced once
+type SavedSearchAlert struct {
+	ID            uint   `gorm:"primaryKey"`
+	UserID        string `gorm:"uniqueIndex:idx_saved_search_alerts_user_ride;not null"`
+	RideID        uint   `gorm:"uniqueIndex:idx_saved_search_alerts_user_ride;not null"`
+	SavedSearchID uint   `gorm:"index;not null"`
+	CreatedAt     time.Time
+}
+
+// Request body struct for saving a search
+type SaveSearchRequest struct {
+	Origin      string   `json:"origin" binding:"required"`
+	Destination string   `json:"destination" binding:"required"`
+	Date        string   `json:"date" binding:"required"` // YYYY-MM-DD
+	TimeFrom    string   `json:"time_from"`               // HH:mm, optional
+	TimeTo      string   `json:"time_to"`                 // HH:mm, optional
+	MaxPrice    *float64 `json:"max_price"`
+}
+
+const maxSavedSearchesPerUser = 20
+
+// validate checks the date, time window and price of a search to be saved
+func (r SaveSearchRequest) validate() error {
+	if err := validateTripWindow(r.Date, r.TimeFrom, r.TimeTo); err != nil {
+		return err
+	}
+	if r.MaxPrice != nil && *r.MaxPrice < 0 {
+		return fmt.Errorf("max_price cannot be negative")
+	}
+	return nil
+}
+
+// validateTripWindow checks a date that is today or later and an optional HH:mm window on it
+func validateTripWindow(date, timeFrom, timeTo string) error {
+	d, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return fmt.Errorf("Invalid date format, expected YYYY-MM-DD")
+	}
+	if d.Format("2006-01-02") < time.Now().Format("2006-01-02") {
+		return fmt.Errorf("date cannot be in the past")
+	}
+
+	for _, t := range []string{timeFrom, timeTo} {
+		if t == "" {
+			continue
+		}
+		if _, err := time.Parse("15:04", t); err != nil {
+			return fmt.Errorf("Invalid time format, expected HH:mm")
+		}
+	}
+	// Ride times are validated as zero-padded HH:mm, so they compare as strings
+	if timeFrom != "" && timeTo != "" && timeFrom > timeTo {
+		return fmt.Errorf("time_from must not be after time_to")
+	}
+	return nil
+}
+
+// inTimeWindow reports whether an HH:mm time falls in a window whose ends may be empty
+func inTimeWindow(t, timeFrom, timeTo string) bool {
+	return (timeFrom == "" || timeFrom <= t) && (timeTo == "" || t <= timeTo)
+}
+
+// notifySavedSearches notifies the users whose saved searches match a newly posted ride, using tx.
+// Call it in the transaction that creates the ride. Users in skip, such as the leader, are not
+// notified. It returns how many users were notified.
+func notifySavedSearches(tx *gorm.DB, ride Ride, skip ...string) (int, error) {
+	if ride.OriginPlaceID == nil || ride.DestinationPlaceID == nil {
+		return 0, nil
+	}
+
+	query := tx.Where("origin_place_id = ? AND destination_place_id = ? AND date = ?",
+		*ride.OriginPlaceID, *ride.DestinationPlaceID, ride.Date).
+		Where("time_from = '' OR time_from <= ?", ride.Time).
+		Where("time_to = '' OR time_to >= ?", ride.Time).
+		Where("max_price IS NULL OR max_price >= ?", ride.Price)
+	if len(skip) > 0 {
+		query = query.Where("user_id NOT IN ?", skip)
+	}
+
+	var searches []SavedSearch
+	if err := query.Order("id").Find(&searches).Error; err != nil {
+		return 0, err
+	}
+
+	title := "New Ride Matches Your Saved Search"
+	message := fmt.Sprintf("A ride from %s to %s on %s at %s was just posted with %d seats at %.2f",
+		ride.Origin, ride.Destination, ride.Date, ride.Time, ride.Seats-ride.SeatsFilled, ride.Price)
+
+	notified := 0
+	for _, search := range searches {
+		alert := SavedSearchAlert{UserID: search.UserID, RideID: ride.ID, SavedSearchID: search.ID}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&alert)
+		if result.Error != nil {
+			return notified, result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue // Already notified through another of the user's searches
+		}
+
+		if err := createNotification(tx, search.UserID, title, message, "saved_search_match", ride); err != nil {
+			return notified, err
+		}
+		notified++
+	}
+	return notified, nil
+}
+
+// attachWaitingSavedSearches gives a newly created place to the upcoming saved searches waiting
+// for a place whose names resolve to it
+func attachWaitingSavedSearches(db *gorm.DB, place Place) error {
+	today := time.Now().Format("2006-01-02")
+	return attachWaitingRows(db.Model(&SavedSearch{}).Where("date >= ?", today), place)
+}
+
+// purgeExpiredSavedSearches deletes saved searches for dates before today, along with the alerts
+// of expired and deleted searches
+func purgeExpiredSavedSearches(ctx context.Context) (int64, error) {
+	db := DB.WithContext(ctx)
+	today := time.Now().Format("2006-01-02")
+
+	result := db.Where("date < ?", today).Delete(&SavedSearch{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if err := db.Where("saved_search_id NOT IN (?)", db.Model(&SavedSearch{}).Select("id")).
+		Delete(&SavedSearchAlert{}).Error; err != nil {
+		return result.RowsAffected, err
+	}
+	return result.RowsAffected, nil
+}
+
+// StartSavedSearchExpiry deletes saved searches whose date has passed every interval
+func StartSavedSearchExpiry(interval time.Duration) {
+	runWorker(func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			// Let a run in progress finish even if shutdown starts
+			expired, err := purgeExpiredSavedSearches(context.WithoutCancel(ctx))
+			if err != nil {
+				slog.Error("❌ Failed to expire saved searches", "error", err)
+			} else if expired > 0 {
+				slog.Info("🧹 Expired saved searches", "count", expired)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// POST /user/saved-searches - Save a search and get notified when a matching ride is posted
+func SaveSearch(c *gin.Context) {
+	db := DB.WithContext(c.Request.Context())
+
+	userID := c.MustGet("uid").(string)
+
+	var req SaveSearchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request data")
+		return
+	}
+	if err := req.validate(); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	if normalizePlaceName(req.Origin) == "" || normalizePlaceName(req.Destination) == "" {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Origin and destination are required")
+		return
+	}
+
+	// Searches look places up the same way rides do, but a place nobody has posted a ride from or
+	// to yet is left for the first such ride to create
+	search := SavedSearch{
+		UserID:                userID,
+		Origin:                displayPlaceName(req.Origin),
+		Destination:           displayPlaceName(req.Destination),
+		OriginNormalized:      normalizePlaceName(req.Origin),
+		DestinationNormalized: normalizePlaceName(req.Destination),
+		Date:                  req.Date,
+		TimeFrom:              req.TimeFrom,
+		TimeTo:                req.TimeTo,
+		MaxPrice:              req.MaxPrice,
+	}
+	origin, err := findPlace(db, req.Origin)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to resolve origin and destination")
+		return
+	}
+	destination, err := findPlace(db, req.Destination)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to resolve origin and destination")
+		return
+	}
+
+	// Saving the same search twice returns the one already saved. Ends are the same when they
+	// resolve to the same place, or have none yet and are spelled the same.
+	existing := db.Where("user_id = ? AND date = ? AND time_from = ? AND time_to = ?", userID, req.Date, req.TimeFrom, req.TimeTo)
+	if origin != nil {
+		search.OriginPlaceID = &origin.ID
+		existing = existing.Where("origin_place_id = ?", origin.ID)
+	} else {
+		existing = existing.Where("origin_place_id IS NULL AND origin_normalized = ?", search.OriginNormalized)
+	}
+	if destination != nil {
+		search.DestinationPlaceID = &destination.ID
+		existing = existing.Where("destination_place_id = ?", destination.ID)
+	} else {
+		existing = existing.Where("destination_place_id IS NULL AND destination_normalized = ?", search.DestinationNormalized)
+	}
+	if req.MaxPrice == nil {
+		existing = existing.Where("max_price IS NULL")
+	} else {
+		existing = existing.Where("max_price = ?", *req.MaxPrice)
+	}
+	var saved SavedSearch
+	if err := existing.Limit(1).Find(&saved).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to check saved searches")
+		return
+	}
+	if saved.ID != 0 {
+		c.JSON(http.StatusOK, saved)
+		return
+	}
+
+	var count int64
+	if err := db.Model(&SavedSearch{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to check saved searches")
+		return
+	}
+	if count >= maxSavedSearchesPerUser {
+		abortWithAPIError(c, &APIError{
+			Status:  http.StatusConflict,
+			Code:    CodeSavedSearchLimit,
+			Message: fmt.Sprintf("You can have at most %d saved searches. Delete one to save another.", maxSavedSearchesPerUser),
+			Details: map[string]interface{}{"limit": maxSavedSearchesPerUser},
+		})
+		return
+	}
+
+	if err := db.Create(&search).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to save search")
+		return
+	}
+
+	c.JSON(http.StatusOK, search)
+}
+
+// GET /user/saved-searches?limit=20&cursor=... - List the user's saved searches, soonest first
+func GetSavedSearches(c *gin.Context) {
+	db := DB.WithContext(c.Request.Context())
+
+	userID := c.MustGet("uid").(string)
+
+	page, err := parsePagination(c, "saved_searches", 2)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	query := db.Where("user_id = ?", userID).Order("date, time_from, id")
+	if page.After != nil {
+		query = query.Where("(date, time_from, id) > (?, ?, ?)", page.After.Keys[0], page.After.Keys[1], page.After.ID)
+	}
+
+	searches := []SavedSearch{}
+	if err := page.limitQuery(query).Find(&searches).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to fetch saved searches")
+		return
+	}
+	searches, nextCursor := pageRows(page, searches, func(s SavedSearch) pageCursor {
+		return pageCursor{ID: s.ID, Keys: []string{s.Date, s.TimeFrom}}
+	})
+
+	respondList(c, page, searches, nextCursor)
+}
+
+// DELETE /user/saved-searches/:searchID - Stop alerts for a saved search
+func DeleteSavedSearch(c *gin.Context) {
+	db := DB.WithContext(c.Request.Context())
+
+	searchID := c.Param("searchID")
+	userID := c.MustGet("uid").(string)
+
+	result := db.Where("id = ? AND user_id = ?", searchID, userID).Delete(&SavedSearch{})
+	if result.Error != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to delete saved search")
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		abortWithError(c, http.StatusNotFound, CodeSavedSearchNotFound, "Saved search not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, MessageResponse{Message: "Saved search deleted"})
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func saveSearch(t *testing.T, user *User, req SaveSearchRequest) SavedSearch {
+	t.Helper()
+
+	var search SavedSearch
+	w := serve(t, SaveSearch, "POST", "/user/saved-searches", "/user/saved-searches", user.FirebaseUID, req)
+	decodeJSON(t, w, http.StatusOK, &search)
+	return search
+}
+
+// postRide saves a ride the way AddRide does and returns how many users were alerted
+func postRide(t *testing.T, leader *User, ride Ride) int {
+	t.Helper()
+
+	ride.LeaderID = leader.ID
+	alerted, err := insertRide(DB, &ride, leader.FirebaseUID)
+	if err != nil {
+		t.Fatalf("post ride: %v", err)
+	}
+	return alerted
+}
+
+func TestSaveSearchDoesNotCreatePlaces(t *testing.T) {
+	db := newTestDB(t)
+	rider := seedUser(t, db)
+
+	search := saveSearch(t, rider, SaveSearchRequest{Origin: "Lake View", Destination: "Airport", Date: daysFromNow(1)})
+	if search.OriginPlaceID != nil || search.DestinationPlaceID != nil {
+		t.Fatalf("search = %+v, want no places yet", search)
+	}
+
+	var places int64
+	db.Model(&Place{}).Count(&places)
+	if places != 0 {
+		t.Fatalf("saving a search created %d places", places)
+	}
+}
+
+func TestSavedSearchMatching(t *testing.T) {
+	db := newTestDB(t)
+	leader, rider, other := seedUser(t, db), seedUser(t, db), seedUser(t, db)
+	date := daysFromNow(1)
+	maxPrice := 150.0
+
+	// Saved before anyone posted on the route, one of them misspelt
+	saveSearch(t, rider, SaveSearchRequest{Origin: "College Campus", Destination: "Airport", Date: date, TimeFrom: "08:00", TimeTo: "10:00", MaxPrice: &maxPrice})
+	saveSearch(t, rider, SaveSearchRequest{Origin: "college campus", Destination: "airport", Date: date}) // Overlaps the first
+	saveSearch(t, other, SaveSearchRequest{Origin: "Colege Campus", Destination: "Airport", Date: date})
+	saveSearch(t, leader, SaveSearchRequest{Origin: "College Campus", Destination: "Airport", Date: date})
+
+	tests := []struct {
+		name string
+		ride Ride
+		want int
+	}{
+		{"matches every search once per user", Ride{Origin: "College Campus", Destination: "Airport", Date: date, Time: "09:00", Seats: 3, Price: 100}, 2},
+		{"outside the time window", Ride{Origin: "College Campus", Destination: "Airport", Date: date, Time: "11:00", Seats: 3, Price: 100}, 2},
+		{"over the price", Ride{Origin: "College Campus", Destination: "Airport", Date: date, Time: "09:30", Seats: 3, Price: 200}, 2},
+		{"another date", Ride{Origin: "College Campus", Destination: "Airport", Date: daysFromNow(2), Time: "09:00", Seats: 3, Price: 100}, 0},
+		{"another route", Ride{Origin: "College Campus", Destination: "Station", Date: date, Time: "09:00", Seats: 3, Price: 100}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := postRide(t, leader, tt.ride); got != tt.want {
+				t.Fatalf("alerted %d users, want %d", got, tt.want)
+			}
+		})
+	}
+
+	// Both users were alerted about each matching ride, the rider through the open-ended search
+	var alerts []SavedSearchAlert
+	db.Order("ride_id, user_id").Find(&alerts)
+	if len(alerts) != 6 {
+		t.Fatalf("%d alerts, want 6", len(alerts))
+	}
+	var leaderAlerts int64
+	db.Model(&SavedSearchAlert{}).Where("user_id = ?", leader.FirebaseUID).Count(&leaderAlerts)
+	if leaderAlerts != 0 {
+		t.Fatal("the leader was alerted about their own ride")
+	}
+
+	var queued int64
+	db.Model(&NotificationOutbox{}).Where("type = ?", "saved_search_match").Count(&queued)
+	if queued != 6 {
+		t.Fatalf("%d notifications queued, want 6", queued)
+	}
+}
+
+func TestSaveSearchDedupe(t *testing.T) {
+	db := newTestDB(t)
+	leader, rider := seedUser(t, db), seedUser(t, db)
+	date := daysFromNow(1)
+
+	// Without places, the same spelling is the same search
+	first := saveSearch(t, rider, SaveSearchRequest{Origin: "Lake View", Destination: "Airport", Date: date})
+	if again := saveSearch(t, rider, SaveSearchRequest{Origin: "lake-view", Destination: "AIRPORT", Date: date}); again.ID != first.ID {
+		t.Fatal("the same search was saved twice")
+	}
+
+	// Once a ride creates the places, any spelling resolving to them is the same search
+	postRide(t, leader, Ride{Origin: "Lake View", Destination: "Airport", Date: daysFromNow(3), Time: "09:00", Seats: 3, Price: 100})
+	if again := saveSearch(t, rider, SaveSearchRequest{Origin: "Lake Views", Destination: "Airport", Date: date}); again.ID != first.ID {
+		t.Fatal("a misspelling of a saved search was saved again")
+	}
+
+	// Any other field makes it another search
+	if other := saveSearch(t, rider, SaveSearchRequest{Origin: "Lake View", Destination: "Airport", Date: date, TimeFrom: "08:00"}); other.ID == first.ID {
+		t.Fatal("a search with a time window was taken for the one without")
+	}
+
+	var count int64
+	db.Model(&SavedSearch{}).Where("user_id = ?", rider.FirebaseUID).Count(&count)
+	if count != 2 {
+		t.Fatalf("%d saved searches, want 2", count)
+	}
+}
+
+func TestPurgeExpiredSavedSearches(t *testing.T) {
+	db := newTestDB(t)
+	rider := seedUser(t, db)
+
+	searches := []SavedSearch{
+		{UserID: rider.FirebaseUID, Origin: "A", Destination: "B", Date: daysFromNow(-1)}, // Expired
+		{UserID: rider.FirebaseUID, Origin: "A", Destination: "B", Date: daysFromNow(0)},  // Today, kept
+		{UserID: rider.FirebaseUID, Origin: "A", Destination: "B", Date: daysFromNow(1)},
+	}
+	if err := db.Create(&searches).Error; err != nil {
+		t.Fatal(err)
+	}
+	alerts := []SavedSearchAlert{
+		{UserID: rider.FirebaseUID, RideID: 1, SavedSearchID: searches[0].ID},
+		{UserID: rider.FirebaseUID, RideID: 2, SavedSearchID: searches[1].ID},
+	}
+	if err := db.Create(&alerts).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := purgeExpiredSavedSearches(context.Background())
+	if err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if expired != 1 {
+		t.Fatalf("expired %d searches, want 1", expired)
+	}
+
+	var left []SavedSearchAlert
+	db.Find(&left)
+	if len(left) != 1 || left[0].SavedSearchID != searches[1].ID {
+		t.Fatalf("alerts left = %+v, want only today's", left)
+	}
+}
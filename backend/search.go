@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rideSearchKey identifies a search route by the places it resolved to, so every spelling
+// of the same route shares one cache entry
+type rideSearchKey struct {
+	OriginPlaceID      uint
+	DestinationPlaceID uint
+	Date               string
+}
+
+func (k rideSearchKey) String() string {
+	return strconv.FormatUint(uint64(k.OriginPlaceID), 10) + "|" + strconv.FormatUint(uint64(k.DestinationPlaceID), 10) + "|" + k.Date
+}
+
+// cachedResponse is a rendered JSON response and its ETag
+type cachedResponse struct {
+	Body []byte
+	ETag string
+}
+
+// rideSearchPages holds the cached pages of one search route, keyed by limit and cursor, so that
+// invalidating the route drops every page at once. Entries are replaced, never modified.
+type rideSearchPages map[string]cachedResponse
+
+// FilterRides results are cached per route for a few seconds. Any change to a ride on the route
+// (posted, deleted, joined or left) drops the route's pages on this instance; other instances
+// may serve the old results until RIDE_SEARCH_CACHE_TTL passes.
+var (
+	rideSearchCache    = newMeteredCache[rideSearchPages]("ride_search", NewMemoryCache[rideSearchPages](0))
+	rideSearchCacheTTL time.Duration
+
+	// Bumped on every invalidation. A search that started before a change must not cache
+	// what it read, or it could put the old results back right after they were dropped.
+	rideSearchGeneration atomic.Uint64
+)
+
+func pageCacheKey(p Pagination, rawCursor string) string {
+	if !p.Enabled {
+		return "all"
+	}
+	return strconv.Itoa(p.Limit) + "|" + rawCursor
+}
+
+// cachedRideSearch returns the cached page, if any
+func cachedRideSearch(key rideSearchKey, page string) (cachedResponse, bool) {
+	pages, ok := rideSearchCache.Get(key.String())
+	if !ok {
+		return cachedResponse{}, false
+	}
+	resp, ok := pages[page]
+	return resp, ok
+}
+
+// cacheRideSearch stores a page read while rideSearchGeneration was generation
+func cacheRideSearch(key rideSearchKey, page string, resp cachedResponse, generation uint64) {
+	if rideSearchGeneration.Load() != generation {
+		return
+	}
+
+	pages, _ := rideSearchCache.Get(key.String())
+	updated := make(rideSearchPages, len(pages)+1)
+	for k, v := range pages {
+		updated[k] = v
+	}
+	updated[page] = resp
+	rideSearchCache.Set(key.String(), updated, rideSearchCacheTTL)
+}
+
+// invalidateRideSearch drops the cached searches the ride appears in
+func invalidateRideSearch(ride Ride) {
+	rideSearchGeneration.Add(1)
+	if ride.OriginPlaceID == nil || ride.DestinationPlaceID == nil {
+		return
+	}
+	rideSearchCache.Delete(rideSearchKey{*ride.OriginPlaceID, *ride.DestinationPlaceID, ride.Date}.String())
+}
+
+// newCachedResponse renders body, tagging it with a hash of its content
+func newCachedResponse(body []byte) cachedResponse {
+	sum := sha256.Sum256(body)
+	return cachedResponse{Body: body, ETag: `"` + hex.EncodeToString(sum[:16]) + `"`}
+}
+
+// respondWithETag writes resp, or 304 Not Modified if the client already has this version.
+// Clients are told to revalidate every time, so polling costs a 304 until the results change.
+func respondWithETag(c *gin.Context, resp cachedResponse) {
+	c.Header("ETag", resp.ETag)
+	c.Header("Cache-Control", "no-cache")
+	if etagMatches(c.GetHeader("If-None-Match"), resp.ETag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", resp.Body)
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, comparing weakly as RFC 9110 requires
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// useRideSearchCache turns the ride search cache on until the test ends
+func useRideSearchCache(t *testing.T) {
+	t.Helper()
+
+	prevCache, prevTTL := rideSearchCache, rideSearchCacheTTL
+	rideSearchCache = newMeteredCache[rideSearchPages]("ride_search", NewMemoryCache[rideSearchPages](100))
+	rideSearchCacheTTL = time.Minute
+	t.Cleanup(func() { rideSearchCache, rideSearchCacheTTL = prevCache, prevTTL })
+}
+
+// searchRides serves GET /ride/filter for the route on date, sending ifNoneMatch if set
+func searchRides(t *testing.T, origin, destination, date, ifNoneMatch string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := gin.New()
+	r.Use(ErrorHandler())
+	r.GET("/ride/filter", FilterRides)
+
+	query := url.Values{"origin": {origin}, "destination": {destination}, "date": {date}}
+	req := httptest.NewRequest("GET", "/ride/filter?"+query.Encode(), nil)
+	if ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK && w.Code != http.StatusNotModified {
+		t.Fatalf("search = %d: %s", w.Code, w.Body.String())
+	}
+	return w
+}
+
+func TestEtagMatches(t *testing.T) {
+	etag := `"abc"`
+	tests := []struct {
+		ifNoneMatch string
+		want        bool
+	}{
+		{`"abc"`, true},
+		{`W/"abc"`, true}, // Weak comparison ignores the W/ prefix
+		{`"xyz", W/"abc"`, true},
+		{`*`, true},
+		{`"xyz"`, false},
+		{`abc`, false},
+		{``, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.ifNoneMatch, etag); got != tt.want {
+			t.Errorf("etagMatches(%q, %q) = %v, want %v", tt.ifNoneMatch, etag, got, tt.want)
+		}
+	}
+}
+
+func TestFilterRidesETag(t *testing.T) {
+	db := newTestDB(t)
+	useRideSearchCache(t)
+	leader := seedUser(t, db)
+	date := daysFromNow(1)
+	seedRide(t, db, leader, "Campus", "Airport", date, "09:00", 4)
+
+	first := searchRides(t, "Campus", "Airport", date, "")
+	etag := first.Header().Get("ETag")
+	if etag == "" || first.Header().Get("Cache-Control") != "no-cache" {
+		t.Fatalf("headers = %v, want an ETag to revalidate with every time", first.Header())
+	}
+
+	for _, ifNoneMatch := range []string{etag, "W/" + etag, `"other", ` + etag} {
+		if w := searchRides(t, "Campus", "Airport", date, ifNoneMatch); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Fatalf("If-None-Match %s = %d with %d bytes, want an empty 304", ifNoneMatch, w.Code, w.Body.Len())
+		}
+	}
+
+	// Another spelling of the route is the same search
+	if w := searchRides(t, "campus ", "AIRPORT", date, etag); w.Code != http.StatusNotModified {
+		t.Fatalf("respelled search = %d, want 304", w.Code)
+	}
+	if w := searchRides(t, "Campus", "Airport", date, `"stale"`); w.Code != http.StatusOK || w.Body.String() != first.Body.String() {
+		t.Fatalf("stale ETag = %d, want the results again", w.Code)
+	}
+}
+
+func TestFilterRidesCacheInvalidation(t *testing.T) {
+	db := newTestDB(t)
+	useRideSearchCache(t)
+	leader, rider := seedUser(t, db), seedUser(t, db)
+	date := daysFromNow(1)
+	ride := seedRide(t, db, leader, "Campus", "Airport", date, "09:00", 4)
+
+	// Results are served from the cache: a change made behind the handlers' back isn't seen
+	before := searchRides(t, "Campus", "Airport", date, "")
+	db.Model(&Ride{}).Where("id = ?", ride.ID).Update("price", 250)
+	if w := searchRides(t, "Campus", "Airport", date, ""); w.Body.String() != before.Body.String() {
+		t.Fatal("the second search wasn't served from the cache")
+	}
+
+	joinURL := fmt.Sprintf("/ride/%d/join-ride", ride.ID)
+	var participant Participant
+	changes := []struct {
+		name   string
+		setup  func() // Run before the results are cached
+		change func() *httptest.ResponseRecorder
+	}{
+		{"posting a ride", nil, func() *httptest.ResponseRecorder {
+			return serve(t, AddRide, "POST", "/ride", "/ride", leader.FirebaseUID,
+				Ride{Origin: "Campus", Destination: "Airport", Date: date, Time: "10:00", Seats: 3, Price: 50})
+		}},
+		{"joining", func() { seedRequest(t, db, rider, ride, "approved") }, func() *httptest.ResponseRecorder {
+			return serve(t, JoinRideWithPrivilege, "POST", "/ride/:rideID/join-ride", joinURL, rider.FirebaseUID, nil)
+		}},
+		{"leaving", nil, func() *httptest.ResponseRecorder {
+			return serve(t, CancelRideParticipation, "DELETE", "/user/cancel-ride/:rideID",
+				fmt.Sprintf("/user/cancel-ride/%d", ride.ID), rider.FirebaseUID, nil)
+		}},
+		{"removing a participant", func() {
+			seedRequest(t, db, rider, ride, "approved")
+			serve(t, JoinRideWithPrivilege, "POST", "/ride/:rideID/join-ride", joinURL, rider.FirebaseUID, nil)
+			db.Where("ride_id = ?", ride.ID).First(&participant)
+		}, func() *httptest.ResponseRecorder {
+			return serve(t, RemoveParticipant, "DELETE", "/ride/:rideID/participant/:participantID",
+				fmt.Sprintf("/ride/%d/participant/%d", ride.ID, participant.ID), leader.FirebaseUID, nil)
+		}},
+		{"deleting a ride", nil, func() *httptest.ResponseRecorder {
+			return serve(t, DeleteRide, "DELETE", "/ride/:rideID", fmt.Sprintf("/ride/%d", ride.ID), leader.FirebaseUID, nil)
+		}},
+	}
+	for _, tt := range changes {
+		if tt.setup != nil {
+			tt.setup()
+		}
+		before := searchRides(t, "Campus", "Airport", date, "")
+		if w := tt.change(); w.Code != http.StatusOK {
+			t.Fatalf("%s = %d: %s", tt.name, w.Code, w.Body.String())
+		}
+		after := searchRides(t, "Campus", "Airport", date, before.Header().Get("ETag"))
+		if after.Code != http.StatusOK || after.Body.String() == before.Body.String() {
+			t.Fatalf("after %s the search = %d with the old results, want the new ones", tt.name, after.Code)
+		}
+	}
+}
+
+func TestRideSearchSkipsCachingAfterInvalidation(t *testing.T) {
+	useRideSearchCache(t)
+	origin, destination := uint(1), uint(2)
+	ride := Ride{OriginPlaceID: &origin, DestinationPlaceID: &destination, Date: "2030-03-10"}
+	key := rideSearchKey{origin, destination, ride.Date}
+
+	// A search reads the rides, then a join changes them before the search stores what it read
+	generation := rideSearchGeneration.Load()
+	invalidateRideSearch(ride)
+	cacheRideSearch(key, "all", newCachedResponse([]byte(`[]`)), generation)
+	if _, ok := cachedRideSearch(key, "all"); ok {
+		t.Fatal("a search that overlapped a change cached its stale results")
+	}
+
+	// A search that started after the change caches as usual
+	cacheRideSearch(key, "all", newCachedResponse([]byte(`[]`)), rideSearchGeneration.Load())
+	if _, ok := cachedRideSearch(key, "all"); !ok {
+		t.Fatal("a fresh search wasn't cached")
+	}
+
+	// Invalidating drops every page of the route
+	cacheRideSearch(key, "20|", newCachedResponse([]byte(`{}`)), rideSearchGeneration.Load())
+	invalidateRideSearch(ride)
+	if _, ok := cachedRideSearch(key, "all"); ok {
+		t.Fatal("a page survived invalidation")
+	}
+	if _, ok := cachedRideSearch(key, "20|"); ok {
+		t.Fatal("a page survived invalidation")
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// tracer creates every span in the backend. Until InitTracing installs a provider it is a no-op.
+var tracer = otel.Tracer("github.com/Ansingh0305/BroCab")
+
+// InitTracing installs the trace exporter selected by OTEL_TRACES_EXPORTER. The returned function
+// flushes buffered spans and must be called on shutdown.
+//
+// The OTLP exporter is configured with the standard OTEL_EXPORTER_OTLP_* variables
+// (e.g. OTEL_EXPORTER_OTLP_ENDPOINT, OTEL_EXPORTER_OTLP_HEADERS).
+func InitTracing(cfg TracingConfig) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "otlp":
+		exporter, err = otlptracehttp.New(context.Background())
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(cfg.ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	slog.Info("✅ Tracing enabled", "exporter", cfg.Exporter, "sample_ratio", cfg.SampleRatio)
+	return provider.Shutdown, nil
+}
+
+// recordSpanError marks the span as failed
+func recordSpanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// TracingMiddleware starts a server span for each request, continuing the caller's trace if it sent
+// a traceparent header. Handlers reach the span through c.Request.Context().
+func TracingMiddleware() gin.HandlerFunc {
+	propagator := otel.GetTextMapPropagator
+
+	return func(c *gin.Context) {
+		ctx := propagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+
+		route := c.FullPath()
+		name := c.Request.Method + " " + route
+		if route == "" {
+			name = c.Request.Method
+		}
+
+		ctx, span := tracer.Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(c.Request.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(c.Request.URL.Path),
+			),
+		)
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, "")
+			if len(c.Errors) > 0 {
+				span.RecordError(c.Errors.Last().Err)
+			}
+		}
+	}
+}
+
+// gormTracingPlugin creates a client span for each GORM statement, as a child of the span in the
+// statement's context. Queries only join the request trace when run with DB.WithContext.
+type gormTracingPlugin struct{}
+
+const gormSpanKey = "tracing:span"
+
+func (gormTracingPlugin) Name() string { return "brocab:tracing" }
+
+func (gormTracingPlugin) Initialize(db *gorm.DB) error {
+	before := func(operation string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			ctx, span := tracer.Start(tx.Statement.Context, "gorm."+operation,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBOperationName(operation)),
+			)
+			setStatementContext(tx, ctx)
+			tx.InstanceSet(gormSpanKey, span)
+		}
+	}
+	after := func(string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			v, ok := tx.InstanceGet(gormSpanKey)
+			if !ok {
+				return
+			}
+			span := v.(trace.Span)
+			defer span.End()
+			restoreStatementContext(tx)
+
+			span.SetAttributes(
+				semconv.DBCollectionName(tx.Statement.Table),
+				semconv.DBQueryText(tx.Statement.SQL.String()),
+				attribute.Int64("db.rows_affected", tx.Statement.RowsAffected),
+			)
+			if err := tx.Statement.Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				recordSpanError(span, err)
+			}
+		}
+	}
+	return registerGormCallbacks(db, "tracing", before, after)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans sends the backend's spans to an in-memory recorder until the test ends
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	prevTracer, prevPropagator := tracer, otel.GetTextMapPropagator()
+	tracer = provider.Tracer("test")
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		tracer = prevTracer
+		otel.SetTextMapPropagator(prevPropagator)
+		provider.Shutdown(context.Background())
+	})
+	return recorder
+}
+
+func TestTracingSpans(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.Use(gormTracingPlugin{}); err != nil {
+		t.Fatal(err)
+	}
+	recorder := recordSpans(t)
+
+	r := gin.New()
+	r.Use(TracingMiddleware())
+	r.GET("/ride/:rideID", func(c *gin.Context) {
+		var rides []Ride
+		DB.WithContext(c.Request.Context()).Find(&rides)
+		c.Status(http.StatusInternalServerError)
+	})
+
+	// The caller's trace is continued
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest("GET", "/ride/7", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("%d spans, want the request's and its query's", len(spans))
+	}
+	query, server := spans[0], spans[1]
+	if server.Name() != "GET /ride/:rideID" || server.SpanKind() != trace.SpanKindServer {
+		t.Fatalf("server span %q (%s), want it named after the route", server.Name(), server.SpanKind())
+	}
+	if server.SpanContext().TraceID().String() != traceID || server.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Fatalf("server span in trace %s under %s, want the caller's", server.SpanContext().TraceID(), server.Parent().SpanID())
+	}
+	if server.Status().Code != codes.Error {
+		t.Fatalf("server span status %v, want an error for the 500", server.Status())
+	}
+	if query.Name() != "gorm.query" || query.SpanKind() != trace.SpanKindClient || query.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Fatalf("query span %q under %s, want gorm.query under the server span", query.Name(), query.Parent().SpanID())
+	}
+}
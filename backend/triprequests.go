@@ -0,0 +1,490 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// TripRequest is a passenger's "looking for a ride" post. Leaders browse open trip requests and
+// can answer one by offering a ride, which sends the passenger a pre-approved privilege to join it.
+//
+// Like saved searches, trip requests don't create places. A request naming a place nobody has
+// posted a ride from or to waits with no place ID until a ride creates one (see resolvePlace).
+type TripRequest struct {
+	ID                    uint      `gorm:"primaryKey" json:"id"`
+	UserID                string    `gorm:"index;not null" json:"-"` // Firebase UID of the passenger
+	Origin                string    `gorm:"not null" json:"origin"`  // As entered
+	Destination           string    `gorm:"not null" json:"destination"`
+	OriginNormalized      string    `gorm:"type:varchar(200);not null;default:''" json:"-"` // See normalizePlaceName
+	DestinationNormalized string    `gorm:"type:varchar(200);not null;default:''" json:"-"`
+	OriginPlaceID         *uint     `gorm:"index:idx_trip_requests_route,priority:1" json:"origin_place_id"` // nil until the place exists
+	DestinationPlaceID    *uint     `gorm:"index:idx_trip_requests_route,priority:2" json:"destination_place_id"`
+	Date                  string    `gorm:"index:idx_trip_requests_route,priority:3;not null" json:"date"` // e.g. "2025-06-10"
+	TimeFrom              string    `gorm:"type:varchar(5);not null;default:''" json:"time_from"`          // HH:mm, empty for any time
+	TimeTo                string    `gorm:"type:varchar(5);not null;default:''" json:"time_to"`            // HH:mm, empty for any time
+	SeatsNeeded           int       `gorm:"not null;default:1" json:"seats_needed"`
+	Status                string    `gorm:"type:varchar(20);not null;index" json:"status"` // "open", "offered" or "cancelled"
+	RideID                *uint     `json:"ride_id"`                                       // Ride offered in answer, once "offered"
+	CreatedAt             time.Time `json:"created_at"`
+	UpdatedAt             time.Time `json:"updated_at"`
+}
+
+// TripRequestResponse is an open trip request as shown to leaders browsing them
+type TripRequestResponse struct {
+	ID              uint      `json:"id"`
+	Origin          string    `json:"origin"`
+	Destination     string    `json:"destination"`
+	Date            string    `json:"date"`
+	TimeFrom        string    `json:"time_from"`
+	TimeTo          string    `json:"time_to"`
+	SeatsNeeded     int       `json:"seats_needed"`
+	PassengerName   string    `json:"passenger_name"`
+	PassengerGender string    `json:"passenger_gender"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// Request body struct for posting a trip request
+type CreateTripRequestRequest struct {
+	Origin      string `json:"origin" binding:"required"`
+	Destination string `json:"destination" binding:"required"`
+	Date        string `json:"date" binding:"required"` // YYYY-MM-DD
+	TimeFrom    string `json:"time_from"`               // HH:mm, optional
+	TimeTo      string `json:"time_to"`                 // HH:mm, optional
+	SeatsNeeded int    `json:"seats_needed"`            // Defaults to 1
+}
+
+// OfferRideResponse is the ride offered for a trip request and the passenger's pre-approved join request
+type OfferRideResponse struct {
+	Message   string `json:"message"`
+	Ride      Ride   `json:"ride"`
+	RequestID uint   `json:"request_id"`
+}
+
+// Request body struct for offering a ride in answer to a trip request.
+// The ride takes its route and date from the trip request.
+type OfferRideRequest struct {
+	Time  string  `json:"time" binding:"required"` // HH:mm, within the trip request's window
+	Seats int     `json:"seats" binding:"required,min=1"`
+	Price float64 `json:"price" binding:"min=0"`
+}
+
+const maxSeatsNeeded = 8
+
+// attachWaitingTripRequests gives a newly created place to the upcoming open trip requests
+// waiting for a place whose names resolve to it
+func attachWaitingTripRequests(db *gorm.DB, place Place) error {
+	today := time.Now().Format("2006-01-02")
+	return attachWaitingRows(db.Model(&TripRequest{}).Where("status = ? AND date >= ?", "open", today), place)
+}
+
+// tripRequestCursor is the keyset position of a trip request in browse order
+func tripRequestCursor(t TripRequest) pageCursor {
+	return pageCursor{ID: t.ID, Keys: []string{t.Date, t.TimeFrom}}
+}
+
+// POST /trip-request - Post a "looking for a ride" request
+func CreateTripRequest(c *gin.Context) {
+	ctx := c.Request.Context()
+	db := DB.WithContext(ctx)
+
+	userID := c.MustGet("uid").(string)
+
+	var req CreateTripRequestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request data")
+		return
+	}
+	if err := validateTripWindow(req.Date, req.TimeFrom, req.TimeTo); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	if normalizePlaceName(req.Origin) == "" || normalizePlaceName(req.Destination) == "" {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Origin and destination are required")
+		return
+	}
+	if req.SeatsNeeded == 0 {
+		req.SeatsNeeded = 1
+	}
+	if req.SeatsNeeded < 1 || req.SeatsNeeded > maxSeatsNeeded {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("seats_needed must be between 1 and %d", maxSeatsNeeded))
+		return
+	}
+
+	user, err := getUser(ctx, userID)
+	if err != nil {
+		abortWithError(c, http.StatusNotFound, CodeUserNotFound, "User not found")
+		return
+	}
+
+	// A passenger who leads a ride that day cannot also ride with someone else
+	existingRideCount, err := countSameDayRides(db, user.ID, req.Date)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to check existing rides")
+		return
+	}
+	if existingRideCount > 0 {
+		abortWithAPIError(c, &APIError{
+			Status:  http.StatusConflict,
+			Code:    CodeSameDayConflict,
+			Message: "You cannot request a ride and create a ride on the same day. You have already created a ride for " + req.Date,
+			Details: map[string]interface{}{"date": req.Date},
+		})
+		return
+	}
+
+	var openCount int64
+	if err := db.Model(&TripRequest{}).Where("user_id = ? AND date = ? AND status = ?", userID, req.Date, "open").
+		Count(&openCount).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to check existing trip requests")
+		return
+	}
+	if openCount > 0 {
+		abortWithError(c, http.StatusConflict, CodeTripRequestExists, "You already have an open trip request for "+req.Date)
+		return
+	}
+
+	// Places are looked up, not created; only a ride posted there creates one
+	trip := TripRequest{
+		UserID:                userID,
+		Origin:                displayPlaceName(req.Origin),
+		Destination:           displayPlaceName(req.Destination),
+		OriginNormalized:      normalizePlaceName(req.Origin),
+		DestinationNormalized: normalizePlaceName(req.Destination),
+		Date:                  req.Date,
+		TimeFrom:              req.TimeFrom,
+		TimeTo:                req.TimeTo,
+		SeatsNeeded:           req.SeatsNeeded,
+		Status:                "open",
+	}
+	origin, err := findPlace(db, req.Origin)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to resolve origin and destination")
+		return
+	}
+	destination, err := findPlace(db, req.Destination)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to resolve origin and destination")
+		return
+	}
+	if origin != nil {
+		trip.OriginPlaceID = &origin.ID
+	}
+	if destination != nil {
+		trip.DestinationPlaceID = &destination.ID
+	}
+	if err := db.Create(&trip).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to create trip request")
+		return
+	}
+
+	c.JSON(http.StatusOK, trip)
+}
+
+// GET /trip-requests?origin=College Campus&destination=City Airport&date=2025-06-10&limit=20&cursor=... - Browse open trip requests, soonest first
+func GetOpenTripRequests(c *gin.Context) {
+	ctx := c.Request.Context()
+	db := DB.WithContext(ctx)
+
+	userID := c.MustGet("uid").(string)
+
+	page, err := parsePagination(c, "open_trip_requests", 2)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	today := time.Now().Format("2006-01-02")
+	query := db.Where("status = ? AND date >= ? AND user_id <> ?", "open", today, userID)
+
+	// Route and date filters are optional. An end naming a place matches trip requests filed under
+	// it; one naming no place matches those still waiting for a place under the same spelling.
+	if origin := c.Query("origin"); origin != "" {
+		placeID, err := findPlaceID(ctx, origin)
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to resolve origin")
+			return
+		}
+		if placeID != 0 {
+			query = query.Where("origin_place_id = ?", placeID)
+		} else {
+			query = query.Where("origin_place_id IS NULL AND origin_normalized = ?", normalizePlaceName(origin))
+		}
+	}
+	if destination := c.Query("destination"); destination != "" {
+		placeID, err := findPlaceID(ctx, destination)
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to resolve destination")
+			return
+		}
+		if placeID != 0 {
+			query = query.Where("destination_place_id = ?", placeID)
+		} else {
+			query = query.Where("destination_place_id IS NULL AND destination_normalized = ?", normalizePlaceName(destination))
+		}
+	}
+	if date := c.Query("date"); date != "" {
+		query = query.Where("date = ?", date)
+	}
+
+	query = query.Order("date, time_from, id")
+	if page.After != nil {
+		query = query.Where("(date, time_from, id) > (?, ?, ?)", page.After.Keys[0], page.After.Keys[1], page.After.ID)
+	}
+
+	var trips []TripRequest
+	if err := page.limitQuery(query).Find(&trips).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to fetch trip requests")
+		return
+	}
+	trips, nextCursor := pageRows(page, trips, tripRequestCursor)
+
+	uids := make([]string, 0, len(trips))
+	for _, t := range trips {
+		uids = append(uids, t.UserID)
+	}
+	passengers, err := getUsersByFirebaseUIDs(ctx, uids)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to fetch passengers")
+		return
+	}
+
+	response := make([]TripRequestResponse, 0, len(trips))
+	for _, t := range trips {
+		passenger := passengers[t.UserID]
+		response = append(response, TripRequestResponse{
+			ID:              t.ID,
+			Origin:          t.Origin,
+			Destination:     t.Destination,
+			Date:            t.Date,
+			TimeFrom:        t.TimeFrom,
+			TimeTo:          t.TimeTo,
+			SeatsNeeded:     t.SeatsNeeded,
+			PassengerName:   passenger.Name,
+			PassengerGender: passenger.Gender,
+			CreatedAt:       t.CreatedAt,
+		})
+	}
+
+	respondList(c, page, response, nextCursor)
+}
+
+// GET /user/trip-requests?limit=20&cursor=... - List the user's own trip requests, newest first
+func GetUserTripRequests(c *gin.Context) {
+	db := DB.WithContext(c.Request.Context())
+
+	userID := c.MustGet("uid").(string)
+
+	page, err := parsePagination(c, "user_trip_requests", 1)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	query := db.Where("user_id = ?", userID).Order("date DESC, id DESC")
+	if page.After != nil {
+		query = query.Where("(date, id) < (?, ?)", page.After.Keys[0], page.After.ID)
+	}
+
+	trips := []TripRequest{}
+	if err := page.limitQuery(query).Find(&trips).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to fetch trip requests")
+		return
+	}
+	trips, nextCursor := pageRows(page, trips, func(t TripRequest) pageCursor {
+		return pageCursor{ID: t.ID, Keys: []string{t.Date}}
+	})
+
+	respondList(c, page, trips, nextCursor)
+}
+
+// DELETE /trip-request/:tripRequestID - Withdraw an open trip request
+func CancelTripRequest(c *gin.Context) {
+	db := DB.WithContext(c.Request.Context())
+
+	tripRequestID := c.Param("tripRequestID")
+	userID := c.MustGet("uid").(string)
+
+	result := db.Model(&TripRequest{}).
+		Where("id = ? AND user_id = ? AND status = ?", tripRequestID, userID, "open").
+		Updates(map[string]interface{}{"status": "cancelled", "updated_at": time.Now()})
+	if result.Error != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to cancel trip request")
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		abortWithError(c, http.StatusNotFound, CodeTripRequestNotFound, "Trip request not found or no longer open")
+		return
+	}
+
+	c.JSON(http.StatusOK, MessageResponse{Message: "Trip request cancelled"})
+}
+
+// POST /trip-request/:tripRequestID/offer - Offer a ride for a trip request; the passenger gets a privilege to join it
+func OfferRideForTripRequest(c *gin.Context) {
+	ctx := c.Request.Context()
+	db := DB.WithContext(ctx)
+
+	tripRequestID, err := strconv.Atoi(c.Param("tripRequestID"))
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid trip request ID")
+		return
+	}
+
+	userID := c.MustGet("uid").(string)
+
+	var req OfferRideRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid input: "+err.Error())
+		return
+	}
+	if _, err := time.Parse("15:04", req.Time); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid time format, expected HH:mm")
+		return
+	}
+
+	leader, err := getUser(ctx, userID)
+	if err != nil {
+		abortWithError(c, http.StatusNotFound, CodeUserNotFound, "User not found")
+		return
+	}
+
+	var trip TripRequest
+	if err := db.Where("id = ? AND status = ?", tripRequestID, "open").First(&trip).Error; err != nil {
+		abortWithError(c, http.StatusNotFound, CodeTripRequestNotFound, "Trip request not found or no longer open")
+		return
+	}
+
+	if trip.UserID == userID {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "You cannot offer a ride for your own trip request")
+		return
+	}
+	if trip.Date < time.Now().Format("2006-01-02") {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "The trip request's date has passed")
+		return
+	}
+	if !inTimeWindow(req.Time, trip.TimeFrom, trip.TimeTo) {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "time must be within the trip request's time window")
+		return
+	}
+	if req.Seats < trip.SeatsNeeded {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("The passenger needs %d seats", trip.SeatsNeeded))
+		return
+	}
+
+	// The same-day rule of AddRide applies to the leader...
+	existingRequestCount, err := countSameDayRequests(db, userID, trip.Date)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to check existing requests")
+		return
+	}
+	if existingRequestCount > 0 {
+		abortWithAPIError(c, &APIError{
+			Status:  http.StatusConflict,
+			Code:    CodeSameDayConflict,
+			Message: "You cannot create a ride and send join requests on the same day. You have already sent requests for rides on " + trip.Date,
+			Details: map[string]interface{}{"date": trip.Date},
+		})
+		return
+	}
+
+	// ...and the rule of SendJoinRequest to the passenger, who may have created a ride since posting
+	passenger, err := getUser(ctx, trip.UserID)
+	if err != nil {
+		abortWithError(c, http.StatusNotFound, CodeUserNotFound, "Passenger not found")
+		return
+	}
+	passengerRideCount, err := countSameDayRides(db, passenger.ID, trip.Date)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to check existing rides")
+		return
+	}
+	if passengerRideCount > 0 {
+		abortWithAPIError(c, &APIError{
+			Status:  http.StatusConflict,
+			Code:    CodeSameDayConflict,
+			Message: "The passenger has since created a ride for " + trip.Date,
+			Details: map[string]interface{}{"date": trip.Date},
+		})
+		return
+	}
+
+	ride := Ride{
+		LeaderID:    leader.ID,
+		Origin:      trip.Origin,
+		Destination: trip.Destination,
+		Date:        trip.Date,
+		Time:        req.Time,
+		Seats:       req.Seats,
+		Price:       req.Price,
+	}
+
+	// Create the ride, close the trip request and approve the passenger in one transaction
+	tx := db.Begin()
+	if tx.Error != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to start transaction")
+		return
+	}
+
+	// The passenger hears about the ride through the offer, not their saved searches
+	if _, err := insertRide(tx, &ride, userID, trip.UserID); err != nil {
+		tx.Rollback()
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Could not save ride: "+err.Error())
+		return
+	}
+
+	// Only one leader can answer a trip request; whoever closes it first wins
+	result := tx.Model(&TripRequest{}).Where("id = ? AND status = ?", trip.ID, "open").
+		Updates(map[string]interface{}{"status": "offered", "ride_id": ride.ID, "updated_at": time.Now()})
+	if result.Error != nil {
+		tx.Rollback()
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to update trip request")
+		return
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		abortWithError(c, http.StatusConflict, CodeTripRequestClosed, "Another leader has already answered this trip request")
+		return
+	}
+
+	// The pre-approved join request is the passenger's privilege to join the ride
+	request := Request{
+		RideID: ride.ID,
+		UserID: trip.UserID,
+		Status: "approved",
+	}
+	if err := tx.Create(&request).Error; err != nil {
+		tx.Rollback()
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to create privilege")
+		return
+	}
+
+	title := "Ride Offered"
+	message := fmt.Sprintf("%s is offering a ride from %s to %s on %s at %s for your trip request. You can now join the ride!",
+		leader.Name, ride.Origin, ride.Destination, ride.Date, ride.Time)
+	if err := createNotification(tx, trip.UserID, title, message, "trip_offer", ride); err != nil {
+		tx.Rollback()
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to create notification")
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to commit transaction")
+		return
+	}
+	wakeNotificationOutbox()
+	ridesCreatedTotal.Inc()
+	invalidateRideSearch(ride)
+
+	c.JSON(http.StatusOK, OfferRideResponse{
+		Message:   "Ride offered - the passenger can now join it",
+		Ride:      ride,
+		RequestID: request.ID,
+	})
+}
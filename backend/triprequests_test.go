@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func createTripRequest(t *testing.T, user *User, req CreateTripRequestRequest) TripRequest {
+	t.Helper()
+
+	var trip TripRequest
+	w := serve(t, CreateTripRequest, "POST", "/trip-request", "/trip-request", user.FirebaseUID, req)
+	decodeJSON(t, w, http.StatusOK, &trip)
+	return trip
+}
+
+func offerRide(t *testing.T, leader *User, trip TripRequest, want int) APIError {
+	t.Helper()
+
+	var body APIError
+	path := fmt.Sprintf("/trip-request/%d/offer", trip.ID)
+	w := serve(t, OfferRideForTripRequest, "POST", "/trip-request/:tripRequestID/offer", path, leader.FirebaseUID,
+		OfferRideRequest{Time: "09:00", Seats: 3, Price: 80})
+	decodeJSON(t, w, want, &body)
+	return body
+}
+
+func TestTripRequestWaitsForRideToCreatePlaces(t *testing.T) {
+	db := newTestDB(t)
+	leader, passenger := seedUser(t, db), seedUser(t, db)
+	date := daysFromNow(1)
+
+	trip := createTripRequest(t, passenger, CreateTripRequestRequest{Origin: "Colege Campus", Destination: "Airport", Date: date})
+	if trip.OriginPlaceID != nil || trip.DestinationPlaceID != nil {
+		t.Fatalf("trip request = %+v, want no places yet", trip)
+	}
+	var places int64
+	db.Model(&Place{}).Count(&places)
+	if places != 0 {
+		t.Fatalf("posting a trip request created %d places", places)
+	}
+
+	// The first ride on the route creates the places, and the misspelt origin resolves to one of them
+	ride := seedRide(t, db, leader, "College Campus", "Airport", daysFromNow(3), "09:00", 3)
+	var got TripRequest
+	db.First(&got, trip.ID)
+	if got.OriginPlaceID == nil || *got.OriginPlaceID != *ride.OriginPlaceID ||
+		got.DestinationPlaceID == nil || *got.DestinationPlaceID != *ride.DestinationPlaceID {
+		t.Fatalf("trip request = %+v, want the ride's places", got)
+	}
+}
+
+func TestBrowseTripRequestsByRoute(t *testing.T) {
+	db := newTestDB(t)
+	leader, passenger, other := seedUser(t, db), seedUser(t, db), seedUser(t, db)
+	date := daysFromNow(1)
+
+	seedRide(t, db, leader, "College Campus", "Airport", daysFromNow(3), "09:00", 3)
+	placed := createTripRequest(t, passenger, CreateTripRequestRequest{Origin: "College Campus", Destination: "Airport", Date: date})
+	waiting := createTripRequest(t, other, CreateTripRequestRequest{Origin: "Lake View", Destination: "Airport", Date: date})
+
+	tests := []struct {
+		name  string
+		query string
+		want  []uint
+	}{
+		{"every open request", "", []uint{placed.ID, waiting.ID}},
+		{"a known place", "?origin=college-campus", []uint{placed.ID}},
+		{"a misspelt known place", "?origin=Colege+Campus", []uint{placed.ID}},
+		{"a place waiting for a ride", "?origin=lake+view", []uint{waiting.ID}},
+		{"an unknown place", "?origin=Railway+Station", nil},
+		{"a known destination", "?destination=Airport", []uint{placed.ID, waiting.ID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var trips []TripRequestResponse
+			w := serve(t, GetOpenTripRequests, "GET", "/trip-requests", "/trip-requests"+tt.query, leader.FirebaseUID, nil)
+			decodeJSON(t, w, http.StatusOK, &trips)
+
+			var ids []uint
+			for _, trip := range trips {
+				ids = append(ids, trip.ID)
+			}
+			if fmt.Sprint(ids) != fmt.Sprint(tt.want) {
+				t.Fatalf("trip requests = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestOfferRideForClosedTripRequest(t *testing.T) {
+	db := newTestDB(t)
+	leader, passenger := seedUser(t, db), seedUser(t, db)
+	trip := createTripRequest(t, passenger, CreateTripRequestRequest{Origin: "College Campus", Destination: "Airport", Date: daysFromNow(1)})
+
+	offerRide(t, leader, trip, http.StatusOK)
+
+	// Once answered the trip request is gone for other leaders
+	if body := offerRide(t, seedUser(t, db), trip, http.StatusNotFound); body.Code != CodeTripRequestNotFound {
+		t.Fatalf("code = %s, want %s", body.Code, CodeTripRequestNotFound)
+	}
+}
+
+func TestOfferRideRace(t *testing.T) {
+	db := newTestDB(t)
+	leader, passenger := seedUser(t, db), seedUser(t, db)
+	trip := createTripRequest(t, passenger, CreateTripRequestRequest{Origin: "College Campus", Destination: "Airport", Date: daysFromNow(1)})
+
+	// Another leader's offer commits between this one reading the trip request and closing it
+	raced := false
+	if err := db.Callback().Update().Before("gorm:update").Register("test_race", func(tx *gorm.DB) {
+		if tx.Statement.Table != "trip_requests" || raced {
+			return
+		}
+		raced = true
+		tx.Session(&gorm.Session{NewDB: true}).Exec("UPDATE trip_requests SET status = 'offered' WHERE id = ?", trip.ID)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if body := offerRide(t, leader, trip, http.StatusConflict); body.Code != CodeTripRequestClosed {
+		t.Fatalf("code = %s, want %s", body.Code, CodeTripRequestClosed)
+	}
+	if !raced {
+		t.Fatal("the race never ran")
+	}
+
+	// The losing offer left nothing behind
+	var rides, approved, offers int64
+	db.Model(&Ride{}).Count(&rides)
+	db.Model(&Request{}).Where("status = ?", "approved").Count(&approved)
+	db.Model(&NotificationOutbox{}).Where("type = ?", "trip_offer").Count(&offers)
+	if rides != 0 || approved != 0 || offers != 0 {
+		t.Fatalf("%d rides, %d approved requests and %d offers left, want none", rides, approved, offers)
+	}
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -20,23 +22,68 @@ type User struct {
 	UpdatedAt   time.Time `json:"updated_at"`
 }
 
-func getUser(uid interface{}) (*User, error) { //
+// UserBasicResponse is the public view of a user
+type UserBasicResponse struct {
+	Name   string `json:"name"`
+	Gender string `json:"gender"`
+}
+
+// RideLeaderResponse is a ride leader's contact details
+type RideLeaderResponse struct {
+	Name        string `json:"name"`
+	Gender      string `json:"gender"`
+	PhoneNumber string `json:"phone"`
+}
+
+func getUser(ctx context.Context, uid interface{}) (*User, error) { //
+	var key string
+	switch v := uid.(type) {
+	case string:
+		key = userCacheKeyUID(v)
+	case uint:
+		key = userCacheKeyID(v)
+	default:
+		return nil, fmt.Errorf("invalid uid type")
+	}
+	if user, ok := userCache.Get(key); ok {
+		return &user, nil
+	}
+
 	var user User
 	switch v := uid.(type) {
 	case string:
-		if err := DB.Where("firebase_uid = ?", v).First(&user).Error; err != nil {
+		if err := DB.WithContext(ctx).Where("firebase_uid = ?", v).First(&user).Error; err != nil {
 			return nil, err
 		}
 	case uint:
-		if err := DB.First(&user, v).Error; err != nil {
+		if err := DB.WithContext(ctx).First(&user, v).Error; err != nil {
 			return nil, err
 		}
-	default:
-		return nil, fmt.Errorf("invalid uid type")
 	}
+	cacheUser(user)
 	return &user, nil
 }
 
+// Users are read on nearly every request, so they are cached by Firebase UID and by ID.
+// UpdateCurrentUser drops both entries; on other instances USER_CACHE_TTL bounds how stale they get.
+var (
+	userCache    = newMeteredCache[User]("user", NewMemoryCache[User](0))
+	userCacheTTL time.Duration
+)
+
+func userCacheKeyUID(firebaseUID string) string { return "uid:" + firebaseUID }
+func userCacheKeyID(id uint) string             { return "id:" + strconv.FormatUint(uint64(id), 10) }
+
+func cacheUser(user User) {
+	userCache.Set(userCacheKeyUID(user.FirebaseUID), user, userCacheTTL)
+	userCache.Set(userCacheKeyID(user.ID), user, userCacheTTL)
+}
+
+func forgetUser(user User) {
+	userCache.Delete(userCacheKeyUID(user.FirebaseUID))
+	userCache.Delete(userCacheKeyID(user.ID))
+}
+
 // Request body struct for creating user
 type CreateUserRequest struct {
 	Name   string `json:"name" binding:"required"`
@@ -54,15 +101,17 @@ type UpdateUserRequest struct {
 
 // GET /user - Get current user's profile
 func GetCurrentUser(c *gin.Context) {
+	ctx := c.Request.Context()
+
 	firebaseUID, exists := c.Get("uid")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		abortWithError(c, http.StatusUnauthorized, CodeUnauthenticated, "Unauthorized")
 		return
 	}
 
-	user, err := getUser(firebaseUID.(string))
+	user, err := getUser(ctx, firebaseUID.(string))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		abortWithError(c, http.StatusNotFound, CodeUserNotFound, "User not found")
 		return
 	}
 
@@ -73,17 +122,17 @@ func GetCurrentUser(c *gin.Context) {
 func CreateUser(c *gin.Context) {
 	var req CreateUserRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request data")
 		return
 	}
 
 	firebaseUID, exists := c.Get("uid")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		abortWithError(c, http.StatusUnauthorized, CodeUnauthenticated, "Unauthorized")
 		return
 	}
 
-	db := DB
+	db := DB.WithContext(c.Request.Context())
 
 	var user User
 	result := db.Where("firebase_uid = ?", firebaseUID).First(&user)
@@ -92,7 +141,7 @@ func CreateUser(c *gin.Context) {
 		return
 	}
 	if result.Error != nil && result.Error != gorm.ErrRecordNotFound {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Database error")
 		return
 	}
 
@@ -107,7 +156,7 @@ func CreateUser(c *gin.Context) {
 	}
 
 	if err := db.Create(&newUser).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to create user")
 		return
 	}
 
@@ -116,22 +165,25 @@ func CreateUser(c *gin.Context) {
 
 // PUT /user - Update current user's profile
 func UpdateCurrentUser(c *gin.Context) {
+	ctx := c.Request.Context()
+	db := DB.WithContext(ctx)
+
 	firebaseUID, exists := c.Get("uid")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		abortWithError(c, http.StatusUnauthorized, CodeUnauthenticated, "Unauthorized")
 		return
 	}
 
 	var req UpdateUserRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request data")
 		return
 	}
 
 	// Get current user
-	user, err := getUser(firebaseUID.(string))
+	user, err := getUser(ctx, firebaseUID.(string))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		abortWithError(c, http.StatusNotFound, CodeUserNotFound, "User not found")
 		return
 	}
 
@@ -149,15 +201,16 @@ func UpdateCurrentUser(c *gin.Context) {
 	updates["updated_at"] = time.Now()
 
 	// Perform update
-	if err := DB.Model(user).Updates(updates).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+	if err := db.Model(user).Updates(updates).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to update user")
 		return
 	}
+	forgetUser(*user)
 
 	// Return updated user
-	updatedUser, err := getUser(firebaseUID.(string))
+	updatedUser, err := getUser(ctx, firebaseUID.(string))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve updated user"})
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve updated user")
 		return
 	}
 
@@ -166,18 +219,17 @@ func UpdateCurrentUser(c *gin.Context) {
 
 // GET /user/:userID
 func GetUserBasic(c *gin.Context) {
+	ctx := c.Request.Context()
+
 	userID := c.Param("userID")
 
-	user, err := getUser(userID)
+	user, err := getUser(ctx, userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		abortWithError(c, http.StatusNotFound, CodeUserNotFound, "User not found")
 		return
 	}
 
-	response := struct {
-		Name   string `json:"name"`
-		Gender string `json:"gender"`
-	}{
+	response := UserBasicResponse{
 		Name:   user.Name,
 		Gender: user.Gender,
 	}
@@ -187,25 +239,23 @@ func GetUserBasic(c *gin.Context) {
 
 // GET /ride/:rideID/leader
 func GetRideLeader(c *gin.Context) {
+	db := DB.WithContext(c.Request.Context())
+
 	rideID := c.Param("rideID")
 
 	var ride Ride
-	if err := DB.First(&ride, "id = ?", rideID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Ride not found"})
+	if err := db.First(&ride, "id = ?", rideID).Error; err != nil {
+		abortWithError(c, http.StatusNotFound, CodeRideNotFound, "Ride not found")
 		return
 	}
 
 	var leader User
-	if err := DB.First(&leader, "id = ?", ride.LeaderID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Leader not found"})
+	if err := db.First(&leader, "id = ?", ride.LeaderID).Error; err != nil {
+		abortWithError(c, http.StatusNotFound, CodeLeaderNotFound, "Leader not found")
 		return
 	}
 
-	response := struct {
-		Name        string `json:"name"`
-		Gender      string `json:"gender"`
-		PhoneNumber string `json:"phone"`
-	}{
+	response := RideLeaderResponse{
 		Name:        leader.Name,
 		Gender:      leader.Gender,
 		PhoneNumber: leader.Phone,
@@ -215,10 +265,66 @@ func GetRideLeader(c *gin.Context) {
 }
 
 // Helper function to get user by database ID and return Firebase UID
-func getUserByID(userID uint) (*User, error) {
-	var user User
-	if err := DB.First(&user, "id = ?", userID).Error; err != nil {
+func getUserByID(ctx context.Context, userID uint) (*User, error) {
+	return getUser(ctx, userID)
+}
+
+// getUsersByFirebaseUIDs loads all users for the given Firebase UIDs in one query, keyed by UID
+func getUsersByFirebaseUIDs(ctx context.Context, uids []string) (map[string]User, error) {
+	users := make(map[string]User, len(uids))
+	if len(uids) == 0 {
+		return users, nil
+	}
+
+	var missing []string
+	for _, uid := range uids {
+		if u, ok := userCache.Get(userCacheKeyUID(uid)); ok {
+			users[uid] = u
+		} else {
+			missing = append(missing, uid)
+		}
+	}
+	if len(missing) == 0 {
+		return users, nil
+	}
+
+	var rows []User
+	if err := DB.WithContext(ctx).Where("firebase_uid IN ?", missing).Find(&rows).Error; err != nil {
 		return nil, err
 	}
-	return &user, nil
+	for _, u := range rows {
+		users[u.FirebaseUID] = u
+		cacheUser(u)
+	}
+	return users, nil
+}
+
+// getUsersByIDs loads all users for the given database IDs in one query, keyed by ID
+func getUsersByIDs(ctx context.Context, ids []uint) (map[uint]User, error) {
+	users := make(map[uint]User, len(ids))
+	if len(ids) == 0 {
+		return users, nil
+	}
+
+	var missing []uint
+	for _, id := range ids {
+		if u, ok := userCache.Get(userCacheKeyID(id)); ok {
+			users[id] = u
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) == 0 {
+		return users, nil
+	}
+
+	var rows []User
+	if err := DB.WithContext(ctx).Where("id IN ?", missing).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, u := range rows {
+		users[u.ID] = u
+		cacheUser(u)
+	}
+	return users, nil
 }
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// Background workers run until StopWorkers cancels workersCtx. Each checks the context
+// between runs, so shutdown waits for the run in progress instead of cutting it off mid-transaction.
+var (
+	workersCtx, cancelWorkers = context.WithCancel(context.Background())
+	workersWG                 sync.WaitGroup
+)
+
+// runWorker starts fn in the background; fn must return once ctx is done
+func runWorker(fn func(ctx context.Context)) {
+	workersWG.Add(1)
+	go func() {
+		defer workersWG.Done()
+		fn(workersCtx)
+	}()
+}
+
+// StopWorkers tells every worker to stop and waits for them, or until ctx expires
+func StopWorkers(ctx context.Context) error {
+	cancelWorkers()
+
+	done := make(chan struct{})
+	go func() {
+		workersWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}